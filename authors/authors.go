@@ -0,0 +1,324 @@
+package authors
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+	"gopkg.in/yaml.v3"
+)
+
+//=== Bindings for tests
+
+var ReadFileFunc = os.ReadFile
+
+var WriteFileFunc = os.WriteFile
+
+//=== Bindings for tests
+
+// IndexSuffix is appended to the destination directory to form the default path of its authors index.
+const IndexSuffix = ".authors.json"
+
+// IndexPath returns the default authors index path of destination.
+func IndexPath(destination string) string {
+	return filepath.Clean(destination) + IndexSuffix
+}
+
+// Author is one person, whichever of their URLs and photos their mentions came with.
+type Author struct {
+	ID string `json:"id"`
+	// Name, Photo and URL are taken from the author's latest mention, at Updated.
+	Name    string    `json:"name,omitempty"`
+	Photo   string    `json:"photo,omitempty"`
+	URL     string    `json:"url,omitempty"`
+	Updated time.Time `json:"updated"`
+	// Aliases are the identity keys resolving to the author, see Keys.
+	Aliases []string `json:"aliases"`
+	// MergedIDs are the former IDs of the author, from identities merged into it or renamed by an alias mapping.
+	MergedIDs []string `json:"merged-ids,omitempty"`
+}
+
+// Alias maps the URLs one person posts from to a single author, like the rel=me links of their site. ID, when set,
+// replaces the generated author ID.
+type Alias struct {
+	ID   string   `yaml:"id"`
+	URLs []string `yaml:"urls"`
+}
+
+type aliasFile struct {
+	Authors []Alias `yaml:"authors"`
+}
+
+// LoadAliases reads the YAML file of alias mappings at path.
+func LoadAliases(path string) ([]Alias, error) {
+	data, err := ReadFileFunc(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read author aliases: %w", err)
+	}
+	var file aliasFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("cannot parse author aliases: %w", err)
+	}
+	for i, alias := range file.Authors {
+		if len(alias.URLs) == 0 {
+			return nil, fmt.Errorf("author alias %d has no urls", i+1)
+		}
+	}
+	return file.Authors, nil
+}
+
+// LoadIndex reads the authors index at path. A missing index is empty.
+func LoadIndex(path string) ([]*Author, error) {
+	data, err := ReadFileFunc(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read authors index: %w", err)
+	}
+	var authors []*Author
+	if err := json.Unmarshal(data, &authors); err != nil {
+		return nil, fmt.Errorf("cannot parse authors index: %w", err)
+	}
+	return authors, nil
+}
+
+// CanonicalURL reduces an author URL to the host and path identifying the author: the scheme, "www.", the query
+// and a trailing slash are dropped, Mastodon's /users/name becomes /@name and x.com becomes twitter.com.
+func CanonicalURL(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return strings.TrimSuffix(strings.ToLower(rawURL), "/")
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	path := strings.TrimSuffix(parsed.EscapedPath(), "/")
+	switch host {
+	case "x.com", "mobile.twitter.com", "mobile.x.com":
+		host = "twitter.com"
+	}
+	if host == "twitter.com" {
+		path = strings.ToLower(path)
+	}
+	if name, ok := strings.CutPrefix(path, "/users/"); ok && name != "" && !strings.Contains(name, "/") {
+		path = "/@" + name
+	}
+	return host + path
+}
+
+// genericPhoto matches the placeholder photos services give everyone without one, which don't identify anybody.
+var genericPhoto = regexp.MustCompile(`(?i)(missing|default|blank|placeholder|avatar-none)[^/]*$`)
+
+// Keys returns the identity keys of mention's author, the first being the strongest:
+//   - url:<canonical URL> for the author URL
+//   - name:<name>@<source host> for authors known only by name, who are told apart by the site they post from
+//   - card:<name> <photo> for the same name with the same, non-placeholder photo
+func Keys(mention webmention.Mention) []string {
+	var keys []string
+	name := strings.ToLower(strings.Join(strings.Fields(mention.Author.Name), " "))
+	if author := CanonicalURL(mention.Author.URL); author != "" {
+		keys = append(keys, "url:"+author)
+	} else if name != "" {
+		host := ""
+		if source, err := url.Parse(mention.WMSource); err == nil {
+			host = strings.TrimPrefix(strings.ToLower(source.Hostname()), "www.")
+		}
+		keys = append(keys, "name:"+name+"@"+host)
+	}
+	if photo := strings.TrimSpace(mention.Author.Photo); name != "" && photo != "" && !genericPhoto.MatchString(photo) {
+		keys = append(keys, "card:"+name+" "+photo)
+	}
+	return keys
+}
+
+// Registry resolves the authors of mentions to stable IDs, merging the identities that turn out to be one person:
+// those sharing a key, or listed in one alias mapping. It is a webmention.Stage setting each mention's author-id.
+// Private mentions are skipped, so their authors never appear in the index.
+type Registry struct {
+	mu      sync.Mutex
+	authors []*Author
+	byKey   map[string]*Author
+	// aliases maps the keys of the configured alias mappings to their mapping, and aliasKeys a mapping to its keys.
+	aliases   map[string]*Alias
+	aliasKeys map[*Alias][]string
+	changed   bool
+}
+
+// NewRegistry returns a registry of authors, as read from an index, resolving identities with aliases.
+func NewRegistry(authors []*Author, aliases []Alias) *Registry {
+	registry := &Registry{authors: authors, byKey: map[string]*Author{}, aliases: map[string]*Alias{},
+		aliasKeys: map[*Alias][]string{}}
+	for _, author := range authors {
+		for _, key := range author.Aliases {
+			registry.byKey[key] = author
+		}
+	}
+	for i := range aliases {
+		alias := &aliases[i]
+		for _, aliasURL := range alias.URLs {
+			key := "url:" + CanonicalURL(aliasURL)
+			registry.aliases[key] = alias
+			registry.aliasKeys[alias] = append(registry.aliasKeys[alias], key)
+		}
+	}
+	return registry
+}
+
+func (r *Registry) Process(mention webmention.Mention) (webmention.Mention, webmention.Verdict) {
+	if mention.WMPrivate {
+		return mention, webmention.Keep
+	}
+	mention.AuthorID = r.Resolve(mention)
+	return mention, webmention.Keep
+}
+
+func (r *Registry) Derives() webmention.Derived {
+	return webmention.Derived{AuthorID: true}
+}
+
+func (r *Registry) String() string {
+	return "authors"
+}
+
+// Resolve returns the ID of mention's author, registering or merging identities as needed, and updates the author
+// from mention when it is their latest. It returns an empty ID when nothing identifies the author.
+func (r *Registry) Resolve(mention webmention.Mention) string {
+	keys := Keys(mention)
+	if len(keys) == 0 {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var alias *Alias
+	for _, key := range keys {
+		if alias = r.aliases[key]; alias != nil {
+			keys = append(keys, r.aliasKeys[alias]...)
+			break
+		}
+	}
+
+	var matched []*Author
+	for _, key := range keys {
+		if author := r.byKey[key]; author != nil && !slices.Contains(matched, author) {
+			matched = append(matched, author)
+		}
+	}
+	var author *Author
+	if len(matched) == 0 {
+		sum := sha256.Sum256([]byte(keys[0]))
+		author = &Author{ID: hex.EncodeToString(sum[:6])}
+		r.authors = append(r.authors, author)
+		r.changed = true
+	} else {
+		// The oldest identity survives a merge and keeps its ID.
+		slices.SortFunc(matched, func(a, b *Author) int {
+			return slices.Index(r.authors, a) - slices.Index(r.authors, b)
+		})
+		author = matched[0]
+		for _, other := range matched[1:] {
+			r.merge(author, other)
+		}
+	}
+	if alias != nil && alias.ID != "" && author.ID != alias.ID {
+		author.MergedIDs = append(author.MergedIDs, author.ID)
+		author.ID = alias.ID
+		r.changed = true
+	}
+	for _, key := range keys {
+		if r.byKey[key] == nil {
+			r.byKey[key] = author
+			author.Aliases = append(author.Aliases, key)
+			r.changed = true
+		}
+	}
+	r.update(author, mention)
+	return author.ID
+}
+
+// merge moves the identity of other into author.
+func (r *Registry) merge(author, other *Author) {
+	for _, key := range other.Aliases {
+		r.byKey[key] = author
+	}
+	author.Aliases = append(author.Aliases, other.Aliases...)
+	author.MergedIDs = append(author.MergedIDs, other.ID)
+	author.MergedIDs = append(author.MergedIDs, other.MergedIDs...)
+	if other.Updated.After(author.Updated) {
+		author.Name, author.Photo, author.URL, author.Updated = other.Name, other.Photo, other.URL, other.Updated
+	}
+	r.authors = slices.DeleteFunc(r.authors, func(a *Author) bool { return a == other })
+	r.changed = true
+}
+
+// update takes the name, photo and URL of author from mention unless an earlier mention is newer.
+func (r *Registry) update(author *Author, mention webmention.Mention) {
+	published := mention.Published
+	if published.IsZero() {
+		published = mention.WMReceived
+	}
+	if published.Before(author.Updated) {
+		return
+	}
+	updated := *author
+	updated.Updated = published
+	if name := strings.TrimSpace(mention.Author.Name); name != "" {
+		updated.Name = name
+	}
+	if mention.Author.Photo != "" {
+		updated.Photo = mention.Author.Photo
+	}
+	if mention.Author.URL != "" {
+		updated.URL = mention.Author.URL
+	}
+	if updated.Name != author.Name || updated.Photo != author.Photo || updated.URL != author.URL ||
+		!updated.Updated.Equal(author.Updated) {
+		*author = updated
+		r.changed = true
+	}
+}
+
+// Authors returns the registered authors, oldest first.
+func (r *Registry) Authors() []Author {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	authors := make([]Author, len(r.authors))
+	for i, author := range r.authors {
+		authors[i] = *author
+	}
+	return authors
+}
+
+// Save writes the authors index to path when an author changed since the registry was created or last saved.
+func (r *Registry) Save(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.changed {
+		return nil
+	}
+	authors := r.authors
+	if authors == nil {
+		authors = []*Author{}
+	}
+	data, err := json.MarshalIndent(authors, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling authors index: %w", err)
+	}
+	if err := WriteFileFunc(path, data, 0644); err != nil {
+		return fmt.Errorf("cannot write authors index: %w", err)
+	}
+	r.changed = false
+	return nil
+}
@@ -0,0 +1,140 @@
+package authors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func mentionBy(name, authorURL, photo string, published time.Time) webmention.Mention {
+	return webmention.Mention{WMSource: "https://source.example/post", Published: published,
+		Author: webmention.Author{Name: name, URL: authorURL, Photo: photo}}
+}
+
+func TestCanonicalURL(t *testing.T) {
+	Convey("Author URLs are reduced to what identifies the author", t, func() {
+		So(CanonicalURL("https://www.Ada.Example/"), ShouldEqual, "ada.example")
+		So(CanonicalURL("http://ada.example/about?ref=1#me"), ShouldEqual, "ada.example/about")
+		So(CanonicalURL("https://mastodon.social/users/ada"), ShouldEqual, "mastodon.social/@ada")
+		So(CanonicalURL("https://mastodon.social/@ada"), ShouldEqual, "mastodon.social/@ada")
+		So(CanonicalURL("https://x.com/Ada"), ShouldEqual, "twitter.com/ada")
+		So(CanonicalURL(""), ShouldEqual, "")
+	})
+}
+
+func TestKeys(t *testing.T) {
+	Convey("Authors are keyed by URL, else by name and source host, and by name and photo", t, func() {
+		So(Keys(mentionBy("Ada  Lovelace", "https://ada.example", "https://ada.example/me.jpg", time.Time{})),
+			ShouldResemble, []string{"url:ada.example", "card:ada lovelace https://ada.example/me.jpg"})
+		So(Keys(mentionBy("Ada", "", "", time.Time{})), ShouldResemble, []string{"name:ada@source.example"})
+		So(Keys(mentionBy("Ada", "", "https://mastodon.social/avatars/original/missing.png", time.Time{})),
+			ShouldHaveLength, 1)
+		So(Keys(webmention.Mention{}), ShouldBeEmpty)
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	Convey("Given a registry with an alias mapping", t, func() {
+		registry := NewRegistry(nil, []Alias{
+			{URLs: []string{"https://ada.example", "https://mastodon.social/@ada"}},
+			{ID: "grace", URLs: []string{"https://grace.example", "https://old-grace.example"}},
+		})
+
+		Convey("An author keeps one ID across their aliased URLs", func() {
+			first := registry.Resolve(mentionBy("Ada", "https://ada.example/", "", day(1)))
+			So(first, ShouldHaveLength, 12)
+			So(registry.Resolve(mentionBy("ada", "https://mastodon.social/users/ada", "", day(2))), ShouldEqual, first)
+			So(registry.Resolve(mentionBy("Someone", "https://someone.example", "", day(2))), ShouldNotEqual, first)
+			So(registry.Resolve(mentionBy("Grace", "https://old-grace.example", "", day(1))), ShouldEqual, "grace")
+		})
+
+		Convey("The index keeps each author's latest name and photo", func() {
+			registry.Resolve(mentionBy("Ada L.", "https://mastodon.social/@ada", "/avatars/new.png", day(5)))
+			registry.Resolve(mentionBy("Ada", "https://ada.example", "/avatars/old.png", day(1)))
+			authors := registry.Authors()
+			So(authors, ShouldHaveLength, 1)
+			So(authors[0].Name, ShouldEqual, "Ada L.")
+			So(authors[0].Photo, ShouldEqual, "/avatars/new.png")
+			So(authors[0].URL, ShouldEqual, "https://mastodon.social/@ada")
+			So(authors[0].Updated, ShouldEqual, day(5))
+		})
+
+		Convey("Identities sharing a name and photo are merged into the oldest", func() {
+			site := registry.Resolve(mentionBy("Linus", "https://linus.example", "", day(1)))
+			bridged := registry.Resolve(mentionBy("Linus", "https://bsky.app/profile/linus", "/avatars/l.png", day(2)))
+			So(bridged, ShouldNotEqual, site)
+			So(registry.Resolve(mentionBy("Linus", "https://linus.example", "/avatars/l.png", day(3))), ShouldEqual, site)
+
+			authors := registry.Authors()
+			So(authors, ShouldHaveLength, 1)
+			So(authors[0].ID, ShouldEqual, site)
+			So(authors[0].MergedIDs, ShouldResemble, []string{bridged})
+			So(registry.Resolve(mentionBy("", "https://bsky.app/profile/linus", "", day(4))), ShouldEqual, site)
+		})
+
+		Convey("Private mentions are left out", func() {
+			mention := mentionBy("Ada", "https://ada.example", "", day(1))
+			mention.WMPrivate = true
+			processed, verdict := registry.Process(mention)
+			So(verdict, ShouldEqual, webmention.Keep)
+			So(processed.AuthorID, ShouldBeEmpty)
+			So(registry.Authors(), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given an index on disk", t, func() {
+		ReadFileFunc = os.ReadFile
+		WriteFileFunc = os.WriteFile
+		path := filepath.Join(t.TempDir(), "webmentions"+IndexSuffix)
+
+		authors, err := LoadIndex(path)
+		So(err, ShouldBeNil)
+		So(authors, ShouldBeEmpty)
+
+		registry := NewRegistry(authors, nil)
+		id := registry.Resolve(mentionBy("Ada", "https://ada.example", "", day(1)))
+		So(registry.Save(path), ShouldBeNil)
+
+		Convey("IDs stay stable across runs", func() {
+			authors, err := LoadIndex(path)
+			So(err, ShouldBeNil)
+			So(authors, ShouldHaveLength, 1)
+			reloaded := NewRegistry(authors, nil)
+			So(reloaded.Resolve(mentionBy("Ada", "https://ada.example", "", day(1))), ShouldEqual, id)
+		})
+
+		Convey("An alias mapping with an ID renames the author, keeping the old ID", func() {
+			authors, _ := LoadIndex(path)
+			renamed := NewRegistry(authors, []Alias{{ID: "ada", URLs: []string{"https://ada.example"}}})
+			So(renamed.Resolve(mentionBy("Ada", "https://ada.example", "", day(2))), ShouldEqual, "ada")
+			So(renamed.Authors()[0].MergedIDs, ShouldResemble, []string{id})
+		})
+
+		Convey("Unchanged registries aren't written", func() {
+			WriteFileFunc = func(string, []byte, os.FileMode) error {
+				panic("unexpected write")
+			}
+			So(registry.Save(path), ShouldBeNil)
+			WriteFileFunc = os.WriteFile
+		})
+	})
+
+	Convey("Alias files are read from YAML", t, func() {
+		ReadFileFunc = os.ReadFile
+		path := filepath.Join(t.TempDir(), "aliases.yaml")
+		So(os.WriteFile(path, []byte("authors:\n  - id: ada\n    urls: [https://ada.example]\n"), 0644), ShouldBeNil)
+		aliases, err := LoadAliases(path)
+		So(err, ShouldBeNil)
+		So(aliases, ShouldResemble, []Alias{{ID: "ada", URLs: []string{"https://ada.example"}}})
+
+		So(os.WriteFile(path, []byte("authors:\n  - id: ada\n"), 0644), ShouldBeNil)
+		_, err = LoadAliases(path)
+		So(err, ShouldNotBeNil)
+	})
+}
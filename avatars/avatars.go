@@ -0,0 +1,316 @@
+package avatars
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+	"github.com/charmbracelet/log"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+//=== Bindings for tests
+
+var WriteFileFunc = os.WriteFile
+
+var MkdirAllFunc = os.MkdirAll
+
+//=== Bindings for tests
+
+const (
+	DefaultURLPrefix = "/avatars/"
+	DefaultSize      = 96
+	// MaxBytes and MaxPixels bound the photos downloaded and decoded.
+	MaxBytes  = 5 << 20
+	MaxPixels = 25_000_000
+)
+
+// fileName matches the content-hash names of mirrored and generated avatars. GC never touches other files.
+var fileName = regexp.MustCompile(`^[0-9a-f]{16}\.(jpg|png|svg)$`)
+
+// Mirror downloads author photos into Dir, cropped to a square of Size pixels and named by the hash of their
+// content, and rewrites each mention's photo to URLPrefix followed by the file name. It is a webmention.Stage.
+// Photos that can't be mirrored are left as they are. Authors without a photo get a generated SVG avatar in the
+// Fallback style, when set.
+type Mirror struct {
+	Dir       string
+	URLPrefix string
+	Size      int
+	Fallback  Fallback
+	// Client downloads the photos. When nil, a client with a 30 second timeout is used that only connects to public
+	// addresses, so a photo URL can't reach the loopback, link-local or private network the mirror runs in.
+	Client *http.Client
+
+	mu sync.Mutex
+	// mirrored maps the photos mirrored and avatars generated so far to their local URL, so each is downloaded or
+	// written once per Mirror.
+	mirrored map[string]string
+}
+
+func (m *Mirror) Process(mention webmention.Mention) (webmention.Mention, webmention.Verdict) {
+	// Photos of private mentions would be served with the site.
+	if mention.WMPrivate {
+		return mention, webmention.Keep
+	}
+	photo := mention.Author.Photo
+	if strings.TrimSpace(photo) == "" && m.Fallback != FallbackNone {
+		local, err := m.Generate(mention)
+		if err != nil {
+			log.Warn("Cannot generate avatar", "WMID", mention.WMID, "err", err)
+		} else if local != "" {
+			mention.Author.Photo = local
+		}
+		return mention, webmention.Keep
+	}
+	if !strings.HasPrefix(photo, "http://") && !strings.HasPrefix(photo, "https://") {
+		return mention, webmention.Keep
+	}
+	local, err := m.Mirror(photo)
+	if err != nil {
+		log.Warn("Cannot mirror avatar", "WMID", mention.WMID, "photo", photo, "err", err)
+		return mention, webmention.Keep
+	}
+	mention.Author.Photo = local
+	return mention, webmention.Keep
+}
+
+func (m *Mirror) String() string {
+	return "avatar-mirror"
+}
+
+// Mirror downloads photo and returns its local URL.
+func (m *Mirror) Mirror(photo string) (string, error) {
+	m.mu.Lock()
+	local, ok := m.mirrored[photo]
+	m.mu.Unlock()
+	if ok {
+		return local, nil
+	}
+
+	data, err := m.download(photo)
+	if err != nil {
+		return "", err
+	}
+	resized, ext, err := Resize(data, m.size())
+	if err != nil {
+		return "", err
+	}
+	return m.save(photo, resized, ext)
+}
+
+// Generate writes the fallback avatar of mention's author and returns its local URL, or an empty URL when nothing
+// identifies the author.
+func (m *Mirror) Generate(mention webmention.Mention) (string, error) {
+	seed := Seed(mention)
+	if seed == "" {
+		return "", nil
+	}
+	key := string(m.Fallback) + ":" + seed + ":" + mention.Author.Name
+	m.mu.Lock()
+	local, ok := m.mirrored[key]
+	m.mu.Unlock()
+	if ok {
+		return local, nil
+	}
+	return m.save(key, Generate(m.Fallback, seed, mention.Author.Name, m.size()), ".svg")
+}
+
+// save writes data to the file named by its hash and remembers its local URL for key.
+func (m *Mirror) save(key string, data []byte, ext string) (string, error) {
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:8]) + ext
+	if err := MkdirAllFunc(m.Dir, 0755); err != nil {
+		return "", fmt.Errorf("cannot create avatar directory: %w", err)
+	}
+	if err := WriteFileFunc(filepath.Join(m.Dir, name), data, 0644); err != nil {
+		return "", fmt.Errorf("cannot write avatar: %w", err)
+	}
+
+	local := m.urlPrefix() + name
+	m.mu.Lock()
+	if m.mirrored == nil {
+		m.mirrored = map[string]string{}
+	}
+	m.mirrored[key] = local
+	m.mu.Unlock()
+	return local, nil
+}
+
+func (m *Mirror) download(photo string) ([]byte, error) {
+	if err := checkScheme(photo); err != nil {
+		return nil, err
+	}
+	client := m.Client
+	if client == nil {
+		client = publicClient
+	}
+	resp, err := client.Get(photo)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading avatar: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("error downloading avatar: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("error downloading avatar: %w", err)
+	}
+	if len(data) > MaxBytes {
+		return nil, fmt.Errorf("avatar is larger than %d bytes", MaxBytes)
+	}
+	return data, nil
+}
+
+// publicClient downloads photos from public addresses only. Redirects are checked like the photo URL, and the dialer
+// checks every address a host name resolves to, so neither can lead to an internal one. Proxies aren't used, as they
+// would connect on the client's behalf.
+var publicClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error { return checkAddress(address) },
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return checkScheme(req.URL.String())
+	},
+}
+
+// checkScheme rejects photo URLs other than http and https ones.
+func checkScheme(photo string) error {
+	parsed, err := url.Parse(photo)
+	if err != nil {
+		return fmt.Errorf("invalid avatar URL: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("avatar URL scheme '%s' is not allowed, expected http or https", parsed.Scheme)
+	}
+	return nil
+}
+
+// checkAddress rejects connecting to address, an IP and port, unless the IP is a public unicast one.
+func checkAddress(address string) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid avatar address '%s': %w", address, err)
+	}
+	// Global unicast excludes the loopback, link-local, multicast and unspecified addresses, but not private ones.
+	ip := addrPort.Addr().Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return fmt.Errorf("avatar address %s is not public", ip)
+	}
+	return nil
+}
+
+func (m *Mirror) size() int {
+	if m.Size < 1 {
+		return DefaultSize
+	}
+	return m.Size
+}
+
+func (m *Mirror) urlPrefix() string {
+	if m.URLPrefix == "" {
+		return DefaultURLPrefix
+	}
+	return m.URLPrefix
+}
+
+// Resize crops the image in data to a centered square and scales it down to size pixels, never up. JPEG photos are
+// encoded as JPEG, everything else as PNG to keep transparency. It returns the encoded image and its file extension.
+func Resize(data []byte, size int) ([]byte, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("unsupported avatar image: %w", err)
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, "", fmt.Errorf("avatar of %dx%d pixels is too large", config.Width, config.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot decode avatar: %w", err)
+	}
+
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	if side == 0 {
+		return nil, "", errors.New("avatar image is empty")
+	}
+	crop := image.Rect(0, 0, side, side).Add(bounds.Min).
+		Add(image.Pt((bounds.Dx()-side)/2, (bounds.Dy()-side)/2))
+	size = min(size, side)
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+
+	var out bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: 85})
+		return out.Bytes(), ".jpg", err
+	}
+	err = png.Encode(&out, dst)
+	return out.Bytes(), ".png", err
+}
+
+// GC removes the mirrored avatars in dir that aren't among referenced, the photo URLs of the stored mentions.
+// Avatars written within grace are kept, as their mentions may not be saved yet. It returns the removed file names.
+func GC(dir, urlPrefix string, referenced []string, grace time.Duration) ([]string, error) {
+	if urlPrefix == "" {
+		urlPrefix = DefaultURLPrefix
+	}
+	inUse := map[string]bool{}
+	for _, photo := range referenced {
+		if name, ok := strings.CutPrefix(photo, urlPrefix); ok {
+			inUse[name] = true
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot list avatars: %w", err)
+	}
+	var removed []string
+	for _, entry := range entries {
+		if entry.IsDir() || !fileName.MatchString(entry.Name()) || inUse[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < grace {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return removed, fmt.Errorf("cannot remove avatar: %w", err)
+		}
+		removed = append(removed, entry.Name())
+	}
+	return removed, nil
+}
@@ -0,0 +1,176 @@
+package avatars
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func encodePNG(width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var out bytes.Buffer
+	So(png.Encode(&out, img), ShouldBeNil)
+	return out.Bytes()
+}
+
+func TestResize(t *testing.T) {
+	Convey("Photos are cropped to a centered square and scaled down", t, func() {
+		resized, ext, err := Resize(encodePNG(200, 100), 48)
+		So(err, ShouldBeNil)
+		So(ext, ShouldEqual, ".png")
+		img, err := png.Decode(bytes.NewReader(resized))
+		So(err, ShouldBeNil)
+		So(img.Bounds().Dx(), ShouldEqual, 48)
+		So(img.Bounds().Dy(), ShouldEqual, 48)
+	})
+
+	Convey("Small photos aren't scaled up", t, func() {
+		resized, _, err := Resize(encodePNG(20, 30), 96)
+		So(err, ShouldBeNil)
+		img, _ := png.Decode(bytes.NewReader(resized))
+		So(img.Bounds().Dx(), ShouldEqual, 20)
+	})
+
+	Convey("JPEG photos stay JPEG", t, func() {
+		var out bytes.Buffer
+		So(jpeg.Encode(&out, image.NewRGBA(image.Rect(0, 0, 64, 64)), nil), ShouldBeNil)
+		_, ext, err := Resize(out.Bytes(), 32)
+		So(err, ShouldBeNil)
+		So(ext, ShouldEqual, ".jpg")
+	})
+
+	Convey("Other files are rejected", t, func() {
+		_, _, err := Resize([]byte("<html>not found</html>"), 32)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestMirror(t *testing.T) {
+	Convey("Given a mirror and a server with a photo", t, func() {
+		WriteFileFunc = os.WriteFile
+		MkdirAllFunc = os.MkdirAll
+		photo := encodePNG(120, 120)
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if r.URL.Path != "/me.png" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write(photo)
+		}))
+		defer server.Close()
+		// The test server listens on the loopback interface, which the default client refuses.
+		mirror := &Mirror{Dir: filepath.Join(t.TempDir(), "avatars"), URLPrefix: "/img/avatars/", Size: 64,
+			Client: server.Client()}
+
+		Convey("The photo is downloaded once and rewritten to its content-hash name", func() {
+			mention := webmention.Mention{Author: webmention.Author{Photo: server.URL + "/me.png"}}
+			first, verdict := mirror.Process(mention)
+			So(verdict, ShouldEqual, webmention.Keep)
+			So(first.Author.Photo, ShouldStartWith, "/img/avatars/")
+			name := filepath.Base(first.Author.Photo)
+			So(fileName.MatchString(name), ShouldBeTrue)
+			_, err := os.Stat(filepath.Join(mirror.Dir, name))
+			So(err, ShouldBeNil)
+
+			second, _ := mirror.Process(mention)
+			So(second.Author.Photo, ShouldEqual, first.Author.Photo)
+			So(requests, ShouldEqual, 1)
+		})
+
+		Convey("Photos that can't be mirrored are left as they are", func() {
+			mention := webmention.Mention{Author: webmention.Author{Photo: server.URL + "/gone.png"}}
+			processed, verdict := mirror.Process(mention)
+			So(verdict, ShouldEqual, webmention.Keep)
+			So(processed.Author.Photo, ShouldEqual, mention.Author.Photo)
+
+			processed, _ = mirror.Process(webmention.Mention{Author: webmention.Author{Photo: "/img/avatars/x.png"}})
+			So(processed.Author.Photo, ShouldEqual, "/img/avatars/x.png")
+		})
+
+		Convey("Photos larger than MaxBytes aren't mirrored", func() {
+			server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write(make([]byte, MaxBytes+1))
+			})
+			_, err := mirror.Mirror(server.URL + "/huge.png")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "larger than")
+		})
+
+		Convey("Without a client, photos on internal addresses or with other schemes aren't downloaded", func() {
+			mirror.Client = nil
+			_, err := mirror.Mirror(server.URL + "/me.png")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not public")
+			So(requests, ShouldEqual, 0)
+
+			_, err = mirror.Mirror("file:///etc/passwd")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "scheme 'file' is not allowed")
+		})
+
+		Convey("Photos of private mentions are not mirrored", func() {
+			mention := webmention.Mention{WMPrivate: true, Author: webmention.Author{Photo: server.URL + "/me.png"}}
+			processed, verdict := mirror.Process(mention)
+			So(verdict, ShouldEqual, webmention.Keep)
+			So(processed.Author.Photo, ShouldEqual, mention.Author.Photo)
+			So(requests, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestCheckAddress(t *testing.T) {
+	Convey("Only public unicast addresses may be connected to", t, func() {
+		for _, address := range []string{"127.0.0.1:80", "[::1]:443", "10.1.2.3:80", "172.16.0.1:80",
+			"192.168.1.1:80", "169.254.169.254:80", "[fe80::1]:80", "[fd00::1]:80", "0.0.0.0:80", "[::ffff:127.0.0.1]:80"} {
+			So(checkAddress(address), ShouldNotBeNil)
+		}
+		So(checkAddress("93.184.216.34:443"), ShouldBeNil)
+		So(checkAddress("[2606:2800:220:1:248:1893:25c8:1946]:443"), ShouldBeNil)
+	})
+}
+
+func TestGC(t *testing.T) {
+	Convey("Given a directory of avatars", t, func() {
+		dir := t.TempDir()
+		old := time.Now().Add(-2 * time.Hour)
+		for _, name := range []string{"0123456789abcdef.png", "fedcba9876543210.jpg", "logo.png"} {
+			path := filepath.Join(dir, name)
+			So(os.WriteFile(path, []byte("x"), 0644), ShouldBeNil)
+			So(os.Chtimes(path, old, old), ShouldBeNil)
+		}
+		So(os.WriteFile(filepath.Join(dir, "aaaaaaaaaaaaaaaa.png"), []byte("x"), 0644), ShouldBeNil)
+
+		Convey("Unreferenced avatars past the grace period are removed", func() {
+			removed, err := GC(dir, "/avatars/", []string{"/avatars/0123456789abcdef.png", "https://x.example/me.png"}, time.Hour)
+			So(err, ShouldBeNil)
+			So(removed, ShouldResemble, []string{"fedcba9876543210.jpg"})
+
+			entries, _ := os.ReadDir(dir)
+			So(entries, ShouldHaveLength, 3)
+		})
+
+		Convey("A missing directory has nothing to collect", func() {
+			removed, err := GC(filepath.Join(dir, "missing"), "", nil, 0)
+			So(err, ShouldBeNil)
+			So(removed, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,131 @@
+package avatars
+
+import (
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+
+	"github.com/blbecker/webmentionR/webmention"
+	"github.com/rivo/uniseg"
+)
+
+// Fallback is the style of the avatars generated for authors without a photo.
+type Fallback string
+
+const (
+	FallbackNone Fallback = ""
+	// FallbackInitials draws the author's initials on a background color.
+	FallbackInitials Fallback = "initials"
+	// FallbackIdenticon draws a symmetric 5x5 pattern.
+	FallbackIdenticon Fallback = "identicon"
+)
+
+func ParseFallback(style string) (Fallback, error) {
+	switch fallback := Fallback(strings.ToLower(strings.TrimSpace(style))); fallback {
+	case FallbackNone, FallbackInitials, FallbackIdenticon:
+		return fallback, nil
+	}
+	return FallbackNone, fmt.Errorf("invalid avatar fallback '%s', expected initials or identicon", style)
+}
+
+// Seed identifies the author of mention for a generated avatar: the author URL, else the author name, else the host
+// of the source. The same author always gets the same avatar.
+func Seed(mention webmention.Mention) string {
+	if author := strings.TrimSpace(mention.Author.URL); author != "" {
+		return strings.TrimSuffix(strings.ToLower(author), "/")
+	}
+	if name := strings.TrimSpace(mention.Author.Name); name != "" {
+		return name
+	}
+	if source, err := url.Parse(mention.WMSource); err == nil {
+		return strings.ToLower(source.Hostname())
+	}
+	return ""
+}
+
+// Generate returns the SVG avatar of style for seed, with name's initials for FallbackInitials.
+func Generate(style Fallback, seed, name string, size int) []byte {
+	sum := sha256.Sum256([]byte(seed))
+	color := hslHex(float64(int(sum[0])<<8|int(sum[1]))*360/65536, 0.55, 0.45)
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d"`, size, size)
+	if style == FallbackIdenticon {
+		svg.WriteString(` viewBox="0 0 6 6"><rect width="6" height="6" fill="#f0f0f0"/>`)
+		// Cells of the left three columns are set by the bits of the hash and mirrored to the right.
+		for column := 0; column < 3; column++ {
+			for row := 0; row < 5; row++ {
+				bit := column*5 + row
+				if sum[2+bit/8]&(1<<(bit%8)) == 0 {
+					continue
+				}
+				fmt.Fprintf(&svg, `<rect x="%g" y="%g" width="1" height="1" fill="%s"/>`, 0.5+float64(column), 0.5+float64(row), color)
+				if column < 2 {
+					fmt.Fprintf(&svg, `<rect x="%g" y="%g" width="1" height="1" fill="%s"/>`, 4.5-float64(column), 0.5+float64(row), color)
+				}
+			}
+		}
+	} else {
+		svg.WriteString(` viewBox="0 0 100 100">`)
+		fmt.Fprintf(&svg, `<rect width="100" height="100" fill="%s"/>`, color)
+		svg.WriteString(`<text x="50" y="50" dy=".35em" text-anchor="middle" fill="#ffffff" ` +
+			`font-family="system-ui,sans-serif" font-size="42">`)
+		_ = xml.EscapeText(&svg, []byte(Initials(name, seed)))
+		svg.WriteString(`</text>`)
+	}
+	svg.WriteString("</svg>\n")
+	return []byte(svg.String())
+}
+
+// Initials returns the first characters of the first and last word of name, or the first character of the host of
+// seed when name is empty.
+func Initials(name, seed string) string {
+	words := strings.Fields(name)
+	if len(words) == 0 {
+		host := seed
+		if parsed, err := url.Parse(seed); err == nil && parsed.Hostname() != "" {
+			host = parsed.Hostname()
+		}
+		words = []string{strings.TrimPrefix(host, "www.")}
+	}
+	first := func(word string) string {
+		cluster, _, _, _ := uniseg.FirstGraphemeClusterInString(strings.TrimLeft(word, `@"'(`), -1)
+		return strings.ToUpper(cluster)
+	}
+	initials := first(words[0])
+	if len(words) > 1 {
+		initials += first(words[len(words)-1])
+	}
+	if initials == "" {
+		return "?"
+	}
+	return initials
+}
+
+// hslHex converts a color from HSL, with hue in degrees and saturation and lightness in [0, 1], to #rrggbb.
+func hslHex(hue, saturation, lightness float64) string {
+	chroma := (1 - math.Abs(2*lightness-1)) * saturation
+	sector := hue / 60
+	x := chroma * (1 - math.Abs(math.Mod(sector, 2)-1))
+	var r, g, b float64
+	switch int(sector) {
+	case 0:
+		r, g = chroma, x
+	case 1:
+		r, g = x, chroma
+	case 2:
+		g, b = chroma, x
+	case 3:
+		g, b = x, chroma
+	case 4:
+		r, b = x, chroma
+	default:
+		r, b = chroma, x
+	}
+	m := lightness - chroma/2
+	channel := func(value float64) int { return int((value+m)*255 + 0.5) }
+	return fmt.Sprintf("#%02x%02x%02x", channel(r), channel(g), channel(b))
+}
@@ -0,0 +1,112 @@
+package avatars
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseFallback(t *testing.T) {
+	Convey("Fallback styles are parsed case-insensitively", t, func() {
+		fallback, err := ParseFallback(" Identicon ")
+		So(err, ShouldBeNil)
+		So(fallback, ShouldEqual, FallbackIdenticon)
+
+		fallback, err = ParseFallback("")
+		So(err, ShouldBeNil)
+		So(fallback, ShouldEqual, FallbackNone)
+
+		_, err = ParseFallback("gravatar")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSeed(t *testing.T) {
+	Convey("Authors are identified by URL, then name, then source host", t, func() {
+		mention := webmention.Mention{WMSource: "https://Notes.Example/post/1",
+			Author: webmention.Author{Name: "Ada Lovelace", URL: "https://Ada.Example/"}}
+		So(Seed(mention), ShouldEqual, "https://ada.example")
+		mention.Author.URL = ""
+		So(Seed(mention), ShouldEqual, "Ada Lovelace")
+		mention.Author.Name = ""
+		So(Seed(mention), ShouldEqual, "notes.example")
+		So(Seed(webmention.Mention{}), ShouldEqual, "")
+	})
+}
+
+func TestInitials(t *testing.T) {
+	Convey("Initials are taken from the first and last word of the name", t, func() {
+		So(Initials("Ada King Lovelace", ""), ShouldEqual, "AL")
+		So(Initials("@grace", ""), ShouldEqual, "G")
+		So(Initials("élodie 👩‍🔬", ""), ShouldEqual, "É👩‍🔬")
+		So(Initials("", "https://www.ada.example"), ShouldEqual, "A")
+		So(Initials("", ""), ShouldEqual, "?")
+	})
+}
+
+func TestGenerate(t *testing.T) {
+	Convey("Generated avatars are deterministic, well-formed SVG", t, func() {
+		for _, style := range []Fallback{FallbackInitials, FallbackIdenticon} {
+			svg := Generate(style, "https://ada.example", "<Ada> & co", 64)
+			So(string(Generate(style, "https://ada.example", "<Ada> & co", 64)), ShouldEqual, string(svg))
+			So(string(Generate(style, "https://grace.example", "<Ada> & co", 64)), ShouldNotEqual, string(svg))
+			So(string(svg), ShouldContainSubstring, `width="64" height="64"`)
+
+			decoder := xml.NewDecoder(strings.NewReader(string(svg)))
+			for {
+				_, err := decoder.Token()
+				if err != nil {
+					So(err.Error(), ShouldEqual, "EOF")
+					break
+				}
+			}
+		}
+		So(string(Generate(FallbackInitials, "x", "<Ada> & co", 64)), ShouldContainSubstring, "&lt;C")
+	})
+
+	Convey("Colors are converted from HSL", t, func() {
+		So(hslHex(0, 1, 0.5), ShouldEqual, "#ff0000")
+		So(hslHex(120, 1, 0.5), ShouldEqual, "#00ff00")
+		So(hslHex(240, 1, 0.25), ShouldEqual, "#000080")
+	})
+}
+
+func TestMirror_Fallback(t *testing.T) {
+	Convey("Given a mirror with a fallback style", t, func() {
+		WriteFileFunc = os.WriteFile
+		MkdirAllFunc = os.MkdirAll
+		mirror := &Mirror{Dir: t.TempDir(), Fallback: FallbackInitials}
+
+		Convey("Authors without a photo get a generated avatar", func() {
+			mention := webmention.Mention{Author: webmention.Author{Name: "Ada", URL: "https://ada.example"}}
+			processed, verdict := mirror.Process(mention)
+			So(verdict, ShouldEqual, webmention.Keep)
+			So(processed.Author.Photo, ShouldStartWith, DefaultURLPrefix)
+			So(processed.Author.Photo, ShouldEndWith, ".svg")
+			name := filepath.Base(processed.Author.Photo)
+			So(fileName.MatchString(name), ShouldBeTrue)
+			data, err := os.ReadFile(filepath.Join(mirror.Dir, name))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, ">A</text>")
+
+			again, _ := mirror.Process(mention)
+			So(again.Author.Photo, ShouldEqual, processed.Author.Photo)
+		})
+
+		Convey("Mentions without anything identifying the author keep their empty photo", func() {
+			processed, _ := mirror.Process(webmention.Mention{})
+			So(processed.Author.Photo, ShouldBeEmpty)
+		})
+
+		Convey("Nothing is generated without a fallback style", func() {
+			mirror.Fallback = FallbackNone
+			processed, _ := mirror.Process(webmention.Mention{Author: webmention.Author{Name: "Ada"}})
+			So(processed.Author.Photo, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,141 @@
+package authors
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+
+	authorregistry "github.com/blbecker/webmentionR/authors"
+	"github.com/blbecker/webmentionR/config"
+	"github.com/blbecker/webmentionR/webmention"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+)
+
+var Command = cli.Command{
+	Name:  "authors",
+	Usage: "maintain the index of the authors of the stored mentions",
+	Subcommands: []*cli.Command{
+		{
+			Name: "rebuild",
+			Usage: "resolve the author of every stored mention again, e.g. after changing the alias mappings, and " +
+				"rewrite the authors index",
+			Action: rebuildAction,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "config",
+					Aliases: []string{"c"},
+					Usage:   "path to the config file (default: $XDG_CONFIG_HOME/webmentionR/config.yaml)",
+					EnvVars: config.EnvVars("config"),
+				},
+				&cli.StringFlag{
+					Name:    "profile",
+					Aliases: []string{"p"},
+					Usage:   "named profile to take the destination and authors settings from",
+					EnvVars: config.EnvVars("profile"),
+				},
+				&cli.StringFlag{
+					Name:    "destination",
+					Aliases: []string{"D"},
+					EnvVars: config.EnvVars("destination"),
+				},
+				&cli.StringFlag{
+					Name:    "authors-index",
+					Usage:   "path of the authors index (default: the destination directory followed by .authors.json)",
+					EnvVars: config.EnvVars("authors-index"),
+				},
+				&cli.StringFlag{
+					Name:    "author-aliases",
+					Usage:   "path to a YAML file mapping the URLs each author posts from to one author",
+					EnvVars: config.EnvVars("author-aliases"),
+				},
+			},
+		},
+	},
+}
+
+// stored locates a mention in the files loaded from the destination.
+type stored struct {
+	path  string
+	index int
+}
+
+func rebuildAction(cliContext *cli.Context) error {
+	configFile, err := config.Load(cliContext.String("config"))
+	if err != nil {
+		return fmt.Errorf("cannot load config file: %w", err)
+	}
+	profile, err := configFile.Profile(cliContext.String("profile"))
+	if err != nil {
+		return fmt.Errorf("cannot select profile: %w", err)
+	}
+	settings := config.NewSettings(cliContext, profile)
+	destination := settings.String("destination")
+	if destination == "" {
+		return fmt.Errorf("a destination is required, set --destination or select a profile")
+	}
+	indexPath := settings.String("authors-index")
+	if indexPath == "" {
+		indexPath = authorregistry.IndexPath(destination)
+	}
+	var aliases []authorregistry.Alias
+	if path := settings.String("author-aliases"); path != "" {
+		if aliases, err = authorregistry.LoadAliases(path); err != nil {
+			return err
+		}
+	}
+
+	mentionsByPath, err := webmention.LoadAll(destination)
+	if err != nil {
+		return err
+	}
+	// Mentions are resolved in the order they were received, so every author keeps the ID of their first mention.
+	var order []stored
+	for path, mentions := range mentionsByPath {
+		for i := range mentions {
+			order = append(order, stored{path: path, index: i})
+		}
+	}
+	mention := func(s stored) *webmention.Mention { return &mentionsByPath[s.path][s.index] }
+	slices.SortFunc(order, func(a, b stored) int {
+		if c := mention(a).WMReceived.Compare(mention(b).WMReceived); c != 0 {
+			return c
+		}
+		return mention(a).WMID - mention(b).WMID
+	})
+
+	registry := authorregistry.NewRegistry(nil, aliases)
+	changed := map[string]bool{}
+	for _, s := range order {
+		processed, _ := registry.Process(*mention(s))
+		if processed.AuthorID != mention(s).AuthorID {
+			mention(s).AuthorID = processed.AuthorID
+			changed[s.path] = true
+		}
+	}
+
+	for path := range changed {
+		if err := webmention.Save(path, mentionsByPath[path]); err != nil {
+			return err
+		}
+		// Conversation threads embed the mentions, so existing ones are rewritten too.
+		threadsPath := strings.TrimSuffix(path, ".json") + webmention.ThreadsSuffix
+		if _, err := os.Stat(threadsPath); err == nil {
+			threads := webmention.BuildThreads(mentionsByPath[path])
+			if err := webmention.SaveThreads(threadsPath, threads); err != nil {
+				return err
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("cannot check conversation threads: %w", err)
+		}
+	}
+	if err := registry.Save(indexPath); err != nil {
+		return err
+	}
+	log.Info("Rebuilt authors index", "path", indexPath, "authors", len(registry.Authors()), "mentions", len(order),
+		"filesChanged", len(changed))
+	return nil
+}
@@ -0,0 +1,61 @@
+package authors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	authorregistry "github.com/blbecker/webmentionR/authors"
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/urfave/cli/v2"
+)
+
+func runAuthors(args ...string) error {
+	app := &cli.App{Commands: []*cli.Command{&Command}}
+	return app.Run(append([]string{"webmentionR", "authors"}, args...))
+}
+
+func Test_RebuildCommand(t *testing.T) {
+	Convey("Given stored mentions by one author from two URLs", t, func() {
+		webmention.ReadFileFunc = os.ReadFile
+		webmention.WriteFileFunc = os.WriteFile
+		authorregistry.ReadFileFunc = os.ReadFile
+		authorregistry.WriteFileFunc = os.WriteFile
+		dir := t.TempDir()
+		destination := filepath.Join(dir, "webmentions")
+		So(os.Mkdir(destination, 0755), ShouldBeNil)
+		received := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		So(webmention.Save(filepath.Join(destination, "post.json"), []webmention.Mention{
+			{WMID: 2, WMReceived: received.Add(time.Hour), Author: webmention.Author{Name: "Ada", URL: "https://mastodon.social/@ada"}},
+			{WMID: 1, WMReceived: received, Author: webmention.Author{Name: "Ada", URL: "https://ada.example"}},
+		}), ShouldBeNil)
+		So(webmention.Save(filepath.Join(destination, "post.threads.json"), nil), ShouldBeNil)
+		aliases := filepath.Join(dir, "aliases.yaml")
+		So(os.WriteFile(aliases, []byte("authors:\n  - urls: [https://ada.example, https://mastodon.social/@ada]\n"), 0644), ShouldBeNil)
+
+		Convey("rebuild assigns both mentions one author and writes the index", func() {
+			So(runAuthors("rebuild", "--destination", destination, "--author-aliases", aliases), ShouldBeNil)
+
+			mentions, err := webmention.LoadMentions(filepath.Join(destination, "post.json"))
+			So(err, ShouldBeNil)
+			So(mentions[0].AuthorID, ShouldNotBeEmpty)
+			So(mentions[1].AuthorID, ShouldEqual, mentions[0].AuthorID)
+
+			index, err := authorregistry.LoadIndex(authorregistry.IndexPath(destination))
+			So(err, ShouldBeNil)
+			So(index, ShouldHaveLength, 1)
+			So(index[0].ID, ShouldEqual, mentions[0].AuthorID)
+			So(index[0].URL, ShouldEqual, "https://mastodon.social/@ada")
+
+			threads, err := os.ReadFile(filepath.Join(destination, "post.threads.json"))
+			So(err, ShouldBeNil)
+			So(string(threads), ShouldContainSubstring, mentions[0].AuthorID)
+		})
+
+		Convey("rebuild needs a destination", func() {
+			So(runAuthors("rebuild"), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,87 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/blbecker/webmentionR/moderation"
+	"github.com/blbecker/webmentionR/spam"
+	"github.com/blbecker/webmentionR/webmention"
+	"github.com/charmbracelet/log"
+)
+
+// releasedPipeline returns pipeline for mentions released from review: without the moderator, and with a scorer
+// that only scores, so the mentions are saved with their spam score but not held or dropped again.
+func releasedPipeline(pipeline webmention.Pipeline) webmention.Pipeline {
+	var released webmention.Pipeline
+	for _, stage := range pipeline {
+		switch stage := stage.(type) {
+		case *moderation.Moderator:
+			continue
+		case *spam.Scorer:
+			released = append(released, &spam.Scorer{Domain: stage.Domain, Weights: stage.Weights, Phrases: stage.Phrases})
+		default:
+			released = append(released, stage)
+		}
+	}
+	return released
+}
+
+// Approve stores mentions released from review as Fetch would have, had they not been held: they pass through every
+// stage of the pipeline but the moderator, with the spam scorer only scoring them, private mentions go to the private
+// store and the others to the destination, and the authors index is saved. What was stored is published to the
+// subscribers, hooks and notifiers like the mentions of a fetch. It returns the number of mentions stored and the
+// failures of the hooks and notifiers, which don't undo the approval.
+func Approve(ctx context.Context, fetchContext *Context, mentions []webmention.Mention) (int, []string, error) {
+	pipeline := releasedPipeline(fetchContext.Pipeline)
+	mentionsByTarget := map[string][]webmention.Mention{}
+	var privateMentions []webmention.Mention
+	stored := 0
+	for _, mention := range mentions {
+		// The reviewer's approval overrides any stage that would hold the mention again.
+		processed, verdict, stage := pipeline.Process(mention)
+		if verdict == webmention.Drop {
+			log.Info("Dropped approved mention", "WMID", mention.WMID, "stage", stage)
+			continue
+		}
+		stored++
+		if processed.WMPrivate && fetchContext.Private != nil {
+			privateMentions = append(privateMentions, processed)
+			continue
+		}
+		mentionsByTarget[processed.WMTarget] = append(mentionsByTarget[processed.WMTarget], processed)
+	}
+
+	bus := webmention.NewBus(fetchContext.Domain)
+	for _, subscriber := range fetchContext.Subscribers {
+		bus.Subscribe(subscriber)
+	}
+	var storedPrivate []webmention.Mention
+	if fetchContext.Private != nil {
+		var err error
+		if storedPrivate, err = fetchContext.Private.Save(privateMentions, pipeline.Derived()); err != nil {
+			return 0, nil, fmt.Errorf("cannot save approved private mentions: %w", err)
+		}
+	}
+	persistenceWorker := webmention.PersistenceWorker{
+		Destination:  fetchContext.Destination,
+		WriteThreads: fetchContext.Threads,
+		Derived:      pipeline.Derived(),
+		Bus:          bus,
+	}
+	notifications := newNotifications(fetchContext, bus, storedPrivate)
+	for target, mentions := range mentionsByTarget {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		if err := PersistFunc(mentions, &wg, &persistenceWorker); err != nil {
+			return 0, nil, fmt.Errorf("cannot save approved mentions of %s: %w", target, err)
+		}
+	}
+	if fetchContext.Authors != nil {
+		if err := fetchContext.Authors.Save(fetchContext.AuthorsIndex); err != nil {
+			return 0, nil, fmt.Errorf("error saving authors index: %w", err)
+		}
+	}
+	return stored, announce(ctx, fetchContext, bus, notifications, persistenceWorker.Stats()), nil
+}
@@ -0,0 +1,160 @@
+package fetch
+
+import (
+	c "context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/blbecker/webmentionR/moderation"
+	"github.com/blbecker/webmentionR/notify"
+	"github.com/blbecker/webmentionR/private"
+	"github.com/blbecker/webmentionR/spam"
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/urfave/cli/v2"
+)
+
+func Test_Approve(t *testing.T) {
+	Convey("Given a pipeline holding every mention before rewriting it", t, func() {
+		moderator, err := moderation.New([]moderation.Rule{{Name: "everyone", Property: []string{"in-reply-to", "like-of"}, Action: moderation.Hold}})
+		So(err, ShouldBeNil)
+		rewrites := 0
+		fetchContext := &Context{
+			Destination: t.TempDir(),
+			Pipeline: webmention.Pipeline{
+				moderator,
+				&spam.Scorer{Threshold: 1},
+				webmention.StageFunc(func(mention webmention.Mention) (webmention.Mention, webmention.Verdict) {
+					rewrites++
+					mention.Content.Text = "rewritten"
+					if mention.WMProperty == "like-of" {
+						return mention, webmention.Drop
+					}
+					return mention, webmention.Hold
+				}),
+			},
+		}
+		var persisted []webmention.Mention
+		PersistFunc = func(fetchedMentions []webmention.Mention, s *sync.WaitGroup, persistable webmention.Persistable) error {
+			defer s.Done()
+			persisted = append(persisted, fetchedMentions...)
+			return nil
+		}
+		Reset(func() { PersistFunc = webmention.DoPersist })
+
+		Convey("approved mentions skip the stages that held them and pass through the others", func() {
+			stored, _, err := Approve(c.Background(), fetchContext, []webmention.Mention{
+				{WMID: 1, WMTarget: "https://example.com/post", WMProperty: "in-reply-to"},
+				{WMID: 2, WMTarget: "https://example.com/post", WMProperty: "like-of"},
+			})
+			So(err, ShouldBeNil)
+			So(stored, ShouldEqual, 1)
+			So(rewrites, ShouldEqual, 2)
+			So(persisted, ShouldHaveLength, 1)
+			So(persisted[0].WMID, ShouldEqual, 1)
+			So(persisted[0].Content.Text, ShouldEqual, "rewritten")
+			So(persisted[0].SpamSignals, ShouldContain, spam.NoAuthor)
+			So(fetchContext.Pipeline, ShouldHaveLength, 3)
+		})
+
+		Convey("approved private mentions are saved to the private store", func() {
+			private.ReadFileFunc = os.ReadFile
+			private.WriteFileFunc = os.WriteFile
+			private.MkdirAllFunc = os.MkdirAll
+			fetchContext.Private = &private.Store{Dir: filepath.Join(t.TempDir(), "webmentions.private")}
+
+			stored, _, err := Approve(c.Background(), fetchContext, []webmention.Mention{
+				{WMID: 1, WMTarget: "https://example.com/post", WMProperty: "in-reply-to", WMPrivate: true},
+			})
+			So(err, ShouldBeNil)
+			So(stored, ShouldEqual, 1)
+			So(persisted, ShouldBeEmpty)
+
+			saved, err := fetchContext.Private.LoadAll()
+			So(err, ShouldBeNil)
+			So(saved[filepath.Join(fetchContext.Private.Dir, "post.json")][0].WMID, ShouldEqual, 1)
+		})
+
+		Convey("approved mentions are published to the subscribers and notifiers like fetched ones", func() {
+			PersistFunc = webmention.DoPersist
+			webmention.LoadFunc = func(string) ([]webmention.Mention, error) { return nil, nil }
+			webmention.SaveFunc = func(string, []webmention.Mention) error { return nil }
+			Reset(func() {
+				webmention.LoadFunc = webmention.LoadMentions
+				webmention.SaveFunc = webmention.Save
+			})
+			var events []webmention.Event
+			fetchContext.Subscribers = []webmention.Subscriber{webmention.SubscriberFunc(func(event webmention.Event) {
+				events = append(events, event)
+			})}
+			var messages []notify.Message
+			fetchContext.Notifiers = []notify.Notifier{notifierFunc(func(ctx c.Context, message notify.Message) error {
+				messages = append(messages, message)
+				return fmt.Errorf("unreachable")
+			})}
+
+			stored, warnings, err := Approve(c.Background(), fetchContext, []webmention.Mention{
+				{WMID: 1, WMTarget: "https://example.com/post", WMProperty: "in-reply-to"},
+			})
+			So(err, ShouldBeNil)
+			So(stored, ShouldEqual, 1)
+			persistedEvent, ok := events[0].(webmention.TargetPersisted)
+			So(ok, ShouldBeTrue)
+			So(persistedEvent.Stats.New, ShouldHaveLength, 1)
+			So(messages, ShouldHaveLength, 1)
+			So(messages[0].Mentions[0].WMID, ShouldEqual, 1)
+			So(warnings, ShouldHaveLength, 1)
+			So(warnings[0], ShouldContainSubstring, "unreachable")
+			So(events[len(events)-1], ShouldHaveSameTypeAs, webmention.Error{})
+		})
+
+		Convey("a mention with a script held for review is stored sanitized once approved", func() {
+			moderation.ReadFileFunc = os.ReadFile
+			moderation.WriteFileFunc = os.WriteFile
+			dir := t.TempDir()
+			rules := filepath.Join(dir, "rules.yaml")
+			So(os.WriteFile(rules, []byte("rules:\n  - name: everyone\n    property: [in-reply-to]\n    action: hold\n"), 0644), ShouldBeNil)
+			set := flag.NewFlagSet("test", flag.ContinueOnError)
+			for _, f := range Command.Flags {
+				So(f.Apply(set), ShouldBeNil)
+			}
+			So(set.Parse([]string{"--domain", "example.com", "--state-file", "", "--destination",
+				filepath.Join(dir, "webmentions"), "--moderation-rules", rules}), ShouldBeNil)
+			fetchContext, err := NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldBeNil)
+			FetchFunc = func(ctx c.Context, client webmention.Client, mentionChan chan webmention.Mention, worker webmention.Fetchable) error {
+				defer close(mentionChan)
+				mentionChan <- webmention.Mention{WMID: 1, WMTarget: "https://example.com/post", WMProperty: "in-reply-to",
+					Content: webmention.Content{HTML: "<p>Nice post!</p><script>alert(1)</script>"}}
+				return nil
+			}
+			Reset(func() { FetchFunc = webmention.DoFetch })
+
+			result, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldBeNil)
+			So(result.Held, ShouldEqual, 1)
+			queue, err := moderation.LoadQueue(fetchContext.HeldFile)
+			So(err, ShouldBeNil)
+			So(queue.Mentions, ShouldHaveLength, 1)
+			So(queue.Mentions[0].Mention.Content.HTML, ShouldEqual, "<p>Nice post!</p>")
+
+			stored, _, err := Approve(c.Background(), fetchContext, []webmention.Mention{queue.Mentions[0].Mention})
+			So(err, ShouldBeNil)
+			So(stored, ShouldEqual, 1)
+			So(persisted, ShouldHaveLength, 1)
+			So(persisted[0].Content.HTML, ShouldEqual, "<p>Nice post!</p>")
+			So(persisted[0].Content.RawHTML, ShouldBeEmpty)
+		})
+	})
+}
+
+// notifierFunc adapts a function to a notify.Notifier.
+type notifierFunc func(ctx c.Context, message notify.Message) error
+
+func (f notifierFunc) Notify(ctx c.Context, message notify.Message) error {
+	return f(ctx, message)
+}
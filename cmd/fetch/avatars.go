@@ -0,0 +1,86 @@
+package fetch
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/blbecker/webmentionR/avatars"
+	"github.com/blbecker/webmentionR/moderation"
+	"github.com/blbecker/webmentionR/webmention"
+	"github.com/charmbracelet/log"
+)
+
+// AvatarGrace is how long a mirrored avatar is kept before it must be used by a stored mention, so collecting
+// while another domain is being fetched doesn't remove the avatars it just mirrored.
+var AvatarGrace = time.Hour
+
+// CollectAvatars removes the unused avatars of every avatar directory a context collects. An avatar is in use while
+// a mention stored, kept private or held by any context mirroring into the same directory refers to it.
+func CollectAvatars(fetchContexts []*Context) error {
+	byDir := map[string][]*Context{}
+	collect := map[string]bool{}
+	for _, fetchContext := range fetchContexts {
+		if fetchContext.Avatars == nil {
+			continue
+		}
+		byDir[fetchContext.Avatars.Dir] = append(byDir[fetchContext.Avatars.Dir], fetchContext)
+		collect[fetchContext.Avatars.Dir] = collect[fetchContext.Avatars.Dir] || fetchContext.AvatarGC
+	}
+
+	for dir, sharing := range byDir {
+		if !collect[dir] {
+			continue
+		}
+		var photos []string
+		for _, fetchContext := range sharing {
+			contextPhotos, err := storedPhotos(fetchContext)
+			if err != nil {
+				return fmt.Errorf("cannot collect avatars of %s: %w", fetchContext.Domain, err)
+			}
+			photos = append(photos, contextPhotos...)
+		}
+		removed, err := avatars.GC(dir, sharing[0].Avatars.URLPrefix, photos, AvatarGrace)
+		if err != nil {
+			return err
+		}
+		if len(removed) > 0 {
+			log.Info("Removed unused avatars", "dir", dir, "count", len(removed))
+		}
+	}
+	return nil
+}
+
+// storedPhotos returns the author photos of the mentions the context stored, kept private or holds for review.
+func storedPhotos(fetchContext *Context) ([]string, error) {
+	var photos []string
+	add := func(mentionsByPath map[string][]webmention.Mention) {
+		for _, mentions := range mentionsByPath {
+			for _, mention := range mentions {
+				photos = append(photos, mention.Author.Photo)
+			}
+		}
+	}
+
+	mentionsByPath, err := webmention.LoadAll(fetchContext.Destination)
+	if err != nil {
+		return nil, err
+	}
+	add(mentionsByPath)
+	if fetchContext.Private != nil {
+		privateMentions, err := fetchContext.Private.LoadAll()
+		if err != nil {
+			return nil, err
+		}
+		add(privateMentions)
+	}
+	if fetchContext.HeldFile != "" {
+		queue, err := moderation.LoadQueue(fetchContext.HeldFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, held := range queue.Mentions {
+			photos = append(photos, held.Mention.Author.Photo)
+		}
+	}
+	return photos, nil
+}
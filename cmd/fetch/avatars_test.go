@@ -0,0 +1,76 @@
+package fetch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blbecker/webmentionR/avatars"
+	"github.com/blbecker/webmentionR/moderation"
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_CollectAvatars(t *testing.T) {
+	Convey("Given two domains mirroring into one avatar directory", t, func() {
+		webmention.WriteFileFunc = os.WriteFile
+		webmention.ReadFileFunc = os.ReadFile
+		moderation.ReadFileFunc = os.ReadFile
+		moderation.WriteFileFunc = os.WriteFile
+		AvatarGrace = 0
+
+		dir := t.TempDir()
+		avatarDir := filepath.Join(dir, "static", "avatars")
+		So(os.MkdirAll(avatarDir, 0755), ShouldBeNil)
+		for _, name := range []string{"1111111111111111.png", "2222222222222222.png", "3333333333333333.png", "4444444444444444.png"} {
+			So(os.WriteFile(filepath.Join(avatarDir, name), []byte("x"), 0644), ShouldBeNil)
+		}
+
+		newContext := func(name string) *Context {
+			destination := filepath.Join(dir, name)
+			So(os.Mkdir(destination, 0755), ShouldBeNil)
+			return &Context{
+				Domain:      name + ".example",
+				Destination: destination,
+				HeldFile:    moderation.QueuePath(destination),
+				Avatars:     &avatars.Mirror{Dir: avatarDir, URLPrefix: "/avatars/"},
+			}
+		}
+		blog, notes := newContext("blog"), newContext("notes")
+		So(webmention.Save(filepath.Join(blog.Destination, "post.json"), []webmention.Mention{
+			{WMID: 1, Author: webmention.Author{Photo: "/avatars/1111111111111111.png"}},
+		}), ShouldBeNil)
+		So(webmention.Save(filepath.Join(notes.Destination, "note.json"), []webmention.Mention{
+			{WMID: 2, Author: webmention.Author{Photo: "/avatars/2222222222222222.png"}},
+		}), ShouldBeNil)
+		So(moderation.Enqueue(notes.HeldFile, []moderation.Held{
+			{Mention: webmention.Mention{WMID: 3, Author: webmention.Author{Photo: "/avatars/3333333333333333.png"}}},
+		}), ShouldBeNil)
+
+		remaining := func() []string {
+			entries, err := os.ReadDir(avatarDir)
+			So(err, ShouldBeNil)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			return names
+		}
+
+		Convey("Nothing is removed unless a domain collects", func() {
+			So(CollectAvatars([]*Context{blog, notes}), ShouldBeNil)
+			So(remaining(), ShouldHaveLength, 4)
+		})
+
+		Convey("Avatars used by either domain's stored or held mentions are kept", func() {
+			blog.AvatarGC = true
+			So(CollectAvatars([]*Context{blog, notes}), ShouldBeNil)
+			So(remaining(), ShouldResemble, []string{"1111111111111111.png", "2222222222222222.png", "3333333333333333.png"})
+		})
+
+		Reset(func() {
+			AvatarGrace = time.Hour
+		})
+	})
+}
@@ -1,13 +1,32 @@
 package fetch
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"github.com/blbecker/webmentionR/authors"
+	"github.com/blbecker/webmentionR/avatars"
+	"github.com/blbecker/webmentionR/config"
+	"github.com/blbecker/webmentionR/hooks"
+	"github.com/blbecker/webmentionR/mastodon"
+	"github.com/blbecker/webmentionR/metrics"
+	"github.com/blbecker/webmentionR/moderation"
+	"github.com/blbecker/webmentionR/notify"
+	"github.com/blbecker/webmentionR/private"
+	"github.com/blbecker/webmentionR/sanitize"
+	"github.com/blbecker/webmentionR/spam"
 	"github.com/blbecker/webmentionR/state"
 	"github.com/blbecker/webmentionR/webmention"
 	"github.com/charmbracelet/log"
 	"github.com/urfave/cli/v2"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"text/template"
+	"time"
 )
 
 var FetchFunc = webmention.DoFetch
@@ -20,113 +39,1190 @@ var Command = cli.Command{
 	Action:  fetchAction,
 	Flags: []cli.Flag{
 		&cli.StringFlag{
-			Name:     "token",
-			Aliases:  []string{"t"},
-			Required: true,
+			Name:    "config",
+			Aliases: []string{"c"},
+			Usage:   "path to the config file (default: $XDG_CONFIG_HOME/webmentionR/config.yaml)",
+			EnvVars: config.EnvVars("config"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "profile",
+			Aliases: []string{"p"},
+			Usage:   "named profile to use from the config file, may be repeated to fetch several domains",
+			EnvVars: config.EnvVars("profile"),
+		},
+		&cli.BoolFlag{
+			Name:    "all-profiles",
+			Usage:   "fetch every profile in the config file",
+			EnvVars: config.EnvVars("all-profiles"),
+		},
+		&cli.IntFlag{
+			Name:    "parallelism",
+			Usage:   "maximum number of domains fetched at the same time",
+			Value:   2,
+			EnvVars: config.EnvVars("parallelism"),
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			Aliases: []string{"t"},
+			Usage:   "webmention.io API token; prefer one of the other token sources to keep it out of shell history",
+			EnvVars: config.EnvVars("token"),
+		},
+		&cli.StringFlag{
+			Name:    "token-env",
+			Usage:   "name of an environment variable holding the token",
+			EnvVars: config.EnvVars("token-env"),
+		},
+		&cli.StringFlag{
+			Name:    "token-file",
+			Usage:   "path to a file holding the token",
+			EnvVars: config.EnvVars("token-file"),
 		},
 		&cli.StringFlag{
-			Name:     "domain",
-			Aliases:  []string{"d"},
-			Required: true,
+			Name:    "token-command",
+			Usage:   "shell command printing the token on stdout, e.g. 'pass show webmention.io'",
+			EnvVars: config.EnvVars("token-command"),
 		},
 		&cli.StringFlag{
-			Name:     "destination",
-			Aliases:  []string{"D"},
-			Required: true,
+			Name:    "domain",
+			Aliases: []string{"d"},
+			EnvVars: config.EnvVars("domain"),
+		},
+		&cli.StringFlag{
+			Name:    "destination",
+			Aliases: []string{"D"},
+			EnvVars: config.EnvVars("destination"),
 		},
 		&cli.StringFlag{
 			Name:    "state-file",
 			Aliases: []string{"s"},
 			Value:   "./fetch.webmentions.state",
+			EnvVars: config.EnvVars("state-file"),
 		},
 		&cli.IntFlag{
-			Name:  "page-size",
-			Value: 10,
+			Name:    "page-size",
+			Value:   10,
+			EnvVars: config.EnvVars("page-size"),
+		},
+		&cli.BoolFlag{
+			Name:    "threads",
+			Usage:   "also write nested reply threads for each target",
+			EnvVars: config.EnvVars("threads"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "stage",
+			Usage:   "filter or transform fetched mentions before they are saved, in the order given: drop-private, drop-property=P[,P], rewrite-target=FROM=>TO or trim-content[=N]",
+			EnvVars: config.EnvVars("stage"),
+		},
+		&cli.StringFlag{
+			Name:    "moderation-rules",
+			Usage:   "path to a YAML file of rules that drop, hold or hide mentions by source, author, content or wm-property",
+			EnvVars: config.EnvVars("moderation-rules"),
+		},
+		&cli.StringFlag{
+			Name:    "moderation-log",
+			Usage:   "append a JSON line to this file for every mention a moderation rule caught",
+			EnvVars: config.EnvVars("moderation-log"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "sanitize-allow",
+			Usage:   "tag, or tag:attribute,attribute, kept by the HTML sanitizer; replaces the built-in allowlist",
+			EnvVars: config.EnvVars("sanitize-allow"),
+		},
+		&cli.BoolFlag{
+			Name:    "no-sanitize",
+			Usage:   "save the HTML content of mentions as received, without sanitizing it",
+			EnvVars: config.EnvVars("no-sanitize"),
+		},
+		&cli.BoolFlag{
+			Name:    "sanitize-keep-raw",
+			Usage:   "keep the HTML as received in the content's raw-html when sanitizing changed it; it is saved to the destination",
+			EnvVars: config.EnvVars("sanitize-keep-raw"),
+		},
+		&cli.BoolFlag{
+			Name:    "spam-score",
+			Usage:   "score mentions for spam from 0 to 100 and save the score with them, without holding or dropping any",
+			EnvVars: config.EnvVars("spam-score"),
+		},
+		&cli.IntFlag{
+			Name:    "spam-threshold",
+			Usage:   "score mentions for spam from 0 to 100 and hold or drop those scoring at least this, 0 to only score them with --spam-score",
+			EnvVars: config.EnvVars("spam-threshold"),
+		},
+		&cli.StringFlag{
+			Name:    "spam-action",
+			Usage:   "what to do with mentions reaching the spam threshold: hold or drop",
+			Value:   "hold",
+			EnvVars: config.EnvVars("spam-action"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "spam-phrase",
+			Usage:   "phrase adding to the spam score of mentions containing it, in addition to the built-in ones",
+			EnvVars: config.EnvVars("spam-phrase"),
+		},
+		&cli.StringFlag{
+			Name:    "held-file",
+			Usage:   "queue of mentions held for review (default: the destination directory followed by .held.json)",
+			EnvVars: config.EnvVars("held-file"),
+		},
+		&cli.StringFlag{
+			Name:    "blocklist",
+			Usage:   "file of rejected senders whose mentions are dropped (default: the destination directory followed by .blocklist)",
+			EnvVars: config.EnvVars("blocklist"),
+		},
+		&cli.StringFlag{
+			Name:    "private-dir",
+			Usage:   "directory private mentions are saved to instead of the destination (default: the destination directory followed by .private)",
+			EnvVars: config.EnvVars("private-dir"),
+		},
+		&cli.StringFlag{
+			Name:    "private-passphrase",
+			Usage:   "encrypt the private mentions with a key derived from this passphrase; prefer one of the other passphrase sources to keep it out of shell history",
+			EnvVars: config.EnvVars("private-passphrase"),
+		},
+		&cli.StringFlag{
+			Name:    "private-passphrase-env",
+			Usage:   "name of an environment variable holding the private mention passphrase",
+			EnvVars: config.EnvVars("private-passphrase-env"),
+		},
+		&cli.StringFlag{
+			Name:    "private-passphrase-file",
+			Usage:   "path to a file holding the private mention passphrase",
+			EnvVars: config.EnvVars("private-passphrase-file"),
+		},
+		&cli.StringFlag{
+			Name:    "private-passphrase-command",
+			Usage:   "shell command printing the private mention passphrase on stdout",
+			EnvVars: config.EnvVars("private-passphrase-command"),
+		},
+		&cli.StringFlag{
+			Name:    "private-key-file",
+			Usage:   "encrypt the private mentions with the hex-encoded 32-byte key in this file",
+			EnvVars: config.EnvVars("private-key-file"),
+		},
+		&cli.StringFlag{
+			Name:    "avatar-dir",
+			Usage:   "mirror author photos into this directory of the site's static files",
+			EnvVars: config.EnvVars("avatar-dir"),
+		},
+		&cli.StringFlag{
+			Name:    "avatar-url-prefix",
+			Usage:   "URL path the site serves --avatar-dir at, prepended to the mirrored file names",
+			Value:   avatars.DefaultURLPrefix,
+			EnvVars: config.EnvVars("avatar-url-prefix"),
+		},
+		&cli.IntFlag{
+			Name:    "avatar-size",
+			Usage:   "width and height in pixels mirrored author photos are cropped and scaled to",
+			Value:   avatars.DefaultSize,
+			EnvVars: config.EnvVars("avatar-size"),
+		},
+		&cli.StringFlag{
+			Name:    "avatar-fallback",
+			Usage:   "generate an avatar for authors without a photo into --avatar-dir, either initials or identicon",
+			EnvVars: config.EnvVars("avatar-fallback"),
+		},
+		&cli.BoolFlag{
+			Name:    "avatar-gc",
+			Usage:   "remove mirrored author photos no stored mention uses any more after the run",
+			EnvVars: config.EnvVars("avatar-gc"),
+		},
+		&cli.BoolFlag{
+			Name:    "authors",
+			Usage:   "give every mention a stable author-id and keep an index of the authors' latest name and photo",
+			EnvVars: config.EnvVars("authors"),
+		},
+		&cli.StringFlag{
+			Name:    "authors-index",
+			Usage:   "path of the authors index (default: the destination directory followed by .authors.json)",
+			EnvVars: config.EnvVars("authors-index"),
+		},
+		&cli.StringFlag{
+			Name:    "author-aliases",
+			Usage:   "path to a YAML file mapping the URLs each author posts from to one author",
+			EnvVars: config.EnvVars("author-aliases"),
+		},
+		&cli.StringFlag{
+			Name:    "mastodon-statuses",
+			Usage:   "path to a YAML file mapping post URLs to the Mastodon statuses they were syndicated to, whose favourites, boosts and replies are imported",
+			EnvVars: config.EnvVars("mastodon-statuses"),
+		},
+		&cli.StringFlag{
+			Name:    "mastodon-token",
+			Usage:   "access token authenticating the Mastodon API requests; prefer one of the other token sources to keep it out of shell history",
+			EnvVars: config.EnvVars("mastodon-token"),
+		},
+		&cli.StringFlag{
+			Name:    "mastodon-instance",
+			Usage:   "base URL of the Mastodon server the token belongs to, the only one it is sent to, e.g. https://mastodon.social",
+			EnvVars: config.EnvVars("mastodon-instance"),
+		},
+		&cli.StringFlag{
+			Name:    "mastodon-token-env",
+			Usage:   "name of an environment variable holding the Mastodon access token",
+			EnvVars: config.EnvVars("mastodon-token-env"),
+		},
+		&cli.StringFlag{
+			Name:    "mastodon-token-file",
+			Usage:   "path to a file holding the Mastodon access token",
+			EnvVars: config.EnvVars("mastodon-token-file"),
+		},
+		&cli.StringFlag{
+			Name:    "mastodon-token-command",
+			Usage:   "shell command printing the Mastodon access token on stdout",
+			EnvVars: config.EnvVars("mastodon-token-command"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "hook-exec",
+			Usage:   "shell command run with a JSON description of the changes on stdin after new or updated mentions are saved",
+			EnvVars: config.EnvVars("hook-exec"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "hook-url",
+			Usage:   "URL to POST a JSON description of the changes to after new or updated mentions are saved",
+			EnvVars: config.EnvVars("hook-url"),
+		},
+		&cli.StringFlag{
+			Name:    "notify-template",
+			Usage:   "path to a text/template for notification messages; the first line is the email subject",
+			EnvVars: config.EnvVars("notify-template"),
+		},
+		&cli.BoolFlag{
+			Name:    "notify-private",
+			Usage:   "include private mentions in notifications",
+			EnvVars: config.EnvVars("notify-private"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "notify-webhook",
+			Usage:   "URL to POST a message listing each run's new mentions to",
+			EnvVars: config.EnvVars("notify-webhook"),
+		},
+		&cli.StringFlag{
+			Name:    "notify-webhook-format",
+			Usage:   "shape of the webhook body: json, slack, discord or matrix",
+			Value:   "json",
+			EnvVars: config.EnvVars("notify-webhook-format"),
+		},
+		&cli.StringFlag{
+			Name:    "notify-webhook-template",
+			Usage:   "path to a text/template rendering the webhook body, replacing --notify-webhook-format",
+			EnvVars: config.EnvVars("notify-webhook-template"),
+		},
+		&cli.StringFlag{
+			Name:    "notify-smtp-addr",
+			Usage:   "host:port of the SMTP server to email each run's new mentions through",
+			EnvVars: config.EnvVars("notify-smtp-addr"),
+		},
+		&cli.StringFlag{
+			Name:    "notify-smtp-from",
+			EnvVars: config.EnvVars("notify-smtp-from"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "notify-smtp-to",
+			EnvVars: config.EnvVars("notify-smtp-to"),
+		},
+		&cli.StringFlag{
+			Name:    "notify-smtp-username",
+			EnvVars: config.EnvVars("notify-smtp-username"),
+		},
+		&cli.StringFlag{
+			Name:    "notify-smtp-password",
+			Usage:   "SMTP password; prefer one of the other password sources to keep it out of shell history",
+			EnvVars: config.EnvVars("notify-smtp-password"),
+		},
+		&cli.StringFlag{
+			Name:    "notify-smtp-password-env",
+			Usage:   "name of an environment variable holding the SMTP password",
+			EnvVars: config.EnvVars("notify-smtp-password-env"),
+		},
+		&cli.StringFlag{
+			Name:    "notify-smtp-password-file",
+			Usage:   "path to a file holding the SMTP password",
+			EnvVars: config.EnvVars("notify-smtp-password-file"),
+		},
+		&cli.StringFlag{
+			Name:    "notify-smtp-password-command",
+			Usage:   "shell command printing the SMTP password on stdout",
+			EnvVars: config.EnvVars("notify-smtp-password-command"),
+		},
+		&cli.StringFlag{
+			Name:    "report",
+			Usage:   "write a report of the run to this file, or to stdout when '-'",
+			EnvVars: config.EnvVars("report"),
+		},
+		&cli.StringFlag{
+			Name:    "report-format",
+			Usage:   "format of the --report: json or markdown",
+			Value:   "json",
+			EnvVars: config.EnvVars("report-format"),
+		},
+		&cli.StringFlag{
+			Name:    "metrics-textfile",
+			Usage:   "write Prometheus metrics to this file after the run, for the node_exporter textfile collector",
+			EnvVars: config.EnvVars("metrics-textfile"),
 		},
 	},
 }
 
 type Context struct {
+	Profile     string
+	Domain      string
+	Token       string
+	Destination string
+	StateFile   string
+	State       *state.State
+	PageSize    int
+	Threads     bool
+	Hooks       []hooks.Hook
+	// Pipeline filters and transforms every fetched mention before it is persisted.
+	Pipeline webmention.Pipeline
+	// HeldFile is the queue mentions held by the pipeline wait in for review.
+	HeldFile string
+	// Private, when set, stores the mentions flagged wm-private instead of the destination.
+	Private   *private.Store
+	Notifiers []notify.Notifier
+	// NotifyPrivate includes private mentions in notifications.
+	NotifyPrivate bool
+	// Avatars, when set, mirrors author photos as the pipeline's last stage; AvatarGC removes unused ones.
+	Avatars  *avatars.Mirror
+	AvatarGC bool
+	// Authors, when set, resolves the author of every mention as the pipeline's last stage; the index of the authors
+	// is saved to AuthorsIndex after each run.
+	Authors      *authors.Registry
+	AuthorsIndex string
+	// MastodonStatuses, when set, is the mapping file of the statuses whose interactions are imported after the
+	// webmention.io mentions in every run, authenticated with MastodonToken on MastodonInstance only.
+	MastodonStatuses string
+	MastodonToken    string
+	MastodonInstance string
+	// NotifyTemplate renders the message sent to Notifiers, notify.DefaultMessage when nil.
+	NotifyTemplate *template.Template
+	// Metrics, when set, collects the Prometheus metrics of every run. Contexts created together share it.
+	Metrics *metrics.Registry
+	// Subscribers receive the events of every run.
+	Subscribers []webmention.Subscriber
+	// Err, when set, is why the profile couldn't be set up. Its domain isn't fetched and Err is recorded as the
+	// outcome of its runs instead.
+	Err error
+}
+
+// Result summarises the run for one profile.
+type Result struct {
+	Profile  string
 	Domain   string
-	Token    string
-	State    *state.State
-	PageSize int
+	Metrics  webmention.MetricsResponse
+	Fetched  int
+	New      int
+	Updated  int
+	Targets  int
+	Started  time.Time
+	Duration time.Duration
+	Err      error
+	// Pages is the number of API requests sent and TargetStats what persisting changed for each target.
+	Pages       int
+	TargetStats []webmention.PersistStats
+	// Filtered counts the mentions the pipeline dropped, per target, but for the private ones kept in the private
+	// store, and Held those it queued for review.
+	Filtered map[string]int
+	Held     int
+	// Private counts the private mentions stored for the first time.
+	Private int
+	// Warnings are the failures after the mentions were saved and the cursor advanced, like failing hooks or
+	// notifiers, which don't fail the run.
+	Warnings []string
 }
 
-// NewFetchContext constructs a fetch context representative of the passed cli.Context.
+// NewFetchContexts constructs a fetch context for every profile selected on the passed cli.Context. Settings not
+// given as flags or environment variables are taken from each profile of the config file. Profiles sharing a state
+// file share its State. A profile that cannot be set up still gets a context, carrying the reason in Err.
+func NewFetchContexts(cliContext *cli.Context) ([]*Context, error) {
+	configFile, err := config.Load(cliContext.String("config"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot load config file: %w", err)
+	}
+	profiles, err := configFile.Select(cliContext.StringSlice("profile"), cliContext.Bool("all-profiles"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot select profile: %w", err)
+	}
+
+	states := map[string]*state.State{}
+	registry := metrics.NewRegistry()
+	var fetchContexts []*Context
+	for name, profile := range profiles {
+		settings := config.NewSettings(cliContext, profile)
+		fetchContext, err := newFetchContext(cliContext, name, settings, states)
+		if err != nil {
+			// A profile that cannot be set up fails on its own, the others are still fetched.
+			fetchContext = failedContext(name, settings, states, fmt.Errorf("profile %s: %w", name, err))
+		}
+		fetchContext.Metrics = registry
+		fetchContexts = append(fetchContexts, fetchContext)
+	}
+
+	sort.Slice(fetchContexts, func(i, j int) bool {
+		return fetchContexts[i].Profile < fetchContexts[j].Profile
+	})
+	return fetchContexts, nil
+}
+
+// NewFetchContext constructs a fetch context representative of the passed cli.Context, which must select a single
+// profile.
 func NewFetchContext(cliContext *cli.Context) (*Context, error) {
-	stateFilePath := cliContext.String("fetchState-file")
-	fetchState, err := state.ReadState(stateFilePath)
+	fetchContexts, err := NewFetchContexts(cliContext)
+	if err != nil {
+		return nil, err
+	}
+	if len(fetchContexts) != 1 {
+		return nil, fmt.Errorf("expected a single profile, got %d", len(fetchContexts))
+	}
+	if fetchContexts[0].Err != nil {
+		return nil, fetchContexts[0].Err
+	}
+	return fetchContexts[0], nil
+}
+
+// failedContext is the context of a profile that couldn't be set up because of err. It keeps the domain and the
+// state, when it can be read, so the failure is recorded in the domain's history.
+func failedContext(profile string, settings *config.Settings, states map[string]*state.State, err error) *Context {
+	fetchContext := &Context{Profile: profile, Domain: settings.String("domain"), Err: err}
+	stateFilePath := settings.String("state-file")
+	fetchState, ok := states[stateFilePath]
+	if !ok {
+		var readErr error
+		if fetchState, readErr = state.ReadState(stateFilePath); readErr != nil {
+			return fetchContext
+		}
+		states[stateFilePath] = fetchState
+	}
+	fetchContext.StateFile = stateFilePath
+	fetchContext.State = fetchState
+	return fetchContext
+}
+
+// ValidateAll validates every context that was set up, marking the invalid ones failed.
+func ValidateAll(fetchContexts []*Context) {
+	for _, fetchContext := range fetchContexts {
+		if fetchContext.Err != nil {
+			continue
+		}
+		if err := fetchContext.Validate(); err != nil {
+			fetchContext.Err = fmt.Errorf("profile %s: %w", fetchContext.Profile, err)
+		}
+	}
+}
+
+func newFetchContext(cliContext *cli.Context, profile string, settings *config.Settings, states map[string]*state.State) (*Context, error) {
+	token, err := settings.Secret("token").Resolve(cliContext.Context)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve token: %w", err)
+	}
+
+	stateFilePath := settings.String("state-file")
+	fetchState, ok := states[stateFilePath]
+	if !ok {
+		fetchState, err = state.ReadState(stateFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read fetchState file: %w", err)
+		}
+		states[stateFilePath] = fetchState
+	}
+
+	fetchContext, err := NewProfileContext(cliContext.Context, profile, settings)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read fetchState file: %w", err)
+		return nil, err
 	}
+	fetchContext.Token = token
+	fetchContext.StateFile = stateFilePath
+	fetchContext.State = fetchState
+	fetchContext.PageSize = settings.Int("page-size")
+	fetchContext.MastodonStatuses = settings.String("mastodon-statuses")
+	fetchContext.MastodonToken, err = settings.Secret("mastodon-token").Resolve(cliContext.Context)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve Mastodon token: %w", err)
+	}
+	fetchContext.MastodonInstance, err = mastodonInstance(settings.String("mastodon-instance"))
+	if err != nil {
+		return nil, err
+	}
+	if fetchContext.MastodonToken != "" && fetchContext.MastodonInstance == "" {
+		return nil, fmt.Errorf("a Mastodon token requires --mastodon-instance, the server it belongs to")
+	}
+	return fetchContext, nil
+}
 
+// NewProfileContext constructs the part of the fetch context of profile that processes and stores mentions: the
+// destination, the pipeline, the stores it feeds and the hooks and notifiers told about what was stored. It resolves
+// only the secrets of the private mention passphrase and the notifiers and reads no state, for commands storing
+// mentions they didn't fetch, like review approve.
+func NewProfileContext(ctx context.Context, profile string, settings *config.Settings) (*Context, error) {
 	fetchContext := Context{
-		Domain:   cliContext.String("domain"),
-		Token:    cliContext.String("token"),
-		PageSize: cliContext.Int("page-size"),
-		State:    fetchState,
+		Profile:     profile,
+		Domain:      settings.String("domain"),
+		Destination: settings.String("destination"),
+		Threads:     settings.Bool("threads"),
+	}
+	var err error
+	fetchContext.Pipeline, err = webmention.ParsePipeline(settings.StringSlice("stage"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot build pipeline: %w", err)
+	}
+	// Every mention is tagged with its network before the configured stages see it.
+	fetchContext.Pipeline = append(webmention.Pipeline{webmention.ClassifyNetwork{}}, fetchContext.Pipeline...)
+	fetchContext.HeldFile = settings.String("held-file")
+	if fetchContext.HeldFile == "" && fetchContext.Destination != "" {
+		fetchContext.HeldFile = moderation.QueuePath(fetchContext.Destination)
+	}
+	moderator, err := newModerator(settings, fetchContext.Destination)
+	if err != nil {
+		return nil, err
+	}
+	scorer, err := newScorer(settings, fetchContext.Domain)
+	if err != nil {
+		return nil, err
+	}
+	if scorer != nil {
+		fetchContext.Pipeline = append(webmention.Pipeline{scorer}, fetchContext.Pipeline...)
+	}
+	if moderator != nil {
+		// Moderation sees mentions before any stage but the sanitizer rewrites them.
+		fetchContext.Pipeline = append(webmention.Pipeline{moderator}, fetchContext.Pipeline...)
+	}
+	// Sanitizing comes first, so even held mentions, which review may publish, are stored sanitized.
+	if !settings.Bool("no-sanitize") {
+		allow := settings.StringSlice("sanitize-allow")
+		if len(allow) == 0 {
+			allow = sanitize.DefaultAllow
+		}
+		policy, err := sanitize.ParsePolicy(allow)
+		if err != nil {
+			return nil, fmt.Errorf("cannot build sanitizer: %w", err)
+		}
+		sanitizer := sanitize.Sanitizer{Policy: policy, KeepRaw: settings.Bool("sanitize-keep-raw")}
+		fetchContext.Pipeline = append(webmention.Pipeline{sanitizer}, fetchContext.Pipeline...)
+	}
+	fetchContext.Private, err = newPrivateStore(ctx, settings, fetchContext.Destination)
+	if err != nil {
+		return nil, err
 	}
-	return &fetchContext, err
+	fetchContext.NotifyPrivate = settings.Bool("notify-private")
+	fetchContext.Hooks = hooks.FromConfig(settings.StringSlice("hook-exec"), settings.StringSlice("hook-url"))
+	fetchContext.NotifyTemplate, err = notify.LoadTemplate(settings.String("notify-template"), notify.DefaultMessage)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load notification template: %w", err)
+	}
+	fetchContext.Notifiers, err = newNotifiers(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+	fallback, err := avatars.ParseFallback(settings.String("avatar-fallback"))
+	if err != nil {
+		return nil, err
+	}
+	if dir := settings.String("avatar-dir"); dir != "" {
+		fetchContext.Avatars = &avatars.Mirror{
+			Dir:       dir,
+			URLPrefix: settings.String("avatar-url-prefix"),
+			Size:      settings.Int("avatar-size"),
+			Fallback:  fallback,
+		}
+		// Mirroring comes last, so photos of dropped mentions aren't downloaded.
+		fetchContext.Pipeline = append(fetchContext.Pipeline, fetchContext.Avatars)
+		fetchContext.AvatarGC = settings.Bool("avatar-gc")
+	} else if fallback != avatars.FallbackNone {
+		return nil, errors.New("--avatar-fallback needs --avatar-dir to write the avatars to")
+	}
+	if settings.Bool("authors") {
+		fetchContext.AuthorsIndex = settings.String("authors-index")
+		if fetchContext.AuthorsIndex == "" {
+			fetchContext.AuthorsIndex = authors.IndexPath(fetchContext.Destination)
+		}
+		fetchContext.Authors, err = newAuthors(settings, fetchContext.AuthorsIndex)
+		if err != nil {
+			return nil, err
+		}
+		// Authors are resolved after avatars are mirrored, so the index refers to the local photos.
+		fetchContext.Pipeline = append(fetchContext.Pipeline, fetchContext.Authors)
+	}
+	return &fetchContext, nil
 }
 
-// fetchAction is an adapter for doFetch implementing cli.ActionFunc for use in a cli.Command
+// newModerator builds the moderator applying the blocklist followed by the moderation rules, nil when there are
+// neither.
+func newModerator(settings *config.Settings, destination string) (*moderation.Moderator, error) {
+	blocklistPath := settings.String("blocklist")
+	if blocklistPath == "" && destination != "" {
+		blocklistPath = moderation.BlocklistPath(destination)
+	}
+	var rules []moderation.Rule
+	if blocklistPath != "" {
+		senders, err := moderation.LoadBlocklist(blocklistPath)
+		if err != nil {
+			return nil, err
+		}
+		rules = moderation.BlocklistRules(senders)
+	}
+	if path := settings.String("moderation-rules"); path != "" {
+		loaded, err := moderation.Load(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load moderation rules: %w", err)
+		}
+		rules = append(rules, loaded.Rules...)
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	moderator, err := moderation.New(rules)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load moderation rules: %w", err)
+	}
+	moderator.LogPath = settings.String("moderation-log")
+	if moderator.NeedsKnown() && destination != "" {
+		moderator.Known, err = moderation.KnownSenders(destination)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load stored mentions: %w", err)
+		}
+	}
+	return moderator, nil
+}
+
+// newAuthors builds the author registry from the index at indexPath and the configured alias mappings.
+func newAuthors(settings *config.Settings, indexPath string) (*authors.Registry, error) {
+	var aliases []authors.Alias
+	if path := settings.String("author-aliases"); path != "" {
+		var err error
+		if aliases, err = authors.LoadAliases(path); err != nil {
+			return nil, err
+		}
+	}
+	index, err := authors.LoadIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	return authors.NewRegistry(index, aliases), nil
+}
+
+// newPrivateStore builds the store of private mentions, nil when there is neither a private directory nor a
+// destination to put it next to.
+func newPrivateStore(ctx context.Context, settings *config.Settings, destination string) (*private.Store, error) {
+	dir := settings.String("private-dir")
+	if dir == "" {
+		if destination == "" {
+			return nil, nil
+		}
+		dir = private.Dir(destination)
+	}
+	passphrase, err := settings.Secret("private-passphrase").Resolve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve private mention passphrase: %w", err)
+	}
+	cipher, err := private.NewCipher(passphrase, settings.String("private-key-file"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot set up private mention encryption: %w", err)
+	}
+	return &private.Store{Dir: dir, Cipher: cipher}, nil
+}
+
+// mastodonInstance returns the base URL of the Mastodon server instance names, which may be a bare host name.
+func mastodonInstance(instance string) (string, error) {
+	instance = strings.TrimSpace(instance)
+	if instance == "" {
+		return "", nil
+	}
+	if !strings.Contains(instance, "://") {
+		instance = "https://" + instance
+	}
+	parsed, err := url.Parse(instance)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return "", fmt.Errorf("invalid Mastodon instance '%s', expected e.g. https://mastodon.social", instance)
+	}
+	return parsed.Scheme + "://" + parsed.Host, nil
+}
+
+// newScorer builds the spam scorer of domain, nil when neither spam scoring nor a spam threshold is set. With a
+// threshold of 0 the scorer only scores.
+func newScorer(settings *config.Settings, domain string) (*spam.Scorer, error) {
+	threshold := max(settings.Int("spam-threshold"), 0)
+	if threshold == 0 && !settings.Bool("spam-score") {
+		return nil, nil
+	}
+	verdict, err := spam.ParseVerdict(settings.String("spam-action"))
+	if err != nil {
+		return nil, err
+	}
+	return &spam.Scorer{
+		Domain:    domain,
+		Threshold: threshold,
+		Verdict:   verdict,
+		Phrases:   append(slices.Clone(spam.DefaultPhrases), settings.StringSlice("spam-phrase")...),
+	}, nil
+}
+
+// newNotifiers builds a webhook notifier per notify-webhook URL and an email notifier when an SMTP server is set.
+func newNotifiers(ctx context.Context, settings *config.Settings) ([]notify.Notifier, error) {
+	var notifiers []notify.Notifier
+	if webhookURLs := settings.StringSlice("notify-webhook"); len(webhookURLs) > 0 {
+		var body *template.Template
+		var err error
+		if path := settings.String("notify-webhook-template"); path != "" {
+			body, err = notify.LoadTemplate(path, "")
+		} else {
+			body, err = notify.WebhookFormat(settings.String("notify-webhook-format"))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot load webhook template: %w", err)
+		}
+		for _, webhookURL := range webhookURLs {
+			notifiers = append(notifiers, notify.Webhook{URL: webhookURL, Template: body})
+		}
+	}
+	if addr := settings.String("notify-smtp-addr"); addr != "" {
+		password, err := settings.Secret("notify-smtp-password").Resolve(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve SMTP password: %w", err)
+		}
+		notifiers = append(notifiers, notify.Email{
+			Addr:     addr,
+			From:     settings.String("notify-smtp-from"),
+			To:       settings.StringSlice("notify-smtp-to"),
+			Username: settings.String("notify-smtp-username"),
+			Password: password,
+		})
+	}
+	return notifiers, nil
+}
+
+// Validate reports settings that are required but were given neither as flags, environment variables nor in the
+// config file.
+func (fetchContext *Context) Validate() error {
+	var missing []string
+	if fetchContext.Domain == "" {
+		missing = append(missing, "domain")
+	}
+	if fetchContext.Token == "" {
+		missing = append(missing, "token")
+	}
+	if fetchContext.Destination == "" {
+		missing = append(missing, "destination")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("required settings not provided: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// fetchAction is an adapter for Fetch implementing cli.ActionFunc for use in a cli.Command
 func fetchAction(context *cli.Context) error {
-	fetchContext, err := NewFetchContext(context)
+	fetchContexts, err := NewFetchContexts(context)
 	if err != nil {
 		return fmt.Errorf("cannot create fetch context: %w", err)
 	}
-	return doFetch(fetchContext)
+	ValidateAll(fetchContexts)
+	reportFormat := context.String("report-format")
+	if !slices.Contains(ReportFormats, reportFormat) {
+		return fmt.Errorf("unknown report format '%s', expected one of %v", reportFormat, ReportFormats)
+	}
+
+	started := time.Now()
+	results := fetchAll(context.Context, fetchContexts, context.Int("parallelism"))
+	logSummary(results)
+
+	var errs []error
+	if err := WriteStates(fetchContexts); err != nil {
+		errs = append(errs, err)
+	}
+	if err := WriteMetrics(fetchContexts, context.String("metrics-textfile")); err != nil {
+		errs = append(errs, err)
+	}
+	if err := CollectAvatars(fetchContexts); err != nil {
+		errs = append(errs, err)
+	}
+	if path := context.String("report"); path != "" {
+		if err := WriteReport(path, reportFormat, NewReport(started, time.Now(), results)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", cmp.Or(result.Domain, result.Profile), result.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WriteStates writes the state file of every context, once per file.
+func WriteStates(fetchContexts []*Context) error {
+	written := map[string]bool{}
+	for _, fetchContext := range fetchContexts {
+		if fetchContext.StateFile == "" || written[fetchContext.StateFile] {
+			continue
+		}
+		written[fetchContext.StateFile] = true
+		if err := state.WriteState(fetchContext.StateFile, fetchContext.State); err != nil {
+			return fmt.Errorf("cannot write state file: %w", err)
+		}
+	}
+	return nil
+}
+
+// fetchAll runs Fetch for every context, at most parallelism at a time. A failing domain doesn't stop the others, and
+// a context that couldn't be set up fails with its error without being fetched.
+func fetchAll(ctx context.Context, fetchContexts []*Context, parallelism int) []Result {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	results := make([]Result, len(fetchContexts))
+	semaphore := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, fetchContext := range fetchContexts {
+		if fetchContext.Err != nil {
+			results[i] = fetchContext.FailedResult()
+			fetchContext.RecordRun(results[i])
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			result, err := Fetch(ctx, fetchContext, NewClient(fetchContext))
+			result.Err = err
+			results[i] = result
+			fetchContext.RecordRun(result)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// WriteMetrics writes the metrics shared by fetchContexts to path, doing nothing when path is empty.
+func WriteMetrics(fetchContexts []*Context, path string) error {
+	if path == "" || len(fetchContexts) == 0 || fetchContexts[0].Metrics == nil {
+		return nil
+	}
+	if err := fetchContexts[0].Metrics.WriteTextfile(path); err != nil {
+		return fmt.Errorf("cannot write metrics: %w", err)
+	}
+	return nil
 }
 
-// doFetch implements the webmention retrieval and persistence functionality.
-func doFetch(fetchContext *Context) error {
+// NewClient returns a webmention.io client for the context's domain, starting at its stored cursor.
+func NewClient(fetchContext *Context) webmention.Client {
 	client := webmention.Client{
 		Domain:   fetchContext.Domain,
 		Token:    fetchContext.Token,
-		SinceID:  fetchContext.State.SinceID,
+		SinceID:  fetchContext.State.SinceIDFor(fetchContext.Domain),
 		PageSize: fetchContext.PageSize,
 	}
+	if fetchContext.Metrics != nil {
+		client.RequestObserver = fetchContext.Metrics
+	}
+	return client
+}
+
+// RecordRun adds the result to the domain's history in the fetch state and to the metrics.
+// A context without state or domain, which failed before either was known, records nothing.
+func (fetchContext *Context) RecordRun(result Result) {
+	if fetchContext.State == nil || fetchContext.Domain == "" {
+		return
+	}
+	fetchContext.State.RecordRun(fetchContext.Domain, result.run())
+	if fetchContext.Metrics != nil {
+		fetchContext.Metrics.RecordRun(fetchContext.Domain, result.Started.Add(result.Duration), result.Err)
+	}
+}
+
+// run converts the result into the record kept in the state file's history.
+// FailedResult is the result of a context that couldn't be set up, failing with its error.
+func (fetchContext *Context) FailedResult() Result {
+	return Result{Profile: fetchContext.Profile, Domain: fetchContext.Domain, Started: time.Now(), Err: fetchContext.Err}
+}
+
+func (result Result) run() state.Run {
+	run := state.Run{
+		Started:  result.Started,
+		Finished: result.Started.Add(result.Duration),
+		Fetched:  result.Fetched,
+		New:      result.New,
+		Updated:  result.Updated,
+	}
+	if result.Err != nil {
+		run.Error = result.Err.Error()
+	}
+	return run
+}
+
+// logSummary logs one line per domain followed by the totals of the run.
+func logSummary(results []Result) {
+	var mentions, targets, failed int
+	for _, result := range results {
+		logger := log.With("profile", result.Profile, "domain", result.Domain, "mentions", result.Fetched,
+			"new", result.New, "updated", result.Updated, "targets", result.Targets,
+			"duration", result.Duration.Round(time.Millisecond))
+		if result.Err != nil {
+			failed++
+			logger.Error("Fetch failed", "err", result.Err)
+		} else {
+			logger.Info("Fetch finished", "maxID", result.Metrics.MaxID)
+		}
+		for _, warning := range result.Warnings {
+			logger.Warn("Fetch warning", "warning", warning)
+		}
+		mentions += result.Fetched
+		targets += result.Targets
+	}
+	log.Info("Run summary", "domains", len(results), "failed", failed, "mentions", mentions, "targets", targets)
+}
+
+// Fetch implements the webmention retrieval and persistence functionality for one domain, fetching with client from
+// its SinceID. On success the domain's cursor in the fetch state is advanced past the newest mention seen.
+func Fetch(ctx context.Context, fetchContext *Context, client webmention.Client) (Result, error) {
+	started := time.Now()
+	result := Result{Profile: fetchContext.Profile, Domain: fetchContext.Domain, Started: started,
+		Filtered: map[string]int{}}
+
+	bus := webmention.NewBus(fetchContext.Domain)
+	for _, subscriber := range fetchContext.Subscribers {
+		bus.Subscribe(subscriber)
+	}
+	bus.Publish(webmention.RunStarted{Domain: fetchContext.Domain, SinceID: client.SinceID, Time: started})
+	// finish publishes the outcome of the run. A failure of stage is published as an Error first; failures already
+	// published are passed with an empty stage.
+	finish := func(stage string, err error) (Result, error) {
+		result.Duration = time.Since(started)
+		if err != nil && stage != "" {
+			bus.Publish(webmention.Error{Domain: fetchContext.Domain, Stage: stage, Err: err})
+		}
+		bus.Publish(webmention.RunFinished{Domain: fetchContext.Domain, Fetched: result.Fetched, New: result.New,
+			Updated: result.Updated, Duration: result.Duration, Err: err})
+		return result, err
+	}
+
+	// The mapping is read up front, so a broken one fails the run before anything is fetched.
+	var mastodonSource *mastodon.Source
+	if fetchContext.MastodonStatuses != "" {
+		statuses, err := mastodon.LoadStatuses(fetchContext.MastodonStatuses)
+		if err != nil {
+			return finish("mastodon", err)
+		}
+		mastodonSource = &mastodon.Source{Statuses: statuses, Token: fetchContext.MastodonToken,
+			Instance: fetchContext.MastodonInstance, Context: ctx,
+			Seen: fetchContext.State.MastodonSeen(fetchContext.Domain)}
+	}
+
 	mentionChan := make(chan webmention.Mention, 10)
 
+	pages := &pageCounter{next: client.RequestObserver}
+	client.RequestObserver = pages
+
 	observer := webmention.MetricsObserver{}
-	var fetchWorker webmention.FetchWorker
+	fetchWorker := webmention.FetchWorker{Bus: bus}
 	fetchWorker.AddObserver(&observer)
+	if fetchContext.Metrics != nil {
+		fetchWorker.AddObserver(fetchContext.Metrics.MentionObserver(fetchContext.Domain))
+	}
 
-	var fetchErr error
+	fetchErrChan := make(chan error, 1)
 	go func() {
-		fetchErr = FetchFunc(context.TODO(), client, mentionChan, &fetchWorker)
+		fetchErrChan <- FetchFunc(ctx, client, mentionChan, &fetchWorker)
 	}()
 
+	mentionsByTarget := map[string][]webmention.Mention{}
+	var held []moderation.Held
+	var privateMentions []webmention.Mention
+	maxID := client.SinceID
+	receive := func(thisWebmention webmention.Mention) {
+		// Dropped mentions still advance the cursor, so they aren't fetched again.
+		maxID = max(maxID, thisWebmention.WMID)
+		result.Fetched++
+
+		processed, verdict, stage := fetchContext.Pipeline.Process(thisWebmention)
+		switch {
+		case verdict == webmention.Drop:
+			log.Debug("Dropped mention", "WMID", thisWebmention.WMID, "stage", stage)
+			// The report lists the targets of filtered mentions, so dropped private ones aren't counted.
+			if !processed.WMPrivate || fetchContext.Private == nil {
+				result.Filtered[processed.WMTarget]++
+			}
+			return
+		case processed.WMPrivate && fetchContext.Private != nil:
+			// The review queue is stored in plain text and approving publishes, so private mentions are never held.
+			privateMentions = append(privateMentions, processed)
+			return
+		case verdict == webmention.Hold:
+			log.Info("Holding mention for review", "WMID", thisWebmention.WMID, "stage", stage)
+			held = append(held, moderation.Held{Mention: processed, Domain: fetchContext.Domain,
+				Reason: heldReason(processed, stage), Held: time.Now()})
+			return
+		}
+		mentionsByTarget[processed.WMTarget] = append(mentionsByTarget[processed.WMTarget], processed)
+	}
+	for thisWebmention := range mentionChan {
+		receive(thisWebmention)
+	}
+
+	fetchErr := <-fetchErrChan
+	result.Pages = pages.count()
 	if fetchErr != nil {
-		return fmt.Errorf("error fetching webmentions: %v", fetchErr)
+		return finish("fetch", fmt.Errorf("error fetching webmentions: %v", fetchErr))
+	}
+	// Mastodon interactions have negative synthetic wm-ids, so importing them leaves the cursor alone. A failed import
+	// still lets the webmention.io mentions be saved, and is reported as a warning.
+	var mastodonErr error
+	if mastodonSource != nil {
+		mastodonChan := make(chan webmention.Mention, 10)
+		go func() {
+			fetchErrChan <- fetchWorker.DoFetch(ctx, mastodonSource, mastodonChan)
+		}()
+		for thisWebmention := range mastodonChan {
+			receive(thisWebmention)
+		}
+		if err := <-fetchErrChan; err != nil {
+			mastodonErr = fmt.Errorf("error importing Mastodon interactions: %w", err)
+		}
 	}
 
-	mentionsByTarget := map[string][]webmention.Mention{}
-	for thisWebmention := range mentionChan {
-		mentionsByTarget[thisWebmention.WMTarget] = append(mentionsByTarget[thisWebmention.WMTarget], thisWebmention)
+	// Held mentions advance the cursor too, so they must be queued before it is.
+	if err := moderation.Enqueue(fetchContext.HeldFile, held); err != nil {
+		return finish("hold", fmt.Errorf("error queueing held mentions: %w", err))
+	}
+	result.Held = len(held)
+	// Private mentions are kept out of the destination, the hooks and, unless asked for, the notifications.
+	var storedPrivate []webmention.Mention
+	if fetchContext.Private != nil {
+		var err error
+		if storedPrivate, err = fetchContext.Private.Save(privateMentions, fetchContext.Pipeline.Derived()); err != nil {
+			return finish("private", fmt.Errorf("error saving private mentions: %w", err))
+		}
 	}
+	result.Private = len(storedPrivate)
 
-	persistenceWorker := webmention.PersistenceWorker{}
+	persistenceWorker := webmention.PersistenceWorker{
+		Destination:  fetchContext.Destination,
+		WriteThreads: fetchContext.Threads,
+		Derived:      fetchContext.Pipeline.Derived(),
+		Bus:          bus,
+	}
+	notifications := newNotifications(fetchContext, bus, storedPrivate)
 	var wg sync.WaitGroup
-	var persistenceErr error
+	persistenceErrs := make(chan error, len(mentionsByTarget))
 	for _, mentions := range mentionsByTarget {
 		wg.Add(1)
 		go func() {
-			err := PersistFunc(mentions, &wg, &persistenceWorker)
-
-			// If any errors occur, retain it for bubble up
-			if persistenceErr == nil {
-				persistenceErr = err
-			}
+			persistenceErrs <- PersistFunc(mentions, &wg, &persistenceWorker)
 		}()
 	}
 
 	wg.Wait()
+	// If any errors occur, retain the first for bubble up
+	var persistenceErr error
+	for range mentionsByTarget {
+		if err := <-persistenceErrs; err != nil && persistenceErr == nil {
+			persistenceErr = err
+		}
+	}
+	result.Metrics = observer.GetMetrics()
+	result.Targets = len(mentionsByTarget)
+	persistStats := persistenceWorker.Stats()
+	result.TargetStats = persistStats
+	for _, stats := range persistStats {
+		result.New += len(stats.New)
+		result.Updated += len(stats.Updated)
+	}
 	if persistenceErr != nil {
-		return fmt.Errorf("error persisting webmentions: %v", persistenceErr)
+		return finish("persist", fmt.Errorf("error persisting webmentions: %v", persistenceErr))
+	}
+	if fetchContext.Authors != nil {
+		if err := fetchContext.Authors.Save(fetchContext.AuthorsIndex); err != nil {
+			return finish("authors", fmt.Errorf("error saving authors index: %w", err))
+		}
 	}
 
-	log.Info("Collected metrics: ", "metrics", observer.GetMetrics())
-	return nil
+	if maxID > client.SinceID {
+		fetchContext.State.SetSinceID(fetchContext.Domain, maxID)
+	}
+	if mastodonSource != nil {
+		fetchContext.State.SetMastodonSeen(fetchContext.Domain, mastodonSource.Imported())
+	}
+	log.Info("Collected metrics", "domain", fetchContext.Domain, "maxID", result.Metrics.MaxID,
+		"seen", result.Metrics.MentionsSeen, "unique", len(result.Metrics.UniqueMentions),
+		"topTargets", webmention.TopN(result.Metrics.ByTarget, 3),
+		"byProperty", webmention.TopN(result.Metrics.ByProperty, -1),
+		"byNetwork", webmention.TopN(result.Metrics.ByNetwork, -1))
+
+	if mastodonErr != nil {
+		bus.Publish(webmention.Error{Domain: fetchContext.Domain, Stage: "mastodon", Err: mastodonErr})
+		result.Warnings = append(result.Warnings, mastodonErr.Error())
+	}
+	// The mentions are saved and the cursor advanced, so failing hooks or notifiers are reported without undoing either.
+	// Neither leaves anything to retry in the next fetch, so they are warnings rather than a failed run.
+	result.Warnings = append(result.Warnings, announce(ctx, fetchContext, bus, notifications, persistStats)...)
+	return finish("", nil)
+}
+
+// newNotifications subscribes the notifications of fetchContext to bus, which are sent by Flush. The private
+// mentions stored for the first time are added when fetchContext notifies about private mentions.
+func newNotifications(fetchContext *Context, bus *webmention.Bus, storedPrivate []webmention.Mention) *notify.Observer {
+	notifications := &notify.Observer{
+		Domain:    fetchContext.Domain,
+		Notifiers: fetchContext.Notifiers,
+		Template:  fetchContext.NotifyTemplate,
+	}
+	bus.Subscribe(notifications)
+	if fetchContext.NotifyPrivate {
+		for _, mention := range storedPrivate {
+			notifications.Update(mention)
+		}
+	}
+	return notifications
+}
+
+// announce runs the hooks of fetchContext with what persisting changed and sends the notifications. Their failures
+// are published on bus and returned as warnings, as the mentions are saved by then.
+func announce(ctx context.Context, fetchContext *Context, bus *webmention.Bus, notifications *notify.Observer,
+	persistStats []webmention.PersistStats) []string {
+	var warnings []string
+	if err := hooks.Run(ctx, fetchContext.Hooks, hooks.NewPayload(fetchContext.Domain, persistStats)); err != nil {
+		err = fmt.Errorf("error running hooks: %w", err)
+		bus.Publish(webmention.Error{Domain: fetchContext.Domain, Stage: "hooks", Err: err})
+		warnings = append(warnings, err.Error())
+	}
+	if err := notifications.Flush(ctx); err != nil {
+		err = fmt.Errorf("error sending notifications: %w", err)
+		bus.Publish(webmention.Error{Domain: fetchContext.Domain, Stage: "notify", Err: err})
+		warnings = append(warnings, err.Error())
+	}
+	return warnings
+}
+
+// heldReason names what held mention: the matching moderation rule, or the stage.
+func heldReason(mention webmention.Mention, stage webmention.Stage) string {
+	if moderator, ok := stage.(*moderation.Moderator); ok {
+		if rule := moderator.Match(mention); rule != nil {
+			return rule.Name
+		}
+	}
+	return fmt.Sprint(stage)
+}
+
+// pageCounter counts the API requests of one run, passing each on to next.
+type pageCounter struct {
+	next  webmention.RequestObserver
+	mu    sync.Mutex
+	pages int
+}
+
+func (c *pageCounter) ObserveRequest(domain string, duration time.Duration, err error) {
+	c.mu.Lock()
+	c.pages++
+	c.mu.Unlock()
+	if c.next != nil {
+		c.next.ObserveRequest(domain, duration, err)
+	}
+}
+
+func (c *pageCounter) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pages
 }
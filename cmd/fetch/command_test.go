@@ -3,11 +3,27 @@ package fetch
 import (
 	c "context"
 	"flag"
+	"fmt"
+	"github.com/blbecker/webmentionR/authors"
+	"github.com/blbecker/webmentionR/avatars"
+	"github.com/blbecker/webmentionR/hooks"
+	"github.com/blbecker/webmentionR/mastodon"
+	"github.com/blbecker/webmentionR/moderation"
+	"github.com/blbecker/webmentionR/notify"
+	"github.com/blbecker/webmentionR/private"
+	"github.com/blbecker/webmentionR/sanitize"
+	"github.com/blbecker/webmentionR/spam"
+	"github.com/blbecker/webmentionR/state"
 	"github.com/blbecker/webmentionR/webmention"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/urfave/cli/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 )
 
 func Test_FetchAction(t *testing.T) {
@@ -26,6 +42,146 @@ func Test_NewFetchContext(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(fetchContext, ShouldNotBeNil)
 		})
+		Convey("fills settings missing from the command line from the config file", func() {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			configData := "profiles:\n  blog:\n    domain: blog.example.com\n    token: file-token\n    destination: data/blog\n"
+			So(os.WriteFile(configPath, []byte(configData), 0600), ShouldBeNil)
+
+			set := flag.NewFlagSet("test", flag.ContinueOnError)
+			for _, f := range Command.Flags {
+				So(f.Apply(set), ShouldBeNil)
+			}
+			So(set.Parse([]string{"--config", configPath, "--profile", "blog", "--token", "flag-token", "--state-file", ""}), ShouldBeNil)
+			context := cli.NewContext(nil, set, nil)
+
+			fetchContext, err := NewFetchContext(context)
+			So(err, ShouldBeNil)
+			So(fetchContext.Domain, ShouldEqual, "blog.example.com")
+			So(fetchContext.Token, ShouldEqual, "flag-token")
+			So(fetchContext.Destination, ShouldEqual, "data/blog")
+			So(fetchContext.HeldFile, ShouldEqual, "data/blog.held.json")
+			So(fetchContext.Private.Dir, ShouldEqual, "data/blog.private")
+			So(fetchContext.Validate(), ShouldBeNil)
+		})
+		Convey("sanitizes mentions, then scores them for spam before the other stages when a threshold is set", func() {
+			set := flag.NewFlagSet("test", flag.ContinueOnError)
+			for _, f := range Command.Flags {
+				So(f.Apply(set), ShouldBeNil)
+			}
+			So(set.Parse([]string{"--domain", "example.com", "--state-file", "", "--spam-threshold", "60",
+				"--stage", "drop-private"}), ShouldBeNil)
+
+			fetchContext, err := NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldBeNil)
+			So(fetchContext.Pipeline, ShouldHaveLength, 4)
+			So(fetchContext.Pipeline[0], ShouldHaveSameTypeAs, sanitize.Sanitizer{})
+			scorer, ok := fetchContext.Pipeline[1].(*spam.Scorer)
+			So(ok, ShouldBeTrue)
+			So(scorer.Threshold, ShouldEqual, 60)
+			So(scorer.Verdict, ShouldEqual, webmention.Hold)
+			So(fetchContext.Pipeline[2], ShouldHaveSameTypeAs, webmention.ClassifyNetwork{})
+
+			So(set.Set("no-sanitize", "true"), ShouldBeNil)
+			fetchContext, err = NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldBeNil)
+			So(fetchContext.Pipeline, ShouldHaveLength, 3)
+
+			So(set.Set("spam-action", "hide"), ShouldBeNil)
+			_, err = NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldNotBeNil)
+		})
+		Convey("only scores mentions for spam when scoring is enabled without a threshold", func() {
+			set := flag.NewFlagSet("test", flag.ContinueOnError)
+			for _, f := range Command.Flags {
+				So(f.Apply(set), ShouldBeNil)
+			}
+			So(set.Parse([]string{"--domain", "example.com", "--state-file", "", "--no-sanitize"}), ShouldBeNil)
+			fetchContext, err := NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldBeNil)
+			So(fetchContext.Pipeline, ShouldHaveLength, 1)
+
+			So(set.Set("spam-score", "true"), ShouldBeNil)
+			fetchContext, err = NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldBeNil)
+			So(fetchContext.Pipeline, ShouldHaveLength, 2)
+			scorer, ok := fetchContext.Pipeline[0].(*spam.Scorer)
+			So(ok, ShouldBeTrue)
+			So(scorer.Threshold, ShouldEqual, 0)
+
+			mention, verdict := scorer.Process(webmention.Mention{WMTarget: "https://example.com/post", Name: "casino"})
+			So(verdict, ShouldEqual, webmention.Keep)
+			So(mention.SpamScore, ShouldEqual, 70)
+		})
+		Convey("reads the SMTP password from its secret source", func() {
+			passwordPath := filepath.Join(t.TempDir(), "smtp-password")
+			So(os.WriteFile(passwordPath, []byte("hunter2\n"), 0600), ShouldBeNil)
+			set := flag.NewFlagSet("test", flag.ContinueOnError)
+			for _, f := range Command.Flags {
+				So(f.Apply(set), ShouldBeNil)
+			}
+			So(set.Parse([]string{"--domain", "example.com", "--state-file", "", "--notify-smtp-addr", "mail.example:587",
+				"--notify-smtp-password-file", passwordPath}), ShouldBeNil)
+
+			fetchContext, err := NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldBeNil)
+			So(fetchContext.Notifiers, ShouldHaveLength, 1)
+			So(fetchContext.Notifiers[0].(notify.Email).Password, ShouldEqual, "hunter2")
+
+			So(set.Set("notify-smtp-password", "plain"), ShouldBeNil)
+			_, err = NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldNotBeNil)
+		})
+		Convey("reads the private mention passphrase from its secret source", func() {
+			passphrasePath := filepath.Join(t.TempDir(), "passphrase")
+			So(os.WriteFile(passphrasePath, []byte("correct horse\n"), 0600), ShouldBeNil)
+			set := flag.NewFlagSet("test", flag.ContinueOnError)
+			for _, f := range Command.Flags {
+				So(f.Apply(set), ShouldBeNil)
+			}
+			So(set.Parse([]string{"--domain", "example.com", "--state-file", "", "--destination", "data/blog",
+				"--private-passphrase-file", passphrasePath}), ShouldBeNil)
+
+			fetchContext, err := NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldBeNil)
+			So(fetchContext.Private.Cipher, ShouldNotBeNil)
+
+			So(set.Set("private-passphrase", "plain"), ShouldBeNil)
+			_, err = NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldNotBeNil)
+		})
+		Convey("resolves authors after mirroring avatars, as the last stages", func() {
+			set := flag.NewFlagSet("test", flag.ContinueOnError)
+			for _, f := range Command.Flags {
+				So(f.Apply(set), ShouldBeNil)
+			}
+			So(set.Parse([]string{"--domain", "example.com", "--state-file", "", "--avatar-fallback", "identicon"}), ShouldBeNil)
+			_, err := NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldNotBeNil)
+
+			So(set.Set("avatar-dir", "static/avatars"), ShouldBeNil)
+			fetchContext, err := NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldBeNil)
+			So(fetchContext.Pipeline[len(fetchContext.Pipeline)-1], ShouldEqual, fetchContext.Avatars)
+			So(fetchContext.Avatars.Fallback, ShouldEqual, avatars.FallbackIdenticon)
+
+			So(set.Set("authors", "true"), ShouldBeNil)
+			So(set.Set("destination", "data/blog"), ShouldBeNil)
+			fetchContext, err = NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldBeNil)
+			So(fetchContext.Pipeline[len(fetchContext.Pipeline)-1], ShouldEqual, fetchContext.Authors)
+			So(fetchContext.AuthorsIndex, ShouldEqual, "data/blog.authors.json")
+		})
+	})
+}
+
+func Test_Validate(t *testing.T) {
+	Convey("Validating a fetch context reports missing required settings", t, func() {
+		err := (&Context{Domain: "example.com"}).Validate()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "token, destination")
+
+		err = (&Context{Domain: "example.com", Token: "t", Destination: "data"}).Validate()
+		So(err, ShouldBeNil)
 	})
 }
 
@@ -40,7 +196,7 @@ type MockPersistWorker struct {
 	WantedErr         error
 }
 
-func Test_doFetch(t *testing.T) {
+func Test_Fetch(t *testing.T) {
 	Convey("Fetching with reasonable input doesn't produce an error", t, func() {
 		flags := flag.NewFlagSet("test", flag.ContinueOnError)
 		cliContext := cli.NewContext(nil, flags, nil)
@@ -72,12 +228,211 @@ func Test_doFetch(t *testing.T) {
 			return pw.WantedErr
 		}
 		So(err, ShouldBeNil)
-		err = doFetch(fetchContext)
+		result, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
 		So(err, ShouldBeNil)
+		So(result.Targets, ShouldEqual, 1)
 		So(len(fw.WantedMentions), ShouldEqual, len(pw.ReceivedMentions))
 		for _, mention := range fw.WantedMentions {
-			So(pw.ReceivedMentions, ShouldContain, mention)
+			classified, _ := webmention.ClassifyNetwork{}.Process(mention)
+			So(pw.ReceivedMentions, ShouldContain, classified)
 		}
+
+		Convey("Hooks are not fired when nothing was persisted", func() {
+			hook := &recordingHook{}
+			fetchContext.Hooks = []hooks.Hook{hook}
+			_, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldBeNil)
+			So(hook.fired, ShouldEqual, 0)
+		})
+
+		Convey("Authors are resolved and their index saved", func() {
+			authors.ReadFileFunc = os.ReadFile
+			authors.WriteFileFunc = os.WriteFile
+			fetchContext.Authors = authors.NewRegistry(nil, nil)
+			fetchContext.AuthorsIndex = filepath.Join(t.TempDir(), "webmentions.authors.json")
+			fetchContext.Pipeline = append(fetchContext.Pipeline, fetchContext.Authors)
+			fw.WantedMentions = []webmention.Mention{{WMID: 2, Author: webmention.Author{URL: "https://ada.example"}}}
+
+			_, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldBeNil)
+			So(pw.ReceivedMentions[0].AuthorID, ShouldNotBeEmpty)
+			index, err := authors.LoadIndex(fetchContext.AuthorsIndex)
+			So(err, ShouldBeNil)
+			So(index, ShouldHaveLength, 1)
+			So(index[0].ID, ShouldEqual, pw.ReceivedMentions[0].AuthorID)
+		})
+	})
+}
+
+type recordingHook struct {
+	fired int
+	err   error
+}
+
+func (h *recordingHook) Fire(_ c.Context, _ hooks.Payload) error {
+	h.fired++
+	return h.err
+}
+
+func Test_fetchAll(t *testing.T) {
+	Convey("Fetching several domains where one fails", t, func() {
+		sharedState := &state.State{}
+		fetchContexts := []*Context{
+			{Profile: "bad", Domain: "bad.example.com", State: sharedState},
+			{Profile: "blog", Domain: "blog.example.com", State: sharedState},
+			{Profile: "notes", Domain: "notes.example.com", State: sharedState},
+		}
+		FetchFunc = func(ctx c.Context, client webmention.Client, mentionChan chan webmention.Mention, worker webmention.Fetchable) error {
+			defer close(mentionChan)
+			client.RequestObserver.ObserveRequest(client.Domain, time.Millisecond, nil)
+			if client.Domain == "bad.example.com" {
+				return fmt.Errorf("unauthorized")
+			}
+			mentionChan <- webmention.Mention{WMID: len(client.Domain), WMTarget: "https://" + client.Domain + "/post"}
+			return nil
+		}
+		PersistFunc = func(fetchedMentions []webmention.Mention, s *sync.WaitGroup, persistable webmention.Persistable) error {
+			defer s.Done()
+			return nil
+		}
+
+		results := fetchAll(c.Background(), fetchContexts, 2)
+
+		Convey("every domain produces a result", func() {
+			So(results, ShouldHaveLength, 3)
+			So(results[0].Err, ShouldNotBeNil)
+			So(results[1].Err, ShouldBeNil)
+			So(results[2].Err, ShouldBeNil)
+			So(results[1].Targets, ShouldEqual, 1)
+		})
+
+		Convey("the API requests of every domain are counted", func() {
+			for _, result := range results {
+				So(result.Pages, ShouldEqual, 1)
+			}
+		})
+
+		Convey("only the successful domains advance their cursor", func() {
+			So(sharedState.SinceIDFor("bad.example.com"), ShouldEqual, 0)
+			So(sharedState.SinceIDFor("blog.example.com"), ShouldEqual, len("blog.example.com"))
+			So(sharedState.SinceIDFor("notes.example.com"), ShouldEqual, len("notes.example.com"))
+		})
+
+		Convey("every domain's run is recorded in the state", func() {
+			So(sharedState.Domain("bad.example.com").LastError, ShouldContainSubstring, "unauthorized")
+			So(sharedState.Domain("bad.example.com").LastSuccess.IsZero(), ShouldBeTrue)
+			blog := sharedState.Domain("blog.example.com")
+			So(blog.LastError, ShouldBeEmpty)
+			So(blog.LastSuccess.IsZero(), ShouldBeFalse)
+			So(blog.History, ShouldHaveLength, 1)
+			So(blog.History[0].Fetched, ShouldEqual, 1)
+		})
+	})
+}
+
+func Test_NewFetchContexts(t *testing.T) {
+	Convey("Selecting every profile of a config file", t, func() {
+		dir := t.TempDir()
+		configPath := filepath.Join(dir, "config.yaml")
+		statePath := filepath.Join(dir, "shared.state")
+		configData := fmt.Sprintf(`profiles:
+  blog:
+    domain: blog.example.com
+    token: blog-token
+    destination: data/blog
+    state-file: %[1]s
+  notes:
+    domain: notes.example.com
+    token: notes-token
+    destination: data/notes
+    state-file: %[1]s
+`, statePath)
+		So(os.WriteFile(configPath, []byte(configData), 0600), ShouldBeNil)
+
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		for _, f := range Command.Flags {
+			So(f.Apply(set), ShouldBeNil)
+		}
+		So(set.Parse([]string{"--config", configPath, "--all-profiles"}), ShouldBeNil)
+
+		fetchContexts, err := NewFetchContexts(cli.NewContext(nil, set, nil))
+
+		So(err, ShouldBeNil)
+		So(fetchContexts, ShouldHaveLength, 2)
+		So(fetchContexts[0].Profile, ShouldEqual, "blog")
+		So(fetchContexts[0].Token, ShouldEqual, "blog-token")
+		So(fetchContexts[1].Domain, ShouldEqual, "notes.example.com")
+		So(fetchContexts[0].State, ShouldPointTo, fetchContexts[1].State)
+	})
+
+	Convey("Selecting profiles where one cannot be set up", t, func() {
+		dir := t.TempDir()
+		configPath := filepath.Join(dir, "config.yaml")
+		statePath := filepath.Join(dir, "shared.state")
+		configData := fmt.Sprintf(`profiles:
+  blog:
+    domain: blog.example.com
+    token-file: %[2]s
+    destination: data/blog
+    state-file: %[1]s
+  notes:
+    domain: notes.example.com
+    token: notes-token
+    destination: data/notes
+    state-file: %[1]s
+`, statePath, filepath.Join(dir, "missing-token"))
+		So(os.WriteFile(configPath, []byte(configData), 0600), ShouldBeNil)
+
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		for _, f := range Command.Flags {
+			So(f.Apply(set), ShouldBeNil)
+		}
+		So(set.Parse([]string{"--config", configPath, "--all-profiles"}), ShouldBeNil)
+
+		fetchContexts, err := NewFetchContexts(cli.NewContext(nil, set, nil))
+
+		So(err, ShouldBeNil)
+		So(fetchContexts, ShouldHaveLength, 2)
+		So(fetchContexts[0].Err, ShouldNotBeNil)
+		So(fetchContexts[0].Err.Error(), ShouldContainSubstring, "profile blog")
+		So(fetchContexts[0].Domain, ShouldEqual, "blog.example.com")
+		So(fetchContexts[0].State, ShouldPointTo, fetchContexts[1].State)
+		So(fetchContexts[1].Err, ShouldBeNil)
+		So(fetchContexts[1].Token, ShouldEqual, "notes-token")
+
+		Convey("the failure is recorded while the other profile is fetched", func() {
+			FetchFunc = func(ctx c.Context, client webmention.Client, mentionChan chan webmention.Mention, worker webmention.Fetchable) error {
+				defer close(mentionChan)
+				mentionChan <- webmention.Mention{WMID: 7, WMTarget: "https://notes.example.com/post"}
+				return nil
+			}
+			PersistFunc = func(fetchedMentions []webmention.Mention, s *sync.WaitGroup, persistable webmention.Persistable) error {
+				defer s.Done()
+				return nil
+			}
+			Reset(func() {
+				FetchFunc = webmention.DoFetch
+				PersistFunc = webmention.DoPersist
+			})
+
+			results := fetchAll(c.Background(), fetchContexts, 2)
+
+			So(results, ShouldHaveLength, 2)
+			So(results[0].Err, ShouldEqual, fetchContexts[0].Err)
+			So(results[1].Err, ShouldBeNil)
+			So(results[1].Fetched, ShouldEqual, 1)
+			sharedState := fetchContexts[1].State
+			So(sharedState.Domain("blog.example.com").LastError, ShouldContainSubstring, "cannot resolve token")
+			So(sharedState.Domain("blog.example.com").History, ShouldHaveLength, 1)
+			So(sharedState.SinceIDFor("notes.example.com"), ShouldEqual, 7)
+		})
+
+		Convey("profiles failing validation are marked failed too", func() {
+			fetchContexts[1].Token = ""
+			ValidateAll(fetchContexts)
+			So(fetchContexts[0].Err.Error(), ShouldContainSubstring, "cannot resolve token")
+			So(fetchContexts[1].Err.Error(), ShouldContainSubstring, "profile notes: required settings not provided: token")
+		})
 	})
 }
 
@@ -86,3 +441,331 @@ func Test_fetchAction(t *testing.T) {
 
 	})
 }
+
+func Test_FetchEvents(t *testing.T) {
+	Convey("Given a subscriber to a domain's runs", t, func() {
+		var events []webmention.Event
+		fetchContext := &Context{
+			Domain: "example.com",
+			State:  &state.State{},
+			Subscribers: []webmention.Subscriber{webmention.SubscriberFunc(func(event webmention.Event) {
+				events = append(events, event)
+			})},
+		}
+		PersistFunc = func(fetchedMentions []webmention.Mention, s *sync.WaitGroup, persistable webmention.Persistable) error {
+			defer s.Done()
+			return nil
+		}
+
+		Convey("A successful run is framed by RunStarted and RunFinished", func() {
+			FetchFunc = func(ctx c.Context, client webmention.Client, mentionChan chan webmention.Mention, worker webmention.Fetchable) error {
+				defer close(mentionChan)
+				mentionChan <- webmention.Mention{WMID: 1, WMTarget: "https://example.com/post"}
+				return nil
+			}
+			_, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldBeNil)
+
+			So(events, ShouldHaveLength, 2)
+			So(events[0], ShouldHaveSameTypeAs, webmention.RunStarted{})
+			finished, ok := events[1].(webmention.RunFinished)
+			So(ok, ShouldBeTrue)
+			So(finished.Fetched, ShouldEqual, 1)
+			So(finished.Err, ShouldBeNil)
+		})
+
+		Convey("Mentions dropped by the pipeline are counted and still advance the cursor", func() {
+			fetchContext.Pipeline = webmention.Pipeline{webmention.DropProperty{Properties: []string{"like-of"}}}
+			FetchFunc = func(ctx c.Context, client webmention.Client, mentionChan chan webmention.Mention, worker webmention.Fetchable) error {
+				defer close(mentionChan)
+				mentionChan <- webmention.Mention{WMID: 1, WMTarget: "https://example.com/post", WMProperty: "in-reply-to"}
+				mentionChan <- webmention.Mention{WMID: 2, WMTarget: "https://example.com/post", WMProperty: "like-of"}
+				return nil
+			}
+			var persisted []webmention.Mention
+			PersistFunc = func(fetchedMentions []webmention.Mention, s *sync.WaitGroup, persistable webmention.Persistable) error {
+				defer s.Done()
+				persisted = fetchedMentions
+				return nil
+			}
+
+			result, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldBeNil)
+			So(result.Fetched, ShouldEqual, 2)
+			So(result.Filtered, ShouldResemble, map[string]int{"https://example.com/post": 1})
+			So(persisted, ShouldHaveLength, 1)
+			So(persisted[0].WMID, ShouldEqual, 1)
+			So(fetchContext.State.SinceIDFor("example.com"), ShouldEqual, 2)
+		})
+
+		Convey("Mentions held by the pipeline are queued for review instead of persisted", func() {
+			moderation.ReadFileFunc = os.ReadFile
+			moderation.WriteFileFunc = os.WriteFile
+			fetchContext.HeldFile = filepath.Join(t.TempDir(), "webmentions.held.json")
+			fetchContext.Pipeline = webmention.Pipeline{webmention.StageFunc(func(mention webmention.Mention) (webmention.Mention, webmention.Verdict) {
+				if mention.WMProperty == "in-reply-to" {
+					return mention, webmention.Hold
+				}
+				return mention, webmention.Keep
+			})}
+			FetchFunc = func(ctx c.Context, client webmention.Client, mentionChan chan webmention.Mention, worker webmention.Fetchable) error {
+				defer close(mentionChan)
+				mentionChan <- webmention.Mention{WMID: 1, WMTarget: "https://example.com/post", WMProperty: "like-of"}
+				mentionChan <- webmention.Mention{WMID: 2, WMTarget: "https://example.com/post", WMProperty: "in-reply-to"}
+				return nil
+			}
+			var persisted []webmention.Mention
+			PersistFunc = func(fetchedMentions []webmention.Mention, s *sync.WaitGroup, persistable webmention.Persistable) error {
+				defer s.Done()
+				persisted = fetchedMentions
+				return nil
+			}
+
+			result, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldBeNil)
+			So(result.Held, ShouldEqual, 1)
+			So(persisted, ShouldHaveLength, 1)
+			So(persisted[0].WMID, ShouldEqual, 1)
+			So(fetchContext.State.SinceIDFor("example.com"), ShouldEqual, 2)
+
+			queue, err := moderation.LoadQueue(fetchContext.HeldFile)
+			So(err, ShouldBeNil)
+			So(queue.Mentions, ShouldHaveLength, 1)
+			So(queue.Mentions[0].Mention.WMID, ShouldEqual, 2)
+			So(queue.Mentions[0].Domain, ShouldEqual, "example.com")
+		})
+
+		Convey("Private mentions are saved to the private store instead of the destination", func() {
+			private.ReadFileFunc = os.ReadFile
+			private.WriteFileFunc = os.WriteFile
+			private.MkdirAllFunc = os.MkdirAll
+			fetchContext.Private = &private.Store{Dir: filepath.Join(t.TempDir(), "webmentions.private")}
+			FetchFunc = func(ctx c.Context, client webmention.Client, mentionChan chan webmention.Mention, worker webmention.Fetchable) error {
+				defer close(mentionChan)
+				mentionChan <- webmention.Mention{WMID: 1, WMTarget: "https://example.com/post"}
+				mentionChan <- webmention.Mention{WMID: 2, WMTarget: "https://example.com/post", WMPrivate: true}
+				return nil
+			}
+			var persisted []webmention.Mention
+			PersistFunc = func(fetchedMentions []webmention.Mention, s *sync.WaitGroup, persistable webmention.Persistable) error {
+				defer s.Done()
+				persisted = fetchedMentions
+				return nil
+			}
+
+			result, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldBeNil)
+			So(result.Private, ShouldEqual, 1)
+			So(persisted, ShouldHaveLength, 1)
+			So(persisted[0].WMID, ShouldEqual, 1)
+			So(fetchContext.State.SinceIDFor("example.com"), ShouldEqual, 2)
+
+			stored, err := fetchContext.Private.LoadAll()
+			So(err, ShouldBeNil)
+			So(stored[filepath.Join(fetchContext.Private.Dir, "post.json")][0].WMID, ShouldEqual, 2)
+		})
+
+		Convey("Private mentions are kept out of the filtered counts, which list their targets", func() {
+			private.ReadFileFunc = os.ReadFile
+			private.WriteFileFunc = os.WriteFile
+			private.MkdirAllFunc = os.MkdirAll
+			fetchContext.Private = &private.Store{Dir: filepath.Join(t.TempDir(), "webmentions.private")}
+			fetchContext.Pipeline = webmention.Pipeline{webmention.DropProperty{Properties: []string{"like-of"}}}
+			FetchFunc = func(ctx c.Context, client webmention.Client, mentionChan chan webmention.Mention, worker webmention.Fetchable) error {
+				defer close(mentionChan)
+				mentionChan <- webmention.Mention{WMID: 1, WMTarget: "https://example.com/post", WMProperty: "like-of"}
+				mentionChan <- webmention.Mention{WMID: 2, WMTarget: "https://example.com/secret", WMProperty: "like-of",
+					WMPrivate: true}
+				mentionChan <- webmention.Mention{WMID: 3, WMTarget: "https://example.com/secret", WMPrivate: true}
+				return nil
+			}
+
+			result, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldBeNil)
+			So(result.Private, ShouldEqual, 1)
+			So(result.Filtered, ShouldResemble, map[string]int{"https://example.com/post": 1})
+
+			report := NewReport(result.Started, time.Now(), []Result{result})
+			So(report.Domains[0].Filtered, ShouldEqual, 1)
+			for _, target := range report.Domains[0].Targets {
+				So(target.Target, ShouldNotEqual, "https://example.com/secret")
+			}
+		})
+
+		Convey("Private mentions are saved to the private store rather than held for review", func() {
+			private.ReadFileFunc = os.ReadFile
+			private.WriteFileFunc = os.WriteFile
+			private.MkdirAllFunc = os.MkdirAll
+			moderation.ReadFileFunc = os.ReadFile
+			moderation.WriteFileFunc = os.WriteFile
+			fetchContext.Private = &private.Store{Dir: filepath.Join(t.TempDir(), "webmentions.private")}
+			fetchContext.HeldFile = filepath.Join(t.TempDir(), "webmentions.held.json")
+			fetchContext.Pipeline = webmention.Pipeline{webmention.StageFunc(func(mention webmention.Mention) (webmention.Mention, webmention.Verdict) {
+				return mention, webmention.Hold
+			})}
+			FetchFunc = func(ctx c.Context, client webmention.Client, mentionChan chan webmention.Mention, worker webmention.Fetchable) error {
+				defer close(mentionChan)
+				mentionChan <- webmention.Mention{WMID: 1, WMTarget: "https://example.com/post"}
+				mentionChan <- webmention.Mention{WMID: 2, WMTarget: "https://example.com/post", WMPrivate: true}
+				return nil
+			}
+
+			result, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldBeNil)
+			So(result.Held, ShouldEqual, 1)
+			So(result.Private, ShouldEqual, 1)
+
+			queue, err := moderation.LoadQueue(fetchContext.HeldFile)
+			So(err, ShouldBeNil)
+			So(queue.Mentions, ShouldHaveLength, 1)
+			So(queue.Mentions[0].Mention.WMID, ShouldEqual, 1)
+			stored, err := fetchContext.Private.LoadAll()
+			So(err, ShouldBeNil)
+			So(stored[filepath.Join(fetchContext.Private.Dir, "post.json")][0].WMID, ShouldEqual, 2)
+		})
+
+		Convey("A failing hook is published and reported as a warning without failing the run", func() {
+			load, save := webmention.LoadFunc, webmention.SaveFunc
+			Reset(func() { webmention.LoadFunc, webmention.SaveFunc = load, save })
+			webmention.LoadFunc = func(string) ([]webmention.Mention, error) { return nil, nil }
+			webmention.SaveFunc = func(string, []webmention.Mention) error { return nil }
+			PersistFunc = webmention.DoPersist
+			hook := &recordingHook{err: fmt.Errorf("build failed")}
+			fetchContext.Hooks = []hooks.Hook{hook}
+			FetchFunc = func(ctx c.Context, client webmention.Client, mentionChan chan webmention.Mention, worker webmention.Fetchable) error {
+				defer close(mentionChan)
+				mentionChan <- webmention.Mention{WMID: 1, WMTarget: "https://example.com/post"}
+				return nil
+			}
+
+			result, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldBeNil)
+			So(hook.fired, ShouldEqual, 1)
+			So(result.New, ShouldEqual, 1)
+			So(result.Warnings, ShouldHaveLength, 1)
+			So(result.Warnings[0], ShouldContainSubstring, "build failed")
+			So(events[len(events)-2].(webmention.Error).Stage, ShouldEqual, "hooks")
+			So(events[len(events)-1].(webmention.RunFinished).Err, ShouldBeNil)
+		})
+
+		Convey("A failed fetch publishes an Error before RunFinished", func() {
+			FetchFunc = func(ctx c.Context, client webmention.Client, mentionChan chan webmention.Mention, worker webmention.Fetchable) error {
+				close(mentionChan)
+				return fmt.Errorf("unauthorized")
+			}
+			_, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldNotBeNil)
+
+			So(events, ShouldHaveLength, 3)
+			failure, ok := events[1].(webmention.Error)
+			So(ok, ShouldBeTrue)
+			So(failure.Stage, ShouldEqual, "fetch")
+			So(failure.Err.Error(), ShouldContainSubstring, "unauthorized")
+			So(events[2].(webmention.RunFinished).Err, ShouldNotBeNil)
+		})
+	})
+}
+
+func Test_FetchMastodon(t *testing.T) {
+	Convey("Given a post syndicated to a Mastodon status with a favourite", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/v1/statuses/1/favourited_by":
+				_, _ = w.Write([]byte(`[{"id": "9", "username": "ada", "url": "https://mastodon.example/@ada"}]`))
+			case "/api/v1/statuses/1/context":
+				_, _ = w.Write([]byte(`{"descendants": []}`))
+			case "/api/v1/statuses/500/favourited_by":
+				w.WriteHeader(http.StatusInternalServerError)
+			default:
+				_, _ = w.Write([]byte(`[]`))
+			}
+		}))
+		defer server.Close()
+		mastodon.ReadFileFunc = os.ReadFile
+		statuses := filepath.Join(t.TempDir(), "statuses.yaml")
+		So(os.WriteFile(statuses, []byte("https://example.com/post: "+server.URL+"/@owner/1\n"), 0644), ShouldBeNil)
+
+		fetchContext, err := NewFetchContext(cli.NewContext(nil, flag.NewFlagSet("test", flag.ContinueOnError), nil))
+		So(err, ShouldBeNil)
+		fetchContext.Domain = "example.com"
+		fetchContext.MastodonStatuses = statuses
+		FetchFunc = func(ctx c.Context, client webmention.Client, mentionChan chan webmention.Mention, worker webmention.Fetchable) error {
+			defer close(mentionChan)
+			mentionChan <- webmention.Mention{WMID: 7, WMTarget: "https://example.com/other"}
+			return nil
+		}
+		var mu sync.Mutex
+		persisted := map[string][]webmention.Mention{}
+		PersistFunc = func(fetchedMentions []webmention.Mention, s *sync.WaitGroup, persistable webmention.Persistable) error {
+			defer s.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			persisted[fetchedMentions[0].WMTarget] = fetchedMentions
+			return nil
+		}
+
+		Convey("its interactions are imported after the webmention.io mentions without moving the cursor", func() {
+			result, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldBeNil)
+			So(result.Fetched, ShouldEqual, 2)
+			So(persisted["https://example.com/other"], ShouldHaveLength, 1)
+			So(persisted["https://example.com/post"], ShouldHaveLength, 1)
+			like := persisted["https://example.com/post"][0]
+			So(like.WMID, ShouldEqual, mastodon.SyntheticID("like-of "+server.URL+"/@owner/1#favorited-by-9"))
+			So(like.WMProperty, ShouldEqual, "like-of")
+			So(like.Network, ShouldEqual, webmention.NetworkMastodon)
+			So(fetchContext.State.SinceIDFor("example.com"), ShouldEqual, 7)
+		})
+
+		Convey("interactions imported in an earlier run aren't imported again", func() {
+			_, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldBeNil)
+			So(fetchContext.State.MastodonSeen("example.com"), ShouldResemble, map[string][]int{
+				server.URL + "/@owner/1": {mastodon.SyntheticID("like-of " + server.URL + "/@owner/1#favorited-by-9")}})
+
+			delete(persisted, "https://example.com/post")
+			result, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldBeNil)
+			So(result.Fetched, ShouldEqual, 1)
+			So(persisted, ShouldNotContainKey, "https://example.com/post")
+		})
+
+		Convey("a failing status is a warning once the webmention.io mentions are saved", func() {
+			So(os.WriteFile(statuses, []byte("https://example.com/post: "+server.URL+"/@owner/500\n"), 0644), ShouldBeNil)
+			result, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldBeNil)
+			So(persisted["https://example.com/other"], ShouldHaveLength, 1)
+			So(fetchContext.State.SinceIDFor("example.com"), ShouldEqual, 7)
+			So(result.Warnings, ShouldHaveLength, 1)
+			So(result.Warnings[0], ShouldContainSubstring, "error importing Mastodon interactions")
+		})
+
+		Convey("the access token is read from its secret source and needs the instance it belongs to", func() {
+			tokenPath := filepath.Join(t.TempDir(), "mastodon-token")
+			So(os.WriteFile(tokenPath, []byte("masto\n"), 0600), ShouldBeNil)
+			set := flag.NewFlagSet("test", flag.ContinueOnError)
+			for _, f := range Command.Flags {
+				So(f.Apply(set), ShouldBeNil)
+			}
+			So(set.Parse([]string{"--domain", "example.com", "--state-file", "", "--mastodon-token-file", tokenPath}), ShouldBeNil)
+			_, err := NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldNotBeNil)
+
+			So(set.Set("mastodon-instance", "mastodon.example"), ShouldBeNil)
+			fetchContext, err := NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldBeNil)
+			So(fetchContext.MastodonToken, ShouldEqual, "masto")
+			So(fetchContext.MastodonInstance, ShouldEqual, "https://mastodon.example")
+
+			So(set.Set("mastodon-token", "plain"), ShouldBeNil)
+			_, err = NewFetchContext(cli.NewContext(nil, set, nil))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a missing mapping file fails the run", func() {
+			fetchContext.MastodonStatuses = filepath.Join(t.TempDir(), "missing.yaml")
+			_, err := Fetch(c.Background(), fetchContext, NewClient(fetchContext))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
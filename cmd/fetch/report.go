@@ -0,0 +1,225 @@
+package fetch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+)
+
+// ReportFormats are the accepted values of --report-format.
+var ReportFormats = []string{"json", "markdown"}
+
+// Report is the machine-readable summary of a run written by --report.
+type Report struct {
+	Started         time.Time      `json:"started"`
+	Finished        time.Time      `json:"finished"`
+	DurationSeconds float64        `json:"durationSeconds"`
+	Failed          int            `json:"failed"`
+	Domains         []DomainReport `json:"domains"`
+}
+
+// DomainReport summarises the run of one profile.
+type DomainReport struct {
+	Profile  string `json:"profile"`
+	Domain   string `json:"domain"`
+	Pages    int    `json:"pages"`
+	Fetched  int    `json:"fetched"`
+	New      int    `json:"new"`
+	Updated  int    `json:"updated"`
+	Skipped  int    `json:"skipped"`
+	Filtered int    `json:"filtered"`
+	Held     int    `json:"held"`
+	Private  int    `json:"private"`
+	// Networks counts the fetched mentions by the network they originate from.
+	Networks        map[string]int `json:"networks,omitempty"`
+	DurationSeconds float64        `json:"durationSeconds"`
+	Error           string         `json:"error,omitempty"`
+	// Warnings are the failures that didn't fail the run, like failing hooks or notifiers.
+	Warnings []string       `json:"warnings,omitempty"`
+	Targets  []TargetReport `json:"targets"`
+	Files    []string       `json:"files"`
+}
+
+// TargetReport counts what happened to the mentions of one target. Skipped mentions were already stored unchanged;
+// filtered ones were dropped before persisting.
+type TargetReport struct {
+	Target   string `json:"target"`
+	Path     string `json:"path"`
+	New      int    `json:"new"`
+	Updated  int    `json:"updated"`
+	Skipped  int    `json:"skipped"`
+	Filtered int    `json:"filtered"`
+}
+
+// NewReport builds the report of a run that started at started and finished at finished.
+func NewReport(started, finished time.Time, results []Result) Report {
+	report := Report{
+		Started:         started,
+		Finished:        finished,
+		DurationSeconds: finished.Sub(started).Seconds(),
+		Domains:         make([]DomainReport, 0, len(results)),
+	}
+	for _, result := range results {
+		domain := DomainReport{
+			Profile:         result.Profile,
+			Domain:          result.Domain,
+			Pages:           result.Pages,
+			Fetched:         result.Fetched,
+			New:             result.New,
+			Updated:         result.Updated,
+			Held:            result.Held,
+			Private:         result.Private,
+			Networks:        result.Metrics.ByNetwork,
+			DurationSeconds: result.Duration.Seconds(),
+			Warnings:        result.Warnings,
+			Targets:         []TargetReport{},
+			Files:           []string{},
+		}
+		if result.Err != nil {
+			domain.Error = result.Err.Error()
+			report.Failed++
+		}
+		for _, stats := range result.TargetStats {
+			target := newTargetReport(stats)
+			target.Filtered = result.Filtered[stats.Target]
+			domain.Targets = append(domain.Targets, target)
+			domain.Files = append(domain.Files, stats.Path)
+			if stats.ThreadsPath != "" {
+				domain.Files = append(domain.Files, stats.ThreadsPath)
+			}
+		}
+		// Targets whose every mention was filtered weren't persisted.
+		for target, filtered := range result.Filtered {
+			if !slices.ContainsFunc(result.TargetStats, func(stats webmention.PersistStats) bool { return stats.Target == target }) {
+				domain.Targets = append(domain.Targets, TargetReport{Target: target, Filtered: filtered})
+			}
+		}
+		slices.SortFunc(domain.Targets, func(a, b TargetReport) int { return strings.Compare(a.Target, b.Target) })
+		for _, target := range domain.Targets {
+			domain.Skipped += target.Skipped
+			domain.Filtered += target.Filtered
+		}
+		report.Domains = append(report.Domains, domain)
+	}
+	return report
+}
+
+func newTargetReport(stats webmention.PersistStats) TargetReport {
+	return TargetReport{
+		Target:  stats.Target,
+		Path:    stats.Path,
+		New:     len(stats.New),
+		Updated: len(stats.Updated),
+		Skipped: stats.Unchanged,
+	}
+}
+
+// WriteReport writes report in format to path, or to stdout when path is "-".
+func WriteReport(path, format string, report Report) error {
+	var out strings.Builder
+	var err error
+	switch format {
+	case "json":
+		err = report.WriteJSON(&out)
+	case "markdown":
+		err = report.WriteMarkdown(&out)
+	default:
+		err = fmt.Errorf("unknown report format '%s', expected one of %v", format, ReportFormats)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write report: %w", err)
+	}
+
+	if path == "-" {
+		_, err = io.WriteString(os.Stdout, out.String())
+	} else {
+		err = os.WriteFile(path, []byte(out.String()), 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write report: %w", err)
+	}
+	return nil
+}
+
+// WriteJSON writes the report as indented JSON.
+func (report Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// WriteMarkdown writes the report as Markdown, e.g. for a CI job summary or pull request comment.
+func (report Report) WriteMarkdown(w io.Writer) error {
+	var out strings.Builder
+	fmt.Fprintf(&out, "## webmentionR run\n\n")
+	fmt.Fprintf(&out, "%d domains, %d failed, in %s\n\n", len(report.Domains), report.Failed,
+		formatSeconds(report.DurationSeconds))
+
+	out.WriteString("| Domain | Pages | Fetched | New | Updated | Skipped | Filtered | Duration | Status |\n")
+	out.WriteString("|---|--:|--:|--:|--:|--:|--:|--:|---|\n")
+	for _, domain := range report.Domains {
+		status := "ok"
+		if domain.Error != "" {
+			status = "failed"
+		} else if len(domain.Warnings) > 0 {
+			status = "warnings"
+		}
+		fmt.Fprintf(&out, "| %s | %d | %d | %d | %d | %d | %d | %s | %s |\n", markdownCell(domain.Domain), domain.Pages,
+			domain.Fetched, domain.New, domain.Updated, domain.Skipped, domain.Filtered,
+			formatSeconds(domain.DurationSeconds), status)
+	}
+
+	for _, domain := range report.Domains {
+		if len(domain.Targets) == 0 && domain.Error == "" && len(domain.Warnings) == 0 {
+			continue
+		}
+		fmt.Fprintf(&out, "\n### %s\n", domain.Domain)
+		if domain.Error != "" {
+			fmt.Fprintf(&out, "\n```\n%s\n```\n", domain.Error)
+		}
+		if len(domain.Warnings) > 0 {
+			out.WriteString("\n")
+			for _, warning := range domain.Warnings {
+				fmt.Fprintf(&out, "- Warning: %s\n", markdownCell(warning))
+			}
+		}
+		if len(domain.Networks) > 0 {
+			var networks []string
+			for _, count := range webmention.TopN(domain.Networks, -1) {
+				networks = append(networks, fmt.Sprintf("%s %d", count.Key, count.Count))
+			}
+			fmt.Fprintf(&out, "\nNetworks: %s\n", strings.Join(networks, ", "))
+		}
+		if len(domain.Targets) == 0 {
+			continue
+		}
+		out.WriteString("\n| Target | New | Updated | Skipped | Filtered | File |\n")
+		out.WriteString("|---|--:|--:|--:|--:|---|\n")
+		for _, target := range domain.Targets {
+			file := "-"
+			if target.Path != "" {
+				file = "`" + target.Path + "`"
+			}
+			fmt.Fprintf(&out, "| %s | %d | %d | %d | %d | %s |\n", markdownCell(target.Target), target.New,
+				target.Updated, target.Skipped, target.Filtered, file)
+		}
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+func formatSeconds(seconds float64) string {
+	return (time.Duration(seconds * float64(time.Second))).Round(time.Millisecond).String()
+}
+
+// markdownCell escapes the characters that would break a table cell.
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
@@ -0,0 +1,119 @@
+package fetch
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testResults() []Result {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return []Result{
+		{
+			Profile: "blog", Domain: "blog.example.com", Pages: 3, Fetched: 5, New: 2, Updated: 1, Targets: 2,
+			Started: started, Duration: 1500 * time.Millisecond,
+			TargetStats: []webmention.PersistStats{
+				{Target: "https://blog.example.com/a", Path: "data/a.json", ThreadsPath: "data/a.threads.json",
+					New: []webmention.Mention{{WMID: 1}, {WMID: 2}}, Unchanged: 1},
+				{Target: "https://blog.example.com/b|c", Path: "data/b.json",
+					Updated: []webmention.Mention{{WMID: 3}}, Unchanged: 1},
+			},
+			Filtered: map[string]int{"https://blog.example.com/a": 1, "https://blog.example.com/private": 2},
+			Metrics:  webmention.MetricsResponse{ByNetwork: map[string]int{"native": 1, "mastodon": 3, "bluesky": 1}},
+		},
+		{
+			Profile: "notes", Domain: "notes.example.com", Pages: 1, Started: started, Duration: time.Second,
+			Err: errors.New("error fetching webmentions: 401 Unauthorized"),
+		},
+	}
+}
+
+func TestNewReport(t *testing.T) {
+	Convey("Given the results of a run", t, func() {
+		started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		report := NewReport(started, started.Add(2*time.Second), testResults())
+
+		Convey("Every domain is summarised", func() {
+			So(report.DurationSeconds, ShouldEqual, 2)
+			So(report.Failed, ShouldEqual, 1)
+			So(report.Domains, ShouldHaveLength, 2)
+
+			blog := report.Domains[0]
+			So(blog.Pages, ShouldEqual, 3)
+			So(blog.New, ShouldEqual, 2)
+			So(blog.Updated, ShouldEqual, 1)
+			So(blog.Skipped, ShouldEqual, 2)
+			So(blog.Filtered, ShouldEqual, 3)
+			So(blog.DurationSeconds, ShouldEqual, 1.5)
+			So(blog.Files, ShouldResemble, []string{"data/a.json", "data/a.threads.json", "data/b.json"})
+			So(blog.Targets, ShouldHaveLength, 3)
+			So(blog.Targets[0], ShouldResemble, TargetReport{
+				Target: "https://blog.example.com/a", Path: "data/a.json", New: 2, Skipped: 1, Filtered: 1,
+			})
+			So(blog.Targets[2], ShouldResemble, TargetReport{Target: "https://blog.example.com/private", Filtered: 2})
+			So(blog.Networks, ShouldResemble, map[string]int{"native": 1, "mastodon": 3, "bluesky": 1})
+
+			notes := report.Domains[1]
+			So(notes.Error, ShouldContainSubstring, "401")
+			So(notes.Targets, ShouldBeEmpty)
+		})
+
+		Convey("The JSON report round-trips", func() {
+			var out strings.Builder
+			So(report.WriteJSON(&out), ShouldBeNil)
+			var decoded Report
+			So(json.Unmarshal([]byte(out.String()), &decoded), ShouldBeNil)
+			So(decoded.Domains, ShouldHaveLength, 2)
+			So(decoded.Domains[0].Targets, ShouldHaveLength, 3)
+			So(decoded.Domains[0].Filtered, ShouldEqual, 3)
+		})
+
+		Convey("The Markdown report has a summary table and per-target details", func() {
+			var out strings.Builder
+			So(report.WriteMarkdown(&out), ShouldBeNil)
+			markdown := out.String()
+			So(markdown, ShouldContainSubstring, "2 domains, 1 failed, in 2s")
+			So(markdown, ShouldContainSubstring, "| blog.example.com | 3 | 5 | 2 | 1 | 2 | 3 | 1.5s | ok |")
+			So(markdown, ShouldContainSubstring, "| notes.example.com | 1 | 0 | 0 | 0 | 0 | 0 | 1s | failed |")
+			So(markdown, ShouldContainSubstring, "| https://blog.example.com/b\\|c | 0 | 1 | 1 | 0 | `data/b.json` |")
+			So(markdown, ShouldContainSubstring, "| https://blog.example.com/private | 0 | 0 | 0 | 2 | - |")
+			So(markdown, ShouldContainSubstring, "401 Unauthorized")
+			So(markdown, ShouldContainSubstring, "\nNetworks: mastodon 3, bluesky 1, native 1\n")
+		})
+
+		Convey("WriteReport writes the chosen format to a file", func() {
+			path := filepath.Join(t.TempDir(), "report.md")
+			So(WriteReport(path, "markdown", report), ShouldBeNil)
+			data, err := os.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldStartWith, "## webmentionR run")
+
+			So(WriteReport(path, "yaml", report), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a run whose hook failed after saving", t, func() {
+		started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		report := NewReport(started, started.Add(time.Second), []Result{{
+			Profile: "blog", Domain: "blog.example.com", Fetched: 1, New: 1, Started: started, Duration: time.Second,
+			Warnings: []string{"error running hooks: hook make failed: exit status 2"},
+		}})
+
+		Convey("The domain is reported with its warnings rather than as failed", func() {
+			So(report.Failed, ShouldEqual, 0)
+			So(report.Domains[0].Warnings, ShouldHaveLength, 1)
+
+			var out strings.Builder
+			So(report.WriteMarkdown(&out), ShouldBeNil)
+			So(out.String(), ShouldContainSubstring, "| 1s | warnings |")
+			So(out.String(), ShouldContainSubstring, "- Warning: error running hooks: hook make failed: exit status 2\n")
+		})
+	})
+}
@@ -0,0 +1,146 @@
+package private
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/blbecker/webmentionR/config"
+	privatestore "github.com/blbecker/webmentionR/private"
+	"github.com/blbecker/webmentionR/webmention"
+	"github.com/urfave/cli/v2"
+)
+
+var Command = cli.Command{
+	Name:  "private",
+	Usage: "read the private mentions kept out of the destination",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "list",
+			Usage:  "print the stored private mentions, newest first",
+			Action: listAction,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "config",
+					Aliases: []string{"c"},
+					Usage:   "path to the config file (default: $XDG_CONFIG_HOME/webmentionR/config.yaml)",
+					EnvVars: config.EnvVars("config"),
+				},
+				&cli.StringFlag{
+					Name:    "profile",
+					Aliases: []string{"p"},
+					Usage:   "named profile to take the destination and private store settings from",
+					EnvVars: config.EnvVars("profile"),
+				},
+				&cli.StringFlag{
+					Name:    "destination",
+					Aliases: []string{"D"},
+					EnvVars: config.EnvVars("destination"),
+				},
+				&cli.StringFlag{
+					Name:    "private-dir",
+					Usage:   "directory of the private mentions (default: the destination directory followed by .private)",
+					EnvVars: config.EnvVars("private-dir"),
+				},
+				&cli.StringFlag{
+					Name:    "private-passphrase",
+					Usage:   "passphrase the private mentions are encrypted with",
+					EnvVars: config.EnvVars("private-passphrase"),
+				},
+				&cli.StringFlag{
+					Name:    "private-passphrase-env",
+					Usage:   "name of an environment variable holding the passphrase",
+					EnvVars: config.EnvVars("private-passphrase-env"),
+				},
+				&cli.StringFlag{
+					Name:    "private-passphrase-file",
+					Usage:   "path to a file holding the passphrase",
+					EnvVars: config.EnvVars("private-passphrase-file"),
+				},
+				&cli.StringFlag{
+					Name:    "private-passphrase-command",
+					Usage:   "shell command printing the passphrase on stdout",
+					EnvVars: config.EnvVars("private-passphrase-command"),
+				},
+				&cli.StringFlag{
+					Name:    "private-key-file",
+					Usage:   "file holding the key the private mentions are encrypted with",
+					EnvVars: config.EnvVars("private-key-file"),
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the mentions as a JSON array",
+				},
+			},
+		},
+	},
+}
+
+// newStore resolves the private store settings of cliContext against the selected config profile.
+func newStore(cliContext *cli.Context) (*privatestore.Store, error) {
+	configFile, err := config.Load(cliContext.String("config"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot load config file: %w", err)
+	}
+	profile, err := configFile.Profile(cliContext.String("profile"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot select profile: %w", err)
+	}
+	settings := config.NewSettings(cliContext, profile)
+
+	dir := settings.String("private-dir")
+	if dir == "" {
+		destination := settings.String("destination")
+		if destination == "" {
+			return nil, fmt.Errorf("a destination or private directory is required, set --destination or select a profile")
+		}
+		dir = privatestore.Dir(destination)
+	}
+	passphrase, err := settings.Secret("private-passphrase").Resolve(cliContext.Context)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve passphrase: %w", err)
+	}
+	cipher, err := privatestore.NewCipher(passphrase, settings.String("private-key-file"))
+	if err != nil {
+		return nil, err
+	}
+	return &privatestore.Store{Dir: dir, Cipher: cipher}, nil
+}
+
+func listAction(cliContext *cli.Context) error {
+	store, err := newStore(cliContext)
+	if err != nil {
+		return err
+	}
+	mentionsByPath, err := store.LoadAll()
+	if err != nil {
+		return err
+	}
+	mentions := []webmention.Mention{}
+	for _, stored := range mentionsByPath {
+		mentions = append(mentions, stored...)
+	}
+	sort.Slice(mentions, func(i, j int) bool { return mentions[i].WMID > mentions[j].WMID })
+	out := cliContext.App.Writer
+
+	if cliContext.Bool("json") {
+		data, err := json.MarshalIndent(mentions, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshalling JSON: %w", err)
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	}
+
+	if len(mentions) == 0 {
+		_, err := fmt.Fprintf(out, "No private mentions in %s\n", store.Dir)
+		return err
+	}
+	for _, mention := range mentions {
+		fmt.Fprintf(out, "%d  %s  %s\n", mention.WMID, mention.WMProperty, mention.WMReceived.Local().Format(time.RFC3339))
+		fmt.Fprintf(out, "  from:   %s (%s)\n", mention.WMSource, mention.Author.Name)
+		fmt.Fprintf(out, "  to:     %s\n", mention.WMTarget)
+	}
+	return nil
+}
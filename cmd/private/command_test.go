@@ -0,0 +1,71 @@
+package private
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	privatestore "github.com/blbecker/webmentionR/private"
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/urfave/cli/v2"
+)
+
+// runPrivate runs the private command with args against a fresh app, returning what it printed.
+func runPrivate(args ...string) (string, error) {
+	var out bytes.Buffer
+	app := &cli.App{
+		Writer:   &out,
+		Commands: []*cli.Command{&Command},
+	}
+	err := app.Run(append([]string{"webmentionR", "private"}, args...))
+	return out.String(), err
+}
+
+func Test_PrivateCommand(t *testing.T) {
+	Convey("Given an encrypted private store", t, func() {
+		privatestore.ReadFileFunc = os.ReadFile
+		privatestore.WriteFileFunc = os.WriteFile
+		privatestore.MkdirAllFunc = os.MkdirAll
+		destination := filepath.Join(t.TempDir(), "webmentions")
+		cipher, err := privatestore.NewPassphraseCipher("correct horse")
+		So(err, ShouldBeNil)
+		store := &privatestore.Store{Dir: privatestore.Dir(destination), Cipher: cipher}
+		_, err = store.Save([]webmention.Mention{
+			{WMID: 1, WMTarget: "https://example.com/post", WMSource: "https://friend.example/a", WMPrivate: true},
+			{WMID: 2, WMTarget: "https://example.com/other", WMSource: "https://friend.example/b", WMPrivate: true},
+		}, webmention.Derived{})
+		So(err, ShouldBeNil)
+
+		Convey("list prints the mentions newest first", func() {
+			out, err := runPrivate("list", "--destination", destination, "--private-passphrase", "correct horse")
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, "from:   https://friend.example/b")
+			So(bytes.Index([]byte(out), []byte("2  ")), ShouldBeLessThan, bytes.Index([]byte(out), []byte("1  ")))
+		})
+
+		Convey("list --json prints the decrypted mentions", func() {
+			out, err := runPrivate("list", "--json", "--destination", destination, "--private-passphrase", "correct horse")
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, `"wm-private": true`)
+		})
+
+		Convey("list reads the passphrase from its secret source", func() {
+			passphrasePath := filepath.Join(t.TempDir(), "passphrase")
+			So(os.WriteFile(passphrasePath, []byte("correct horse\n"), 0600), ShouldBeNil)
+			out, err := runPrivate("list", "--destination", destination, "--private-passphrase-file", passphrasePath)
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, "from:   https://friend.example/b")
+
+			_, err = runPrivate("list", "--destination", destination, "--private-passphrase", "correct horse",
+				"--private-passphrase-file", passphrasePath)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("list fails without the right passphrase", func() {
+			_, err := runPrivate("list", "--destination", destination, "--private-passphrase", "wrong")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
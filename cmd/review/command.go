@@ -0,0 +1,299 @@
+package review
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blbecker/webmentionR/cmd/fetch"
+	"github.com/blbecker/webmentionR/config"
+	"github.com/blbecker/webmentionR/moderation"
+	"github.com/blbecker/webmentionR/webmention"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+)
+
+var commonFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "config",
+		Aliases: []string{"c"},
+		Usage:   "path to the config file (default: $XDG_CONFIG_HOME/webmentionR/config.yaml)",
+		EnvVars: config.EnvVars("config"),
+	},
+	&cli.StringFlag{
+		Name:    "profile",
+		Aliases: []string{"p"},
+		Usage:   "named profile to take the destination and queue from",
+		EnvVars: config.EnvVars("profile"),
+	},
+	&cli.StringFlag{
+		Name:    "destination",
+		Aliases: []string{"D"},
+		EnvVars: config.EnvVars("destination"),
+	},
+	&cli.StringFlag{
+		Name:    "held-file",
+		Usage:   "queue of mentions held for review (default: the destination directory followed by .held.json)",
+		EnvVars: config.EnvVars("held-file"),
+	},
+}
+
+var Command = cli.Command{
+	Name:  "review",
+	Usage: "list, approve or reject the mentions moderation held for review",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "list",
+			Usage:  "print the held mentions, newest first",
+			Action: listAction,
+			Flags: append([]cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the raw queue",
+				},
+			}, commonFlags...),
+		},
+		{
+			Name:      "approve",
+			Usage:     "save held mentions to their target's file",
+			ArgsUsage: "<wm-id>...",
+			Action:    approveAction,
+			Flags:     withFetchFlags(commonFlags),
+		},
+		{
+			Name:      "reject",
+			Usage:     "discard held mentions and add their senders, except bridges, to the blocklist",
+			ArgsUsage: "<wm-id>...",
+			Action:    rejectAction,
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:    "blocklist",
+					Usage:   "file of rejected senders whose mentions are dropped (default: the destination directory followed by .blocklist)",
+					EnvVars: config.EnvVars("blocklist"),
+				},
+				&cli.BoolFlag{
+					Name:  "no-block",
+					Usage: "discard the mentions without blocking their senders",
+				},
+			}, commonFlags...),
+		},
+	},
+}
+
+// withFetchFlags returns flags followed by the fetch flags not among them, so approved mentions are processed with the
+// same settings as fetched ones.
+func withFetchFlags(flags []cli.Flag) []cli.Flag {
+	var names []string
+	for _, f := range flags {
+		names = append(names, f.Names()...)
+	}
+	flags = slices.Clone(flags)
+	for _, f := range fetch.Command.Flags {
+		if !slices.ContainsFunc(f.Names(), func(name string) bool { return slices.Contains(names, name) }) {
+			flags = append(flags, f)
+		}
+	}
+	return flags
+}
+
+// reviewContext holds the settings shared by the review subcommands.
+type reviewContext struct {
+	Destination string
+	Settings    *config.Settings
+	Queue       *moderation.Queue
+}
+
+// newReviewContext resolves the settings of cliContext against the selected config profile and reads the queue.
+func newReviewContext(cliContext *cli.Context) (*reviewContext, error) {
+	configFile, err := config.Load(cliContext.String("config"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot load config file: %w", err)
+	}
+	profile, err := configFile.Profile(cliContext.String("profile"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot select profile: %w", err)
+	}
+	settings := config.NewSettings(cliContext, profile)
+
+	reviewContext := reviewContext{Destination: settings.String("destination"), Settings: settings}
+	queuePath := settings.String("held-file")
+	if queuePath == "" {
+		if reviewContext.Destination == "" {
+			return nil, fmt.Errorf("a destination or held file is required, set --destination or select a profile")
+		}
+		queuePath = moderation.QueuePath(reviewContext.Destination)
+	}
+	reviewContext.Queue, err = moderation.LoadQueue(queuePath)
+	if err != nil {
+		return nil, err
+	}
+	return &reviewContext, nil
+}
+
+// take removes the mentions with the wm-ids given as arguments from the queue. Nothing is removed unless every
+// wm-id is queued.
+func (reviewContext *reviewContext) take(cliContext *cli.Context) ([]moderation.Held, error) {
+	if cliContext.NArg() == 0 {
+		return nil, fmt.Errorf("expected at least one wm-id argument")
+	}
+	var wmIDs []int
+	for _, arg := range cliContext.Args().Slice() {
+		wmID, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid wm-id '%s'", arg)
+		}
+		if _, ok := reviewContext.Queue.Find(wmID); !ok {
+			return nil, fmt.Errorf("no held mention with wm-id %d", wmID)
+		}
+		wmIDs = append(wmIDs, wmID)
+	}
+
+	var taken []moderation.Held
+	for _, wmID := range wmIDs {
+		if held, ok := reviewContext.Queue.Remove(wmID); ok {
+			taken = append(taken, held)
+		}
+	}
+	return taken, nil
+}
+
+func listAction(cliContext *cli.Context) error {
+	reviewContext, err := newReviewContext(cliContext)
+	if err != nil {
+		return err
+	}
+	out := cliContext.App.Writer
+	queued := reviewContext.Queue.Mentions
+
+	if cliContext.Bool("json") {
+		if queued == nil {
+			queued = []moderation.Held{}
+		}
+		data, err := json.MarshalIndent(queued, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshalling JSON: %w", err)
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	}
+
+	if len(queued) == 0 {
+		_, err := fmt.Fprintf(out, "No mentions held in %s\n", reviewContext.Queue.Path)
+		return err
+	}
+	for _, held := range queued {
+		mention := held.Mention
+		fmt.Fprintf(out, "%d  %s  held by %s at %s\n", mention.WMID, mention.WMProperty, held.Reason,
+			held.Held.Local().Format(time.RFC3339))
+		fmt.Fprintf(out, "  from:   %s (%s)\n", mention.WMSource, authorOf(mention))
+		fmt.Fprintf(out, "  to:     %s\n", mention.WMTarget)
+		if text := excerpt(mention.Content.Text, 120); text != "" {
+			fmt.Fprintf(out, "  text:   %s\n", text)
+		}
+	}
+	return nil
+}
+
+func authorOf(mention webmention.Mention) string {
+	switch {
+	case mention.Author.Name != "" && mention.Author.URL != "":
+		return mention.Author.Name + ", " + mention.Author.URL
+	case mention.Author.Name != "":
+		return mention.Author.Name
+	case mention.Author.URL != "":
+		return mention.Author.URL
+	default:
+		return "unknown author"
+	}
+}
+
+// excerpt collapses the whitespace of text and shortens it to length runes.
+func excerpt(text string, length int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > length {
+		return string(runes[:length-1]) + "…"
+	}
+	return text
+}
+
+func approveAction(cliContext *cli.Context) error {
+	reviewContext, err := newReviewContext(cliContext)
+	if err != nil {
+		return err
+	}
+	if reviewContext.Destination == "" {
+		return fmt.Errorf("a destination is required, set --destination or select a profile")
+	}
+	approved, err := reviewContext.take(cliContext)
+	if err != nil {
+		return err
+	}
+
+	// Approved mentions go through the stages after the ones that held them, like mentions that were never held.
+	fetchContext, err := fetch.NewProfileContext(cliContext.Context, cliContext.String("profile"), reviewContext.Settings)
+	if err != nil {
+		return err
+	}
+	fetchContext.Destination = reviewContext.Destination
+	mentions := make([]webmention.Mention, len(approved))
+	for i, held := range approved {
+		mentions[i] = held.Mention
+	}
+	_, warnings, err := fetch.Approve(cliContext.Context, fetchContext, mentions)
+	if err != nil {
+		return err
+	}
+	for _, held := range approved {
+		log.Info("Approved mention", "WMID", held.Mention.WMID, "target", held.Mention.WMTarget)
+	}
+	for _, warning := range warnings {
+		log.Warn("Approve warning", "warning", warning)
+	}
+	// The queue is only written once the mentions are saved, so a failure leaves them held.
+	return reviewContext.Queue.Save()
+}
+
+func rejectAction(cliContext *cli.Context) error {
+	reviewContext, err := newReviewContext(cliContext)
+	if err != nil {
+		return err
+	}
+	rejected, err := reviewContext.take(cliContext)
+	if err != nil {
+		return err
+	}
+
+	if !cliContext.Bool("no-block") {
+		blocklistPath := reviewContext.Settings.String("blocklist")
+		if blocklistPath == "" {
+			if reviewContext.Destination == "" {
+				return fmt.Errorf("a destination or blocklist is required, set --destination, --blocklist or --no-block")
+			}
+			blocklistPath = moderation.BlocklistPath(reviewContext.Destination)
+		}
+		for _, held := range rejected {
+			sender := moderation.Sender(held.Mention)
+			if sender == "" {
+				log.Warn("Cannot block mention without author or source", "WMID", held.Mention.WMID)
+				continue
+			}
+			// Without an author URL the sender is the source's host, which for a bridge is everyone it relays for.
+			if webmention.IsBridgeHost(sender) {
+				fmt.Fprintf(cliContext.App.Writer, "Not blocking %s for mention %d: it is a bridge relaying the mentions "+
+					"of many authors, and the mention has no author URL to block instead\n", sender, held.Mention.WMID)
+				continue
+			}
+			if err := moderation.Block(blocklistPath, sender); err != nil {
+				return err
+			}
+			log.Info("Blocked sender", "sender", sender, "blocklist", blocklistPath)
+		}
+	}
+	for _, held := range rejected {
+		log.Info("Rejected mention", "WMID", held.Mention.WMID, "source", held.Mention.WMSource)
+	}
+	return reviewContext.Queue.Save()
+}
@@ -0,0 +1,160 @@
+package review
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blbecker/webmentionR/cmd/fetch"
+	"github.com/blbecker/webmentionR/moderation"
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/urfave/cli/v2"
+)
+
+// runReview runs the review command with args against a fresh app, returning what it printed.
+func runReview(args ...string) (string, error) {
+	var out bytes.Buffer
+	app := &cli.App{
+		Writer:   &out,
+		Commands: []*cli.Command{&Command},
+	}
+	err := app.Run(append([]string{"webmentionR", "review"}, args...))
+	return out.String(), err
+}
+
+func Test_ReviewCommand(t *testing.T) {
+	Convey("Given a destination with two held mentions", t, func() {
+		webmention.WriteFileFunc = os.WriteFile
+		webmention.ReadFileFunc = os.ReadFile
+		moderation.WriteFileFunc = os.WriteFile
+		moderation.ReadFileFunc = os.ReadFile
+		moderation.OpenFileFunc = os.OpenFile
+		fetch.PersistFunc = webmention.DoPersist
+
+		dir := t.TempDir()
+		destination := filepath.Join(dir, "webmentions")
+		So(os.Mkdir(destination, 0755), ShouldBeNil)
+		So(os.WriteFile(filepath.Join(destination, "post.json"), []byte("[]"), 0644), ShouldBeNil)
+		queuePath := moderation.QueuePath(destination)
+		now := time.Now()
+		So(moderation.Enqueue(queuePath, []moderation.Held{
+			{Domain: "example.com", Reason: "first-time", Held: now.Add(-time.Hour), Mention: webmention.Mention{
+				WMID: 1, WMTarget: "https://example.com/post", WMSource: "https://friend.example/reply",
+				WMProperty: "in-reply-to", Author: webmention.Author{Name: "Friend", URL: "https://friend.example/"},
+				Content: webmention.Content{Text: "Nice   post!", HTML: "<p>Nice post!</p><script>alert(1)</script>"}}},
+			{Domain: "example.com", Reason: "links-only", Held: now, Mention: webmention.Mention{
+				WMID: 2, WMTarget: "https://example.com/post", WMSource: "https://spam.example/x",
+				WMProperty: "mention-of"}},
+		}), ShouldBeNil)
+
+		queued := func() []moderation.Held {
+			queue, err := moderation.LoadQueue(queuePath)
+			So(err, ShouldBeNil)
+			return queue.Mentions
+		}
+
+		Convey("list prints the held mentions, newest first", func() {
+			out, err := runReview("list", "--destination", destination)
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, "1  in-reply-to  held by first-time")
+			So(out, ShouldContainSubstring, "from:   https://friend.example/reply (Friend, https://friend.example/)")
+			So(out, ShouldContainSubstring, "text:   Nice post!")
+			So(out, ShouldContainSubstring, "(unknown author)")
+			So(bytes.Index([]byte(out), []byte("2  mention-of")), ShouldBeLessThan, bytes.Index([]byte(out), []byte("1  in-reply-to")))
+		})
+
+		Convey("list --json prints the raw queue", func() {
+			out, err := runReview("list", "--json", "--destination", destination)
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, "\"reason\": \"links-only\"")
+		})
+
+		Convey("approve moves a mention into its target's file", func() {
+			_, err := runReview("approve", "--destination", destination, "1")
+			So(err, ShouldBeNil)
+
+			stored, err := webmention.LoadMentions(filepath.Join(destination, "post.json"))
+			So(err, ShouldBeNil)
+			So(stored, ShouldHaveLength, 1)
+			So(stored[0].WMID, ShouldEqual, 1)
+			So(queued(), ShouldHaveLength, 1)
+			So(queued()[0].Mention.WMID, ShouldEqual, 2)
+		})
+
+		Convey("approve runs the mention through the stages after moderation", func() {
+			_, err := runReview("approve", "--destination", destination, "1")
+			So(err, ShouldBeNil)
+
+			stored, err := webmention.LoadMentions(filepath.Join(destination, "post.json"))
+			So(err, ShouldBeNil)
+			So(stored, ShouldHaveLength, 1)
+			So(stored[0].Content.HTML, ShouldEqual, "<p>Nice post!</p>")
+			So(stored[0].Network, ShouldEqual, webmention.NetworkNative)
+		})
+
+		Convey("approve stores the mention even when the moderator would hold it again", func() {
+			rules := filepath.Join(dir, "rules.yaml")
+			So(os.WriteFile(rules, []byte("rules:\n  - name: friend\n    source-domain: [friend.example]\n    action: hold\n"), 0644), ShouldBeNil)
+			_, err := runReview("approve", "--destination", destination, "--moderation-rules", rules, "1")
+			So(err, ShouldBeNil)
+
+			stored, err := webmention.LoadMentions(filepath.Join(destination, "post.json"))
+			So(err, ShouldBeNil)
+			So(stored, ShouldHaveLength, 1)
+			So(queued()[0].Mention.WMID, ShouldEqual, 2)
+		})
+
+		Convey("a failed approval leaves the mention held", func() {
+			So(os.WriteFile(filepath.Join(destination, "post.json"), []byte("not json"), 0644), ShouldBeNil)
+			_, err := runReview("approve", "--destination", destination, "1")
+			So(err, ShouldNotBeNil)
+			So(queued(), ShouldHaveLength, 2)
+		})
+
+		Convey("reject discards a mention and blocks its sender", func() {
+			_, err := runReview("reject", "--destination", destination, "1", "2")
+			So(err, ShouldBeNil)
+			So(queued(), ShouldBeEmpty)
+
+			senders, err := moderation.LoadBlocklist(moderation.BlocklistPath(destination))
+			So(err, ShouldBeNil)
+			So(senders, ShouldResemble, []string{"https://friend.example", "spam.example"})
+			stored, err := webmention.LoadMentions(filepath.Join(destination, "post.json"))
+			So(err, ShouldBeNil)
+			So(stored, ShouldBeEmpty)
+		})
+
+		Convey("reject doesn't block a bridge when the mention has no author URL", func() {
+			So(moderation.Enqueue(queuePath, []moderation.Held{{Domain: "example.com", Held: now, Mention: webmention.Mention{
+				WMID: 3, WMTarget: "https://example.com/post", WMSource: "https://brid.gy/comment/mastodon/@a/1/2",
+				WMProperty: "in-reply-to"}}}), ShouldBeNil)
+			out, err := runReview("reject", "--destination", destination, "3")
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, "Not blocking brid.gy for mention 3")
+			So(queued(), ShouldHaveLength, 2)
+			_, err = os.Stat(moderation.BlocklistPath(destination))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("reject --no-block leaves the blocklist alone", func() {
+			_, err := runReview("reject", "--no-block", "--destination", destination, "2")
+			So(err, ShouldBeNil)
+			So(queued(), ShouldHaveLength, 1)
+			_, err = os.Stat(moderation.BlocklistPath(destination))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("unknown or invalid wm-ids change nothing", func() {
+			_, err := runReview("reject", "--destination", destination, "2", "3")
+			So(err, ShouldNotBeNil)
+			_, err = runReview("approve", "--destination", destination, "latest")
+			So(err, ShouldNotBeNil)
+			_, err = runReview("approve", "--destination", destination)
+			So(err, ShouldNotBeNil)
+			So(queued(), ShouldHaveLength, 2)
+		})
+	})
+}
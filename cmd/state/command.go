@@ -0,0 +1,267 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blbecker/webmentionR/config"
+	fetchstate "github.com/blbecker/webmentionR/state"
+	"github.com/blbecker/webmentionR/webmention"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+)
+
+var commonFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "config",
+		Aliases: []string{"c"},
+		Usage:   "path to the config file (default: $XDG_CONFIG_HOME/webmentionR/config.yaml)",
+		EnvVars: config.EnvVars("config"),
+	},
+	&cli.StringFlag{
+		Name:    "profile",
+		Aliases: []string{"p"},
+		Usage:   "named profile to take the state file, domain and destination from",
+		EnvVars: config.EnvVars("profile"),
+	},
+	&cli.StringFlag{
+		Name:    "state-file",
+		Aliases: []string{"s"},
+		Value:   "./fetch.webmentions.state",
+		EnvVars: config.EnvVars("state-file"),
+	},
+	&cli.StringFlag{
+		Name:    "domain",
+		Aliases: []string{"d"},
+		EnvVars: config.EnvVars("domain"),
+	},
+}
+
+var Command = cli.Command{
+	Name:  "state",
+	Usage: "inspect and repair the fetch state",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "show",
+			Usage:  "print the cursor and recent runs of every domain, or of --domain",
+			Action: showAction,
+			Flags: append([]cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the raw state file",
+				},
+			}, commonFlags...),
+		},
+		{
+			Name:      "set-since-id",
+			Usage:     "set the cursor of --domain",
+			ArgsUsage: "<wm-id>",
+			Action:    setSinceIDAction,
+			Flags:     commonFlags,
+		},
+		{
+			Name:   "reset",
+			Usage:  "reset the cursor of --domain, or of every domain, so the next fetch starts over",
+			Action: resetAction,
+			Flags:  commonFlags,
+		},
+		{
+			Name:   "rebuild",
+			Usage:  "set the cursor of --domain to the newest wm-id stored in --destination",
+			Action: rebuildAction,
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:    "destination",
+					Aliases: []string{"D"},
+					EnvVars: config.EnvVars("destination"),
+				},
+			}, commonFlags...),
+		},
+	},
+}
+
+// stateContext holds the settings shared by the state subcommands.
+type stateContext struct {
+	StateFile   string
+	Domain      string
+	Destination string
+	State       *fetchstate.State
+}
+
+// newStateContext resolves the settings of cliContext against the selected config profile and reads the state file.
+func newStateContext(cliContext *cli.Context) (*stateContext, error) {
+	configFile, err := config.Load(cliContext.String("config"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot load config file: %w", err)
+	}
+	profile, err := configFile.Profile(cliContext.String("profile"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot select profile: %w", err)
+	}
+	settings := config.NewSettings(cliContext, profile)
+
+	stateContext := stateContext{
+		StateFile:   settings.String("state-file"),
+		Domain:      settings.String("domain"),
+		Destination: settings.String("destination"),
+	}
+	stateContext.State, err = fetchstate.ReadState(stateContext.StateFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read state file: %w", err)
+	}
+	return &stateContext, nil
+}
+
+func (stateContext *stateContext) requireDomain() error {
+	if stateContext.Domain == "" {
+		return fmt.Errorf("a domain is required, set --domain or select a profile")
+	}
+	return nil
+}
+
+func (stateContext *stateContext) write() error {
+	if err := fetchstate.WriteState(stateContext.StateFile, stateContext.State); err != nil {
+		return fmt.Errorf("cannot write state file: %w", err)
+	}
+	return nil
+}
+
+func showAction(cliContext *cli.Context) error {
+	stateContext, err := newStateContext(cliContext)
+	if err != nil {
+		return err
+	}
+	out := cliContext.App.Writer
+
+	if cliContext.Bool("json") {
+		data, err := json.MarshalIndent(stateContext.State, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshalling JSON: %w", err)
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	}
+
+	domains := stateContext.State.DomainNames()
+	if stateContext.Domain != "" {
+		domains = []string{stateContext.Domain}
+	}
+	if len(domains) == 0 {
+		_, err := fmt.Fprintf(out, "No domains recorded in %s (legacy sinceID %d)\n", stateContext.StateFile, stateContext.State.SinceID)
+		return err
+	}
+
+	for _, domain := range domains {
+		domainState := stateContext.State.Domain(domain)
+		fmt.Fprintf(out, "%s\n", domain)
+		fmt.Fprintf(out, "  sinceID:      %d\n", domainState.SinceID)
+		fmt.Fprintf(out, "  last success: %s\n", formatTime(domainState.LastSuccess))
+		fmt.Fprintf(out, "  last attempt: %s\n", formatTime(domainState.LastAttempt))
+		if domainState.LastError != "" {
+			fmt.Fprintf(out, "  last error:   %s\n", domainState.LastError)
+		}
+		if n := len(domainState.History); n > 0 {
+			run := domainState.History[n-1]
+			fmt.Fprintf(out, "  last run:     fetched %d, new %d, updated %d in %s\n",
+				run.Fetched, run.New, run.Updated, run.Finished.Sub(run.Started).Round(time.Millisecond))
+		}
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+func setSinceIDAction(cliContext *cli.Context) error {
+	stateContext, err := newStateContext(cliContext)
+	if err != nil {
+		return err
+	}
+	if err := stateContext.requireDomain(); err != nil {
+		return err
+	}
+	if cliContext.NArg() != 1 {
+		return fmt.Errorf("expected exactly one wm-id argument")
+	}
+	sinceID, err := strconv.Atoi(cliContext.Args().First())
+	if err != nil || sinceID < 0 {
+		return fmt.Errorf("invalid wm-id '%s'", cliContext.Args().First())
+	}
+
+	previous := stateContext.State.SinceIDFor(stateContext.Domain)
+	stateContext.State.SetSinceID(stateContext.Domain, sinceID)
+	log.Info("Updated cursor", "domain", stateContext.Domain, "from", previous, "to", sinceID)
+	return stateContext.write()
+}
+
+func resetAction(cliContext *cli.Context) error {
+	stateContext, err := newStateContext(cliContext)
+	if err != nil {
+		return err
+	}
+
+	if stateContext.Domain == "" {
+		stateContext.State.ResetSinceIDs()
+		log.Info("Reset cursor of every domain")
+	} else {
+		stateContext.State.SetSinceID(stateContext.Domain, 0)
+		log.Info("Reset cursor", "domain", stateContext.Domain)
+	}
+	return stateContext.write()
+}
+
+func rebuildAction(cliContext *cli.Context) error {
+	stateContext, err := newStateContext(cliContext)
+	if err != nil {
+		return err
+	}
+	if err := stateContext.requireDomain(); err != nil {
+		return err
+	}
+	if stateContext.Destination == "" {
+		return fmt.Errorf("a destination is required, set --destination or select a profile")
+	}
+
+	mentionsByPath, err := webmention.LoadAll(stateContext.Destination)
+	if err != nil {
+		return err
+	}
+	maxID := MaxStoredID(mentionsByPath, stateContext.Domain)
+
+	previous := stateContext.State.SinceIDFor(stateContext.Domain)
+	stateContext.State.SetSinceID(stateContext.Domain, maxID)
+	log.Info("Rebuilt cursor from stored mentions", "domain", stateContext.Domain, "files", len(mentionsByPath),
+		"from", previous, "to", maxID)
+	return stateContext.write()
+}
+
+// MaxStoredID returns the newest wm-id among the stored mentions targeting domain.
+func MaxStoredID(mentionsByPath map[string][]webmention.Mention, domain string) int {
+	maxID := 0
+	for _, mentions := range mentionsByPath {
+		for _, mention := range mentions {
+			if targetsDomain(mention, domain) {
+				maxID = max(maxID, mention.WMID)
+			}
+		}
+	}
+	return maxID
+}
+
+func targetsDomain(mention webmention.Mention, domain string) bool {
+	target, err := url.Parse(mention.WMTarget)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(target.Hostname())
+	domain = strings.ToLower(domain)
+	return host == domain || strings.TrimPrefix(host, "www.") == domain
+}
@@ -0,0 +1,124 @@
+package state
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fetchstate "github.com/blbecker/webmentionR/state"
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/urfave/cli/v2"
+)
+
+// runState runs the state command with args against a fresh app, returning what it printed.
+func runState(args ...string) (string, error) {
+	var out bytes.Buffer
+	app := &cli.App{
+		Writer:   &out,
+		Commands: []*cli.Command{&Command},
+	}
+	err := app.Run(append([]string{"webmentionR", "state"}, args...))
+	return out.String(), err
+}
+
+func writeMentions(path string, mentions []webmention.Mention) {
+	So(webmention.Save(path, mentions), ShouldBeNil)
+}
+
+func Test_StateCommand(t *testing.T) {
+	Convey("Given a state file with a domain", t, func() {
+		webmention.WriteFileFunc = os.WriteFile
+		webmention.ReadFileFunc = os.ReadFile
+		fetchstate.WriteFileFunc = os.WriteFile
+		fetchstate.ReadFileFunc = os.ReadFile
+
+		dir := t.TempDir()
+		stateFile := filepath.Join(dir, "fetch.webmentions.state")
+		initial := &fetchstate.State{}
+		initial.SetSinceID("example.com", 10)
+		initial.SetSinceID("other.example", 20)
+		So(fetchstate.WriteState(stateFile, initial), ShouldBeNil)
+
+		readState := func() *fetchstate.State {
+			s, err := fetchstate.ReadState(stateFile)
+			So(err, ShouldBeNil)
+			return s
+		}
+
+		Convey("show prints every domain's cursor", func() {
+			out, err := runState("show", "--state-file", stateFile)
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, "example.com")
+			So(out, ShouldContainSubstring, "sinceID:      10")
+			So(out, ShouldContainSubstring, "other.example")
+			So(out, ShouldContainSubstring, "last success: never")
+		})
+
+		Convey("show --json prints the raw state", func() {
+			out, err := runState("show", "--json", "--state-file", stateFile)
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, "\"domains\"")
+		})
+
+		Convey("set-since-id updates one domain", func() {
+			_, err := runState("set-since-id", "--state-file", stateFile, "--domain", "example.com", "42")
+			So(err, ShouldBeNil)
+			So(readState().SinceIDFor("example.com"), ShouldEqual, 42)
+			So(readState().SinceIDFor("other.example"), ShouldEqual, 20)
+		})
+
+		Convey("set-since-id rejects a missing domain or invalid id", func() {
+			_, err := runState("set-since-id", "--state-file", stateFile, "42")
+			So(err, ShouldNotBeNil)
+			_, err = runState("set-since-id", "--state-file", stateFile, "--domain", "example.com", "latest")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("reset with a domain resets only that domain", func() {
+			_, err := runState("reset", "--state-file", stateFile, "--domain", "example.com")
+			So(err, ShouldBeNil)
+			So(readState().SinceIDFor("example.com"), ShouldEqual, 0)
+			So(readState().SinceIDFor("other.example"), ShouldEqual, 20)
+		})
+
+		Convey("reset without a domain resets every domain", func() {
+			_, err := runState("reset", "--state-file", stateFile)
+			So(err, ShouldBeNil)
+			So(readState().SinceIDFor("example.com"), ShouldEqual, 0)
+			So(readState().SinceIDFor("other.example"), ShouldEqual, 0)
+		})
+
+		Convey("rebuild derives the cursor from the stored mentions", func() {
+			destination := filepath.Join(dir, "webmentions")
+			So(os.Mkdir(destination, 0755), ShouldBeNil)
+			writeMentions(filepath.Join(destination, "post.json"), []webmention.Mention{
+				{WMID: 120, WMTarget: "https://example.com/post"},
+				{WMID: 99, WMTarget: "https://www.example.com/post"},
+				{WMID: 500, WMTarget: "https://other.example/post"},
+			})
+			writeMentions(filepath.Join(destination, "post"+webmention.ThreadsSuffix), nil)
+
+			_, err := runState("rebuild", "--state-file", stateFile, "--domain", "example.com", "--destination", destination)
+			So(err, ShouldBeNil)
+			So(readState().SinceIDFor("example.com"), ShouldEqual, 120)
+		})
+
+		Convey("rebuild requires a destination", func() {
+			_, err := runState("rebuild", "--state-file", stateFile, "--domain", "example.com")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestMaxStoredID(t *testing.T) {
+	Convey("Only mentions targeting the domain are considered", t, func() {
+		mentionsByPath := map[string][]webmention.Mention{
+			"a.json": {{WMID: 3, WMTarget: "https://example.com/a"}, {WMID: 9, WMTarget: "https://elsewhere.example/a"}},
+			"b.json": {{WMID: 5, WMTarget: "https://EXAMPLE.com/b"}},
+		}
+		So(MaxStoredID(mentionsByPath, "example.com"), ShouldEqual, 5)
+		So(MaxStoredID(mentionsByPath, "unknown.example"), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,329 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/blbecker/webmentionR/cmd/fetch"
+	"github.com/blbecker/webmentionR/config"
+	"github.com/blbecker/webmentionR/webmention"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+)
+
+var Command = cli.Command{
+	Name:    "watch",
+	Aliases: []string{"w"},
+	Usage:   "poll for webmentions on an interval until interrupted",
+	Action:  watchAction,
+	Flags: append([]cli.Flag{
+		&cli.DurationFlag{
+			Name:    "interval",
+			Usage:   "time between polls of each domain",
+			Value:   15 * time.Minute,
+			EnvVars: config.EnvVars("interval"),
+		},
+		&cli.DurationFlag{
+			Name:    "jitter",
+			Usage:   "random variation added to or removed from each interval",
+			Value:   time.Minute,
+			EnvVars: config.EnvVars("jitter"),
+		},
+		&cli.DurationFlag{
+			Name:    "max-backoff",
+			Usage:   "longest wait between polls of a domain that keeps failing",
+			Value:   6 * time.Hour,
+			EnvVars: config.EnvVars("max-backoff"),
+		},
+		&cli.StringFlag{
+			Name:    "health-addr",
+			Usage:   "address to serve the /healthz and /metrics endpoints on, e.g. :8080",
+			EnvVars: config.EnvVars("health-addr"),
+		},
+	}, fetchFlags()...),
+}
+
+// fetchFlags returns the fetch flags watch accepts: all of them but the run report, which only fetch writes.
+func fetchFlags() []cli.Flag {
+	var flags []cli.Flag
+	for _, f := range fetch.Command.Flags {
+		if !slices.ContainsFunc(f.Names(), func(name string) bool { return name == "report" || name == "report-format" }) {
+			flags = append(flags, f)
+		}
+	}
+	return flags
+}
+
+// shutdownTimeout bounds how long the health server waits for in-flight requests on shutdown.
+const shutdownTimeout = 5 * time.Second
+
+// DomainStatus is the health of one watched domain.
+type DomainStatus struct {
+	Domain              string    `json:"domain"`
+	Polls               int       `json:"polls"`
+	LastSuccess         time.Time `json:"lastSuccess"`
+	LastError           string    `json:"lastError,omitempty"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	NextPoll            time.Time `json:"nextPoll"`
+}
+
+// Watcher polls every domain on an interval. Each domain keeps its client and in-memory state between polls, and the
+// state file is written after every poll so a restart resumes where the watcher stopped.
+type Watcher struct {
+	Interval    time.Duration
+	Jitter      time.Duration
+	MaxBackoff  time.Duration
+	Parallelism int
+	HTTPClient  *http.Client
+	// MetricsTextfile, when set, is rewritten with the current metrics after every poll.
+	MetricsTextfile string
+
+	fetchContexts []*fetch.Context
+	semaphore     chan struct{}
+	mu            sync.RWMutex
+	status        map[string]*DomainStatus
+}
+
+//=== Bindings for tests
+
+var RandFunc = rand.Int64N
+
+//=== Bindings for tests
+
+// NewWatcher returns a Watcher for fetchContexts with the default timings.
+func NewWatcher(fetchContexts []*fetch.Context) *Watcher {
+	status := map[string]*DomainStatus{}
+	for _, fetchContext := range fetchContexts {
+		status[fetchContext.Domain] = &DomainStatus{Domain: fetchContext.Domain}
+	}
+	return &Watcher{
+		Interval:      15 * time.Minute,
+		Jitter:        time.Minute,
+		MaxBackoff:    6 * time.Hour,
+		Parallelism:   2,
+		HTTPClient:    &http.Client{Timeout: time.Minute},
+		fetchContexts: fetchContexts,
+		status:        status,
+	}
+}
+
+func watchAction(cliContext *cli.Context) error {
+	fetchContexts, err := fetch.NewFetchContexts(cliContext)
+	if err != nil {
+		return fmt.Errorf("cannot create fetch context: %w", err)
+	}
+	fetch.ValidateAll(fetchContexts)
+	// Profiles that cannot be set up are recorded as failed once and not watched; the others are.
+	var watched []*fetch.Context
+	var failed []error
+	for _, fetchContext := range fetchContexts {
+		if fetchContext.Err == nil {
+			watched = append(watched, fetchContext)
+			continue
+		}
+		log.Error("Cannot watch profile", "profile", fetchContext.Profile, "err", fetchContext.Err)
+		fetchContext.RecordRun(fetchContext.FailedResult())
+		failed = append(failed, fetchContext.Err)
+	}
+	if len(watched) == 0 {
+		return errors.Join(append(failed, fetch.WriteStates(fetchContexts))...)
+	}
+
+	ctx, stop := signal.NotifyContext(cliContext.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	watcher := NewWatcher(watched)
+	watcher.Interval = cliContext.Duration("interval")
+	watcher.Jitter = cliContext.Duration("jitter")
+	watcher.MaxBackoff = cliContext.Duration("max-backoff")
+	watcher.Parallelism = cliContext.Int("parallelism")
+	watcher.MetricsTextfile = cliContext.String("metrics-textfile")
+
+	if addr := cliContext.String("health-addr"); addr != "" {
+		server := &http.Server{Addr: addr, Handler: watcher.Handler()}
+		go func() {
+			log.Info("Serving health and metrics endpoints", "addr", addr)
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error("Health endpoint failed", "err", err)
+			}
+		}()
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				log.Error("Cannot shut down health endpoint", "err", err)
+			}
+		}()
+	}
+
+	watcher.Run(ctx)
+	log.Info("Shutting down watcher")
+	return errors.Join(append(failed, fetch.WriteStates(fetchContexts))...)
+}
+
+// Run polls every domain until ctx is done.
+func (w *Watcher) Run(ctx context.Context) {
+	w.semaphore = make(chan struct{}, max(w.Parallelism, 1))
+
+	var wg sync.WaitGroup
+	for _, fetchContext := range w.fetchContexts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.watchDomain(ctx, fetchContext)
+		}()
+	}
+	wg.Wait()
+}
+
+// watchDomain polls one domain until ctx is done, reusing the same client for every poll.
+func (w *Watcher) watchDomain(ctx context.Context, fetchContext *fetch.Context) {
+	client := fetch.NewClient(fetchContext)
+	client.HTTPClient = w.HTTPClient
+
+	for {
+		failures, ok := w.poll(ctx, fetchContext, client)
+		if !ok {
+			return
+		}
+
+		delay := w.nextDelay(failures)
+		w.updateStatus(fetchContext.Domain, func(status *DomainStatus) {
+			status.NextPoll = time.Now().Add(delay)
+		})
+		log.Debug("Waiting for next poll", "domain", fetchContext.Domain, "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// poll runs one fetch and records its outcome, returning the domain's consecutive failures. ok is false once ctx is
+// done; a fetch interrupted by the cancellation is not recorded as a failure.
+func (w *Watcher) poll(ctx context.Context, fetchContext *fetch.Context, client webmention.Client) (failures int, ok bool) {
+	select {
+	case w.semaphore <- struct{}{}:
+	case <-ctx.Done():
+		return 0, false
+	}
+	client.SinceID = fetchContext.State.SinceIDFor(fetchContext.Domain)
+	result, err := fetch.Fetch(ctx, fetchContext, client)
+	<-w.semaphore
+
+	if err != nil && ctx.Err() != nil {
+		return 0, false
+	}
+
+	result.Err = err
+	fetchContext.RecordRun(result)
+	if err := fetch.WriteStates([]*fetch.Context{fetchContext}); err != nil {
+		log.Error("Cannot save state", "domain", fetchContext.Domain, "err", err)
+	}
+	if err := fetch.WriteMetrics(w.fetchContexts, w.MetricsTextfile); err != nil {
+		log.Error("Cannot save metrics", "err", err)
+	}
+	if err := fetch.CollectAvatars(w.fetchContexts); err != nil {
+		log.Error("Cannot remove unused avatars", "err", err)
+	}
+
+	w.updateStatus(fetchContext.Domain, func(status *DomainStatus) {
+		status.Polls++
+		if err != nil {
+			status.ConsecutiveFailures++
+			status.LastError = err.Error()
+		} else {
+			status.ConsecutiveFailures = 0
+			status.LastError = ""
+			status.LastSuccess = result.Started.Add(result.Duration)
+		}
+		failures = status.ConsecutiveFailures
+	})
+
+	if err != nil {
+		log.Error("Poll failed", "domain", fetchContext.Domain, "failures", failures, "err", err)
+	} else {
+		log.Info("Poll finished", "domain", fetchContext.Domain, "fetched", result.Fetched, "new", result.New,
+			"updated", result.Updated)
+	}
+	return failures, ctx.Err() == nil
+}
+
+// nextDelay returns the wait before the next poll: the interval with random jitter after a success, doubling with
+// every consecutive failure up to MaxBackoff.
+func (w *Watcher) nextDelay(failures int) time.Duration {
+	delay := w.Interval
+	for i := 0; i < failures && delay < w.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if w.MaxBackoff > 0 && delay > w.MaxBackoff {
+		delay = w.MaxBackoff
+	}
+	if w.Jitter > 0 {
+		delay += time.Duration(RandFunc(int64(2*w.Jitter))) - w.Jitter
+	}
+	return max(delay, 0)
+}
+
+func (w *Watcher) updateStatus(domain string, update func(status *DomainStatus)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	update(w.status[domain])
+}
+
+// Status returns a copy of every domain's status.
+func (w *Watcher) Status() []DomainStatus {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	statuses := make([]DomainStatus, 0, len(w.fetchContexts))
+	for _, fetchContext := range w.fetchContexts {
+		statuses = append(statuses, *w.status[fetchContext.Domain])
+	}
+	return statuses
+}
+
+// Handler serves the watcher's endpoints: /healthz, and /metrics when the fetch contexts collect metrics.
+func (w *Watcher) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", w.serveHealth)
+	if len(w.fetchContexts) > 0 && w.fetchContexts[0].Metrics != nil {
+		mux.Handle("/metrics", w.fetchContexts[0].Metrics.Handler())
+	}
+	return mux
+}
+
+// serveHealth reports every domain's status, responding 503 while any domain's most recent poll failed.
+func (w *Watcher) serveHealth(rw http.ResponseWriter, _ *http.Request) {
+	statuses := w.Status()
+	healthy := true
+	for _, status := range statuses {
+		if status.ConsecutiveFailures > 0 {
+			healthy = false
+		}
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(rw).Encode(struct {
+		Healthy bool           `json:"healthy"`
+		Domains []DomainStatus `json:"domains"`
+	}{healthy, statuses})
+	if err != nil {
+		log.Error("Cannot write health response", "err", err)
+	}
+}
@@ -0,0 +1,142 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blbecker/webmentionR/cmd/fetch"
+	"github.com/blbecker/webmentionR/metrics"
+	"github.com/blbecker/webmentionR/state"
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWatcher_Run(t *testing.T) {
+	Convey("Given a watcher over a healthy and a failing domain", t, func() {
+		var polls atomic.Int32
+		fetch.FetchFunc = func(ctx context.Context, client webmention.Client, mentionChan chan webmention.Mention, worker webmention.Fetchable) error {
+			defer close(mentionChan)
+			polls.Add(1)
+			if client.Domain == "bad.example.com" {
+				return fmt.Errorf("unauthorized")
+			}
+			mentionChan <- webmention.Mention{WMID: client.SinceID + 1, WMTarget: "https://good.example.com/post"}
+			return nil
+		}
+		fetch.PersistFunc = func(fetchedMentions []webmention.Mention, s *sync.WaitGroup, persistable webmention.Persistable) error {
+			defer s.Done()
+			return nil
+		}
+
+		sharedState := &state.State{}
+		registry := metrics.NewRegistry()
+		watcher := NewWatcher([]*fetch.Context{
+			{Profile: "good", Domain: "good.example.com", State: sharedState, Metrics: registry},
+			{Profile: "bad", Domain: "bad.example.com", State: sharedState, Metrics: registry},
+		})
+		watcher.Interval = 5 * time.Millisecond
+		watcher.Jitter = 0
+		watcher.MaxBackoff = 20 * time.Millisecond
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		watcher.Run(ctx)
+
+		Convey("every domain is polled repeatedly until the context ends", func() {
+			So(polls.Load(), ShouldBeGreaterThan, 2)
+		})
+
+		Convey("the in-memory state carries the cursor between polls", func() {
+			good := sharedState.Domain("good.example.com")
+			So(good.SinceID, ShouldBeGreaterThan, 1)
+			So(good.SinceID, ShouldEqual, len(good.History))
+		})
+
+		Convey("the health endpoint reports the failing domain", func() {
+			server := httptest.NewServer(watcher.Handler())
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + "/healthz")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+
+			var body struct {
+				Healthy bool           `json:"healthy"`
+				Domains []DomainStatus `json:"domains"`
+			}
+			So(json.NewDecoder(resp.Body).Decode(&body), ShouldBeNil)
+			So(body.Healthy, ShouldBeFalse)
+			So(body.Domains, ShouldHaveLength, 2)
+			So(body.Domains[0].ConsecutiveFailures, ShouldEqual, 0)
+			So(body.Domains[1].ConsecutiveFailures, ShouldBeGreaterThan, 0)
+			So(body.Domains[1].LastError, ShouldContainSubstring, "unauthorized")
+		})
+
+		Convey("the metrics endpoint reports every domain's runs", func() {
+			recorder := httptest.NewRecorder()
+			watcher.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+			So(recorder.Body.String(), ShouldContainSubstring, `webmentionr_runs_total{domain="bad.example.com",result="failure"}`)
+			So(recorder.Body.String(), ShouldContainSubstring, `webmentionr_runs_total{domain="good.example.com",result="success"}`)
+		})
+	})
+}
+
+func TestCommand_Flags(t *testing.T) {
+	Convey("watch takes the fetch flags but the run report, which it doesn't write", t, func() {
+		var names []string
+		for _, f := range Command.Flags {
+			names = append(names, f.Names()...)
+		}
+		So(names, ShouldContain, "interval")
+		So(names, ShouldContain, "domain")
+		So(names, ShouldNotContain, "report")
+		So(names, ShouldNotContain, "report-format")
+	})
+}
+
+func TestWatcher_Health(t *testing.T) {
+	Convey("A watcher that hasn't failed is healthy", t, func() {
+		watcher := NewWatcher([]*fetch.Context{{Domain: "example.com", State: &state.State{}}})
+		recorder := httptest.NewRecorder()
+		watcher.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		So(recorder.Code, ShouldEqual, http.StatusOK)
+		So(recorder.Body.String(), ShouldContainSubstring, "\"healthy\":true")
+	})
+}
+
+func TestWatcher_nextDelay(t *testing.T) {
+	Convey("Given a watcher", t, func() {
+		watcher := NewWatcher(nil)
+		watcher.Interval = time.Minute
+		watcher.MaxBackoff = 5 * time.Minute
+		watcher.Jitter = 0
+
+		Convey("a success waits for the interval", func() {
+			So(watcher.nextDelay(0), ShouldEqual, time.Minute)
+		})
+
+		Convey("failures back off exponentially up to the maximum", func() {
+			So(watcher.nextDelay(1), ShouldEqual, 2*time.Minute)
+			So(watcher.nextDelay(2), ShouldEqual, 4*time.Minute)
+			So(watcher.nextDelay(3), ShouldEqual, 5*time.Minute)
+			So(watcher.nextDelay(50), ShouldEqual, 5*time.Minute)
+		})
+
+		Convey("jitter varies the delay in both directions", func() {
+			watcher.Jitter = 10 * time.Second
+			RandFunc = func(n int64) int64 { return 0 }
+			So(watcher.nextDelay(0), ShouldEqual, 50*time.Second)
+			RandFunc = func(n int64) int64 { return n - 1 }
+			So(watcher.nextDelay(0), ShouldEqual, 70*time.Second-time.Nanosecond)
+		})
+	})
+}
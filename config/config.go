@@ -0,0 +1,430 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blbecker/webmentionR/secret"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prepended to the upper-cased flag name to form the environment variable bound to each flag.
+const EnvPrefix = "WEBMENTIONR_"
+
+// File is the on-disk configuration: a set of named profiles and the profile to use when none is selected.
+type File struct {
+	DefaultProfile string             `yaml:"default-profile"`
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// Profile holds the settings for one site. Every field mirrors the fetch flag of the same name.
+type Profile struct {
+	Domain       string `yaml:"domain"`
+	Token        string `yaml:"token"`
+	TokenEnv     string `yaml:"token-env"`
+	TokenFile    string `yaml:"token-file"`
+	TokenCommand string `yaml:"token-command"`
+	Destination  string `yaml:"destination"`
+	StateFile    string `yaml:"state-file"`
+	PageSize     int    `yaml:"page-size"`
+	Threads      bool   `yaml:"threads"`
+	// HookExec and HookURL are fired after a run that added or updated mentions.
+	HookExec []string `yaml:"hook-exec"`
+	HookURL  []string `yaml:"hook-url"`
+	// Stage lists the pipeline stages applied to every fetched mention, in order.
+	Stage []string `yaml:"stage"`
+	// ModerationRules is applied to fetched mentions before any stage.
+	ModerationRules string `yaml:"moderation-rules"`
+	ModerationLog   string `yaml:"moderation-log"`
+	// HeldFile and Blocklist default to files next to Destination.
+	HeldFile  string `yaml:"held-file"`
+	Blocklist string `yaml:"blocklist"`
+	// SpamScore or SpamThreshold turns on spam scoring; SpamPhrase extends the built-in spam phrases.
+	SpamScore     bool     `yaml:"spam-score"`
+	SpamThreshold int      `yaml:"spam-threshold"`
+	SpamAction    string   `yaml:"spam-action"`
+	SpamPhrase    []string `yaml:"spam-phrase"`
+	// PrivateDir defaults to a directory next to Destination; the passphrase or key file encrypt it.
+	PrivateDir string `yaml:"private-dir"`
+	// PrivatePassphrase may also be read from an environment variable, a file or a command, like the token.
+	PrivatePassphrase        string `yaml:"private-passphrase"`
+	PrivatePassphraseEnv     string `yaml:"private-passphrase-env"`
+	PrivatePassphraseFile    string `yaml:"private-passphrase-file"`
+	PrivatePassphraseCommand string `yaml:"private-passphrase-command"`
+	PrivateKeyFile           string `yaml:"private-key-file"`
+	NotifyPrivate            bool   `yaml:"notify-private"`
+	// SanitizeAllow replaces the built-in allowlist of the HTML sanitizer; NoSanitize keeps the HTML as received and
+	// SanitizeKeepRaw keeps it next to the sanitized HTML.
+	SanitizeAllow   []string `yaml:"sanitize-allow"`
+	NoSanitize      bool     `yaml:"no-sanitize"`
+	SanitizeKeepRaw bool     `yaml:"sanitize-keep-raw"`
+	// AvatarDir turns on mirroring author photos into the site's static files.
+	AvatarDir       string `yaml:"avatar-dir"`
+	AvatarURLPrefix string `yaml:"avatar-url-prefix"`
+	AvatarSize      int    `yaml:"avatar-size"`
+	// AvatarFallback generates initials or identicon avatars for authors without a photo.
+	AvatarFallback string `yaml:"avatar-fallback"`
+	AvatarGC       bool   `yaml:"avatar-gc"`
+	// Authors turns on resolving mentions to stable author IDs and writing the authors index.
+	Authors       bool   `yaml:"authors"`
+	AuthorsIndex  string `yaml:"authors-index"`
+	AuthorAliases string `yaml:"author-aliases"`
+	// MastodonStatuses maps posts to the Mastodon statuses whose favourites, boosts and replies are imported.
+	MastodonStatuses string `yaml:"mastodon-statuses"`
+	// MastodonInstance is the server MastodonToken belongs to, the only one it is sent to.
+	MastodonInstance string `yaml:"mastodon-instance"`
+	// MastodonToken may also be read from an environment variable, a file or a command, like the token.
+	MastodonToken        string `yaml:"mastodon-token"`
+	MastodonTokenEnv     string `yaml:"mastodon-token-env"`
+	MastodonTokenFile    string `yaml:"mastodon-token-file"`
+	MastodonTokenCommand string `yaml:"mastodon-token-command"`
+	// The notify settings deliver newly stored mentions as one message per run.
+	NotifyTemplate        string   `yaml:"notify-template"`
+	NotifyWebhook         []string `yaml:"notify-webhook"`
+	NotifyWebhookFormat   string   `yaml:"notify-webhook-format"`
+	NotifyWebhookTemplate string   `yaml:"notify-webhook-template"`
+	NotifySMTPAddr        string   `yaml:"notify-smtp-addr"`
+	NotifySMTPFrom        string   `yaml:"notify-smtp-from"`
+	NotifySMTPTo          []string `yaml:"notify-smtp-to"`
+	NotifySMTPUsername    string   `yaml:"notify-smtp-username"`
+	// NotifySMTPPassword may also be read from an environment variable, a file or a command, like the token.
+	NotifySMTPPassword        string `yaml:"notify-smtp-password"`
+	NotifySMTPPasswordEnv     string `yaml:"notify-smtp-password-env"`
+	NotifySMTPPasswordFile    string `yaml:"notify-smtp-password-file"`
+	NotifySMTPPasswordCommand string `yaml:"notify-smtp-password-command"`
+}
+
+//=== Bindings for tests
+
+var ReadFileFunc = os.ReadFile
+
+var UserConfigDirFunc = os.UserConfigDir
+
+var LookupEnvFunc = os.LookupEnv
+
+//=== Bindings for tests
+
+// DefaultPath returns the XDG location of the configuration file, e.g. ~/.config/webmentionR/config.yaml.
+func DefaultPath() (string, error) {
+	configDir, err := UserConfigDirFunc()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine user config directory: %w", err)
+	}
+	return filepath.Join(configDir, "webmentionR", "config.yaml"), nil
+}
+
+// Load reads the configuration file at path. When path is empty the default location is tried instead, and a
+// missing default file yields an empty configuration rather than an error.
+func Load(path string) (*File, error) {
+	explicit := path != ""
+	if !explicit {
+		defaultPath, err := DefaultPath()
+		if err != nil {
+			log.Debug("Skipping default config file", "err", err)
+			return &File{}, nil
+		}
+		path = defaultPath
+	}
+
+	fileData, err := ReadFileFunc(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return &File{}, nil
+		}
+		return nil, fmt.Errorf("could not open config file: %w", err)
+	}
+
+	var configFile File
+	if err := yaml.Unmarshal(fileData, &configFile); err != nil {
+		return nil, fmt.Errorf("error parsing config file: %v", err.Error())
+	}
+	log.Debug("Loaded config file", "path", path, "profiles", len(configFile.Profiles))
+	return &configFile, nil
+}
+
+// Select returns the named profiles keyed by name, or every profile when all is set. Without names the result is
+// the single profile chosen by Profile, keyed "default" when the config file defines no profiles.
+func (f *File) Select(names []string, all bool) (map[string]Profile, error) {
+	if all {
+		if len(f.Profiles) == 0 {
+			return nil, fmt.Errorf("no profiles are configured")
+		}
+		return f.Profiles, nil
+	}
+	if len(names) == 0 {
+		names = []string{""}
+	}
+
+	selected := map[string]Profile{}
+	for _, name := range names {
+		resolvedName, err := f.resolveName(name)
+		if err != nil {
+			return nil, err
+		}
+		profile, err := f.Profile(resolvedName)
+		if err != nil {
+			return nil, err
+		}
+		if resolvedName == "" {
+			resolvedName = "default"
+		}
+		selected[resolvedName] = profile
+	}
+	return selected, nil
+}
+
+// Profile returns the named profile. An empty name selects the default profile, or the only profile when exactly
+// one is defined. A configuration without profiles yields an empty Profile.
+func (f *File) Profile(name string) (Profile, error) {
+	name, err := f.resolveName(name)
+	if err != nil || name == "" {
+		return Profile{}, err
+	}
+
+	profile, ok := f.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("no profile named '%s' in config file", name)
+	}
+	return profile, nil
+}
+
+// resolveName maps an empty profile name to the default or only profile. It stays empty when no profiles exist.
+func (f *File) resolveName(name string) (string, error) {
+	if name == "" {
+		name = f.DefaultProfile
+	}
+	if name != "" {
+		return name, nil
+	}
+
+	switch len(f.Profiles) {
+	case 0:
+		return "", nil
+	case 1:
+		return f.ProfileNames()[0], nil
+	default:
+		return "", fmt.Errorf("several profiles are configured, select one of %v", f.ProfileNames())
+	}
+}
+
+// ProfileNames returns the configured profile names in sorted order.
+func (f *File) ProfileNames() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Values returns the profile's non-zero settings keyed by flag name.
+func (p Profile) Values() map[string]string {
+	values := map[string]string{}
+	set := func(name, value string) {
+		if value != "" {
+			values[name] = value
+		}
+	}
+	set("domain", p.Domain)
+	set("token", p.Token)
+	set("token-env", p.TokenEnv)
+	set("token-file", p.TokenFile)
+	set("token-command", p.TokenCommand)
+	set("destination", p.Destination)
+	set("state-file", p.StateFile)
+	if p.PageSize != 0 {
+		set("page-size", strconv.Itoa(p.PageSize))
+	}
+	if p.Threads {
+		set("threads", "true")
+	}
+	set("moderation-rules", p.ModerationRules)
+	set("moderation-log", p.ModerationLog)
+	set("held-file", p.HeldFile)
+	set("blocklist", p.Blocklist)
+	if p.SpamScore {
+		set("spam-score", "true")
+	}
+	if p.SpamThreshold != 0 {
+		set("spam-threshold", strconv.Itoa(p.SpamThreshold))
+	}
+	set("spam-action", p.SpamAction)
+	set("private-dir", p.PrivateDir)
+	set("private-passphrase", p.PrivatePassphrase)
+	set("private-passphrase-env", p.PrivatePassphraseEnv)
+	set("private-passphrase-file", p.PrivatePassphraseFile)
+	set("private-passphrase-command", p.PrivatePassphraseCommand)
+	set("private-key-file", p.PrivateKeyFile)
+	if p.NotifyPrivate {
+		set("notify-private", "true")
+	}
+	if p.NoSanitize {
+		set("no-sanitize", "true")
+	}
+	if p.SanitizeKeepRaw {
+		set("sanitize-keep-raw", "true")
+	}
+	set("avatar-dir", p.AvatarDir)
+	set("avatar-url-prefix", p.AvatarURLPrefix)
+	if p.AvatarSize != 0 {
+		set("avatar-size", strconv.Itoa(p.AvatarSize))
+	}
+	set("avatar-fallback", p.AvatarFallback)
+	if p.AvatarGC {
+		set("avatar-gc", "true")
+	}
+	if p.Authors {
+		set("authors", "true")
+	}
+	set("authors-index", p.AuthorsIndex)
+	set("author-aliases", p.AuthorAliases)
+	set("mastodon-statuses", p.MastodonStatuses)
+	set("mastodon-instance", p.MastodonInstance)
+	set("mastodon-token", p.MastodonToken)
+	set("mastodon-token-env", p.MastodonTokenEnv)
+	set("mastodon-token-file", p.MastodonTokenFile)
+	set("mastodon-token-command", p.MastodonTokenCommand)
+	set("notify-template", p.NotifyTemplate)
+	set("notify-webhook-format", p.NotifyWebhookFormat)
+	set("notify-webhook-template", p.NotifyWebhookTemplate)
+	set("notify-smtp-addr", p.NotifySMTPAddr)
+	set("notify-smtp-from", p.NotifySMTPFrom)
+	set("notify-smtp-username", p.NotifySMTPUsername)
+	set("notify-smtp-password", p.NotifySMTPPassword)
+	set("notify-smtp-password-env", p.NotifySMTPPasswordEnv)
+	set("notify-smtp-password-file", p.NotifySMTPPasswordFile)
+	set("notify-smtp-password-command", p.NotifySMTPPasswordCommand)
+	return values
+}
+
+// Lists returns the profile's non-empty list settings keyed by flag name.
+func (p Profile) Lists() map[string][]string {
+	lists := map[string][]string{}
+	set := func(name string, value []string) {
+		if len(value) > 0 {
+			lists[name] = value
+		}
+	}
+	set("hook-exec", p.HookExec)
+	set("hook-url", p.HookURL)
+	set("notify-webhook", p.NotifyWebhook)
+	set("notify-smtp-to", p.NotifySMTPTo)
+	set("stage", p.Stage)
+	set("spam-phrase", p.SpamPhrase)
+	set("sanitize-allow", p.SanitizeAllow)
+	return lists
+}
+
+// Settings resolves flag values for one profile with the precedence flags > env > file > flag default.
+type Settings struct {
+	cliContext *cli.Context
+	values     map[string]string
+	lists      map[string][]string
+}
+
+// NewSettings combines cliContext with profile.
+func NewSettings(cliContext *cli.Context, profile Profile) *Settings {
+	return &Settings{cliContext: cliContext, values: profile.Values(), lists: profile.Lists()}
+}
+
+func (s *Settings) lookup(name string) (string, bool) {
+	if s.cliContext.IsSet(name) {
+		return "", false
+	}
+	value, ok := s.values[name]
+	return value, ok
+}
+
+// String returns the resolved value of the named string flag.
+func (s *Settings) String(name string) string {
+	if value, ok := s.lookup(name); ok {
+		return value
+	}
+	return s.cliContext.String(name)
+}
+
+// Int returns the resolved value of the named int flag.
+func (s *Settings) Int(name string) int {
+	if value, ok := s.lookup(name); ok {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return s.cliContext.Int(name)
+}
+
+// StringSlice returns the resolved value of the named string slice flag. A list given as flags or through the
+// environment replaces the profile's list rather than extending it.
+func (s *Settings) StringSlice(name string) []string {
+	if list, ok := s.lists[name]; ok && !s.cliContext.IsSet(name) {
+		return list
+	}
+	return s.cliContext.StringSlice(name)
+}
+
+// Bool returns the resolved value of the named bool flag.
+func (s *Settings) Bool(name string) bool {
+	if value, ok := s.lookup(name); ok {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return s.cliContext.Bool(name)
+}
+
+// The layers a setting can be given at, from the lowest precedence to the highest.
+const (
+	layerUnset = iota
+	layerFile
+	layerEnv
+	layerFlag
+)
+
+// layer tells where the named setting was given. A flag repeating the value of its environment variable counts as
+// given through the environment.
+func (s *Settings) layer(name string) int {
+	if s.cliContext.IsSet(name) {
+		if env, ok := LookupEnvFunc(EnvVars(name)[0]); ok && env == s.cliContext.String(name) {
+			return layerEnv
+		}
+		return layerFlag
+	}
+	if _, ok := s.values[name]; ok {
+		return layerFile
+	}
+	return layerUnset
+}
+
+// SecretSuffixes are appended to the name of a secret setting to name the settings giving it: the secret itself, an
+// environment variable, a file or a command printing it, e.g. token, token-env, token-file and token-command.
+var SecretSuffixes = []string{"", "-env", "-file", "-command"}
+
+// Secret returns the source of the named secret. Only the sources given at the highest layer are kept, so a source
+// given as a flag replaces one in the environment, which replaces one in the config file. Several sources at that
+// layer are left for secret.Source.Resolve to reject.
+func (s *Settings) Secret(name string) secret.Source {
+	top := layerUnset
+	for _, suffix := range SecretSuffixes {
+		top = max(top, s.layer(name+suffix))
+	}
+	value := func(suffix string) string {
+		if s.layer(name+suffix) != top {
+			return ""
+		}
+		return s.String(name + suffix)
+	}
+	return secret.Source{Name: name, Value: value(""), Env: value("-env"), File: value("-file"), Command: value("-command")}
+}
+
+// EnvVars returns the environment variable bound to the named flag, e.g. WEBMENTIONR_STATE_FILE for state-file.
+func EnvVars(flagName string) []string {
+	return []string{EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))}
+}
@@ -0,0 +1,289 @@
+package config
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/blbecker/webmentionR/secret"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/urfave/cli/v2"
+)
+
+type MockFileReader struct {
+	WantedErr      error
+	WantedData     []byte
+	TargetFilePath string
+}
+
+func (m *MockFileReader) ReadFile(filepath string) ([]byte, error) {
+	m.TargetFilePath = filepath
+	return m.WantedData, m.WantedErr
+}
+
+const testConfig = `
+default-profile: blog
+profiles:
+  blog:
+    domain: blog.example.com
+    token: blog-token
+    destination: data/webmentions
+    page-size: 50
+  notes:
+    domain: notes.example.com
+    threads: true
+`
+
+// newTestContext builds a cli.Context for flags, parsing args as the command line would.
+func newTestContext(flags []cli.Flag, args []string) *cli.Context {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range flags {
+		_ = f.Apply(set)
+	}
+	_ = set.Parse(args)
+	cliContext := cli.NewContext(nil, set, nil)
+	cliContext.Command = &cli.Command{Flags: flags}
+	return cliContext
+}
+
+func TestLoad(t *testing.T) {
+	Convey("Given a valid config file", t, func() {
+		mockReader := &MockFileReader{WantedData: []byte(testConfig)}
+		ReadFileFunc = mockReader.ReadFile
+
+		Convey("When loading it from an explicit path", func() {
+			configFile, err := Load("webmentionR.yaml")
+
+			Convey("Then the profiles are parsed", func() {
+				So(err, ShouldBeNil)
+				So(mockReader.TargetFilePath, ShouldEqual, "webmentionR.yaml")
+				So(configFile.DefaultProfile, ShouldEqual, "blog")
+				So(configFile.ProfileNames(), ShouldResemble, []string{"blog", "notes"})
+				So(configFile.Profiles["blog"].PageSize, ShouldEqual, 50)
+				So(configFile.Profiles["notes"].Threads, ShouldBeTrue)
+			})
+		})
+
+		Convey("When no path is given, the XDG default is used", func() {
+			UserConfigDirFunc = func() (string, error) { return "/home/user/.config", nil }
+
+			_, err := Load("")
+			So(err, ShouldBeNil)
+			So(mockReader.TargetFilePath, ShouldEqual, "/home/user/.config/webmentionR/config.yaml")
+		})
+	})
+
+	Convey("Given a missing config file", t, func() {
+		ReadFileFunc = (&MockFileReader{WantedErr: fs.ErrNotExist}).ReadFile
+
+		Convey("A missing default file yields an empty config", func() {
+			configFile, err := Load("")
+			So(err, ShouldBeNil)
+			So(configFile.Profiles, ShouldBeEmpty)
+		})
+
+		Convey("A missing explicit file is an error", func() {
+			_, err := Load("missing.yaml")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an invalid config file", t, func() {
+		ReadFileFunc = (&MockFileReader{WantedData: []byte("profiles: [")}).ReadFile
+
+		_, err := Load("broken.yaml")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "error parsing config file")
+	})
+
+	Convey("Given an unreadable config file", t, func() {
+		ReadFileFunc = (&MockFileReader{WantedErr: fmt.Errorf("permission denied")}).ReadFile
+
+		_, err := Load("")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestFile_Profile(t *testing.T) {
+	Convey("Given a config file with several profiles", t, func() {
+		configFile := File{
+			Profiles: map[string]Profile{
+				"blog":  {Domain: "blog.example.com"},
+				"notes": {Domain: "notes.example.com"},
+			},
+		}
+
+		Convey("A named profile is returned", func() {
+			profile, err := configFile.Profile("notes")
+			So(err, ShouldBeNil)
+			So(profile.Domain, ShouldEqual, "notes.example.com")
+		})
+
+		Convey("An unknown profile is an error", func() {
+			_, err := configFile.Profile("shop")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("No name and no default is ambiguous", func() {
+			_, err := configFile.Profile("")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("No name selects the default profile", func() {
+			configFile.DefaultProfile = "blog"
+			profile, err := configFile.Profile("")
+			So(err, ShouldBeNil)
+			So(profile.Domain, ShouldEqual, "blog.example.com")
+		})
+	})
+
+	Convey("Given a config file with a single profile, it is selected implicitly", t, func() {
+		configFile := File{Profiles: map[string]Profile{"blog": {Domain: "blog.example.com"}}}
+		profile, err := configFile.Profile("")
+		So(err, ShouldBeNil)
+		So(profile.Domain, ShouldEqual, "blog.example.com")
+	})
+
+	Convey("Given an empty config file, an empty profile is returned", t, func() {
+		profile, err := (&File{}).Profile("")
+		So(err, ShouldBeNil)
+		So(profile, ShouldResemble, Profile{})
+	})
+}
+
+func TestSettings(t *testing.T) {
+	Convey("Given fetch-like flags and a profile", t, func() {
+		flags := []cli.Flag{
+			&cli.StringFlag{Name: "domain", EnvVars: EnvVars("domain")},
+			&cli.StringFlag{Name: "token", EnvVars: EnvVars("token")},
+			&cli.StringFlag{Name: "token-file", EnvVars: EnvVars("token-file")},
+			&cli.StringFlag{Name: "token-command", EnvVars: EnvVars("token-command")},
+			&cli.IntFlag{Name: "page-size", Value: 10, EnvVars: EnvVars("page-size")},
+			&cli.BoolFlag{Name: "threads", EnvVars: EnvVars("threads")},
+			&cli.StringSliceFlag{Name: "hook-exec", EnvVars: EnvVars("hook-exec")},
+		}
+		profile := Profile{
+			Domain:   "file.example.com",
+			Token:    "file-token",
+			PageSize: 50,
+			Threads:  true,
+			HookExec: []string{"make build", "make deploy"},
+		}
+
+		Convey("Values from the file fill in unset flags", func() {
+			settings := NewSettings(newTestContext(flags, nil), profile)
+			So(settings.String("domain"), ShouldEqual, "file.example.com")
+			So(settings.String("token"), ShouldEqual, "file-token")
+			So(settings.Int("page-size"), ShouldEqual, 50)
+			So(settings.Bool("threads"), ShouldBeTrue)
+			So(settings.StringSlice("hook-exec"), ShouldResemble, []string{"make build", "make deploy"})
+		})
+
+		Convey("A list given as flags replaces the file's list", func() {
+			settings := NewSettings(newTestContext(flags, []string{"--hook-exec", "hugo"}), profile)
+			So(settings.StringSlice("hook-exec"), ShouldResemble, []string{"hugo"})
+		})
+
+		Convey("Flag defaults apply when neither the flag nor the file sets a value", func() {
+			settings := NewSettings(newTestContext(flags, nil), Profile{})
+			So(settings.Int("page-size"), ShouldEqual, 10)
+			So(settings.Bool("threads"), ShouldBeFalse)
+		})
+
+		Convey("Flags take precedence over the file", func() {
+			settings := NewSettings(newTestContext(flags, []string{"--domain", "flag.example.com"}), profile)
+			So(settings.String("domain"), ShouldEqual, "flag.example.com")
+		})
+
+		Convey("A token source given as a flag replaces the file's token source", func() {
+			cliContext := newTestContext(flags, []string{"--token-file", "/run/secrets/token"})
+			settings := NewSettings(cliContext, Profile{TokenCommand: "pass show webmention.io"})
+			So(settings.Secret("token"), ShouldResemble, secret.Source{Name: "token", File: "/run/secrets/token"})
+		})
+
+		Convey("A token source given as a flag replaces one in the environment", func() {
+			t.Setenv("WEBMENTIONR_TOKEN_COMMAND", "pass show webmention.io")
+			settings := NewSettings(newTestContext(flags, []string{"--token-file", "/run/secrets/token"}), profile)
+			So(settings.Secret("token"), ShouldResemble, secret.Source{Name: "token", File: "/run/secrets/token"})
+		})
+
+		Convey("A token source in the environment replaces the file's token source", func() {
+			t.Setenv("WEBMENTIONR_TOKEN_COMMAND", "pass show webmention.io")
+			settings := NewSettings(newTestContext(flags, nil), profile)
+			So(settings.Secret("token"), ShouldResemble, secret.Source{Name: "token", Command: "pass show webmention.io"})
+		})
+
+		Convey("Token sources conflicting within one layer are all kept, to be rejected", func() {
+			settings := NewSettings(newTestContext(flags, []string{"--token", "t", "--token-file", "/run/secrets/token"}), profile)
+			_, err := settings.Secret("token").Resolve(context.Background())
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Environment variables take precedence over the file", func() {
+			t.Setenv("WEBMENTIONR_TOKEN", "env-token")
+			settings := NewSettings(newTestContext(flags, nil), profile)
+			So(settings.String("token"), ShouldEqual, "env-token")
+		})
+	})
+}
+
+func TestFile_Select(t *testing.T) {
+	Convey("Given a config file with several profiles", t, func() {
+		configFile := File{
+			DefaultProfile: "blog",
+			Profiles: map[string]Profile{
+				"blog":  {Domain: "blog.example.com"},
+				"notes": {Domain: "notes.example.com"},
+			},
+		}
+
+		Convey("Every profile is selected with all", func() {
+			profiles, err := configFile.Select(nil, true)
+			So(err, ShouldBeNil)
+			So(profiles, ShouldHaveLength, 2)
+		})
+
+		Convey("Named profiles are selected", func() {
+			profiles, err := configFile.Select([]string{"notes", "blog"}, false)
+			So(err, ShouldBeNil)
+			So(profiles, ShouldHaveLength, 2)
+			So(profiles["notes"].Domain, ShouldEqual, "notes.example.com")
+		})
+
+		Convey("Without names the default profile is selected under its own name", func() {
+			profiles, err := configFile.Select(nil, false)
+			So(err, ShouldBeNil)
+			So(profiles, ShouldHaveLength, 1)
+			So(profiles["blog"].Domain, ShouldEqual, "blog.example.com")
+		})
+
+		Convey("An unknown profile is an error", func() {
+			_, err := configFile.Select([]string{"shop"}, false)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an empty config file", t, func() {
+		configFile := File{}
+
+		Convey("A single default profile is selected", func() {
+			profiles, err := configFile.Select(nil, false)
+			So(err, ShouldBeNil)
+			So(profiles, ShouldContainKey, "default")
+		})
+
+		Convey("Selecting all profiles is an error", func() {
+			_, err := configFile.Select(nil, true)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestEnvVars(t *testing.T) {
+	Convey("Flag names map to prefixed upper snake case variables", t, func() {
+		So(EnvVars("state-file"), ShouldResemble, []string{"WEBMENTIONR_STATE_FILE"})
+		So(EnvVars("token"), ShouldResemble, []string{"WEBMENTIONR_TOKEN"})
+	})
+}
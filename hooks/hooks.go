@@ -0,0 +1,167 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/blbecker/webmentionR/secret"
+	"github.com/blbecker/webmentionR/webmention"
+	"github.com/charmbracelet/log"
+)
+
+// Payload describes the mentions a run added or updated, per target. It is the JSON body sent to every hook.
+type Payload struct {
+	Domain  string         `json:"domain"`
+	New     int            `json:"new"`
+	Updated int            `json:"updated"`
+	Targets []TargetChange `json:"targets"`
+}
+
+// TargetChange lists the mentions of one target that were added or updated.
+type TargetChange struct {
+	Target  string               `json:"target"`
+	Path    string               `json:"path"`
+	New     []webmention.Mention `json:"new,omitempty"`
+	Updated []webmention.Mention `json:"updated,omitempty"`
+}
+
+// NewPayload builds the payload for domain from the persistence outcome of each target, leaving out unchanged targets.
+func NewPayload(domain string, stats []webmention.PersistStats) Payload {
+	payload := Payload{Domain: domain, Targets: []TargetChange{}}
+	for _, target := range stats {
+		if !target.Changed() {
+			continue
+		}
+		payload.New += len(target.New)
+		payload.Updated += len(target.Updated)
+		payload.Targets = append(payload.Targets, TargetChange{
+			Target:  target.Target,
+			Path:    target.Path,
+			New:     target.New,
+			Updated: target.Updated,
+		})
+	}
+	return payload
+}
+
+// Changed reports whether the run added or updated any mention.
+func (p Payload) Changed() bool {
+	return p.New > 0 || p.Updated > 0
+}
+
+// Hook is notified after a run persisted changes.
+type Hook interface {
+	Fire(ctx context.Context, payload Payload) error
+}
+
+// Run fires every hook with payload, skipping them all when nothing changed. A failing hook doesn't stop the others.
+func Run(ctx context.Context, hooks []Hook, payload Payload) error {
+	if !payload.Changed() {
+		log.Debug("No changes, skipping hooks", "domain", payload.Domain)
+		return nil
+	}
+
+	var errs []error
+	for _, hook := range hooks {
+		log.Info("Running hook", "hook", hook, "new", payload.New, "updated", payload.Updated)
+		if err := hook.Fire(ctx, payload); err != nil {
+			errs = append(errs, fmt.Errorf("hook %s failed: %w", hook, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ExecHook runs a shell command with the payload on stdin. WEBMENTIONR_DOMAIN, WEBMENTIONR_NEW and
+// WEBMENTIONR_UPDATED are set for commands that don't need the full payload.
+type ExecHook struct {
+	Command string
+}
+
+func (h ExecHook) Fire(ctx context.Context, payload Payload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"WEBMENTIONR_DOMAIN="+payload.Domain,
+		"WEBMENTIONR_NEW="+strconv.Itoa(payload.New),
+		"WEBMENTIONR_UPDATED="+strconv.Itoa(payload.Updated),
+	)
+	return cmd.Run()
+}
+
+func (h ExecHook) String() string {
+	return "exec:" + h.Command
+}
+
+// HTTPHook POSTs the payload as JSON to URL, e.g. a static site host's build webhook.
+type HTTPHook struct {
+	URL string
+	// Client sends the request, a client with a 30 second timeout when nil.
+	Client *http.Client
+}
+
+func (h HTTPHook) Fire(ctx context.Context, payload Payload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("error building request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", secret.RedactString(err.Error(), h.URL))
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return nil
+}
+
+// String names the hook by host only, as build webhook URLs often embed a secret in their path.
+func (h HTTPHook) String() string {
+	parsed, err := url.Parse(h.URL)
+	if err != nil {
+		return "http:invalid-url"
+	}
+	return "http:" + parsed.Host
+}
+
+// FromConfig builds the hooks for the given commands and URLs, commands first.
+func FromConfig(commands, urls []string) []Hook {
+	var hooks []Hook
+	for _, command := range commands {
+		hooks = append(hooks, ExecHook{Command: command})
+	}
+	for _, hookURL := range urls {
+		hooks = append(hooks, HTTPHook{URL: hookURL})
+	}
+	return hooks
+}
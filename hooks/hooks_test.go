@@ -0,0 +1,150 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recordingHook remembers every payload it was fired with.
+type recordingHook struct {
+	payloads []Payload
+	err      error
+}
+
+func (h *recordingHook) Fire(_ context.Context, payload Payload) error {
+	h.payloads = append(h.payloads, payload)
+	return h.err
+}
+
+func testStats() []webmention.PersistStats {
+	return []webmention.PersistStats{
+		{Target: "https://example.com/a", Path: "data/a.json", New: []webmention.Mention{{WMID: 1}, {WMID: 2}}},
+		{Target: "https://example.com/b", Path: "data/b.json", Unchanged: 3},
+		{Target: "https://example.com/c", Path: "data/c.json", Updated: []webmention.Mention{{WMID: 3}}},
+	}
+}
+
+func TestNewPayload(t *testing.T) {
+	Convey("Only changed targets are included in the payload", t, func() {
+		payload := NewPayload("example.com", testStats())
+		So(payload.Domain, ShouldEqual, "example.com")
+		So(payload.New, ShouldEqual, 2)
+		So(payload.Updated, ShouldEqual, 1)
+		So(payload.Targets, ShouldHaveLength, 2)
+		So(payload.Targets[0].Target, ShouldEqual, "https://example.com/a")
+		So(payload.Targets[1].Target, ShouldEqual, "https://example.com/c")
+		So(payload.Changed(), ShouldBeTrue)
+	})
+
+	Convey("A run without changes gives an unchanged payload", t, func() {
+		payload := NewPayload("example.com", []webmention.PersistStats{{Target: "https://example.com/b", Unchanged: 3}})
+		So(payload.Targets, ShouldBeEmpty)
+		So(payload.Changed(), ShouldBeFalse)
+	})
+}
+
+func TestRun(t *testing.T) {
+	Convey("Given two hooks", t, func() {
+		first, second := &recordingHook{}, &recordingHook{}
+
+		Convey("Nothing is fired when nothing changed", func() {
+			err := Run(context.Background(), []Hook{first, second}, NewPayload("example.com", nil))
+			So(err, ShouldBeNil)
+			So(first.payloads, ShouldBeEmpty)
+			So(second.payloads, ShouldBeEmpty)
+		})
+
+		Convey("A failing hook doesn't stop the others", func() {
+			first.err = io.ErrUnexpectedEOF
+			err := Run(context.Background(), []Hook{first, second}, NewPayload("example.com", testStats()))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, io.ErrUnexpectedEOF.Error())
+			So(first.payloads, ShouldHaveLength, 1)
+			So(second.payloads, ShouldHaveLength, 1)
+		})
+	})
+}
+
+func TestExecHook(t *testing.T) {
+	Convey("The command receives the payload on stdin and the counts in its environment", t, func() {
+		dir := t.TempDir()
+		payloadFile := filepath.Join(dir, "payload.json")
+		envFile := filepath.Join(dir, "env")
+		hook := ExecHook{Command: "cat > " + payloadFile +
+			` && echo "$WEBMENTIONR_DOMAIN $WEBMENTIONR_NEW $WEBMENTIONR_UPDATED" > ` + envFile}
+
+		So(hook.Fire(context.Background(), NewPayload("example.com", testStats())), ShouldBeNil)
+
+		data, err := os.ReadFile(payloadFile)
+		So(err, ShouldBeNil)
+		var payload Payload
+		So(json.Unmarshal(data, &payload), ShouldBeNil)
+		So(payload.Targets, ShouldHaveLength, 2)
+
+		env, err := os.ReadFile(envFile)
+		So(err, ShouldBeNil)
+		So(strings.TrimSpace(string(env)), ShouldEqual, "example.com 2 1")
+	})
+
+	Convey("A failing command is an error", t, func() {
+		So(ExecHook{Command: "exit 3"}.Fire(context.Background(), Payload{}), ShouldNotBeNil)
+	})
+}
+
+func TestHTTPHook(t *testing.T) {
+	Convey("Given a webhook server", t, func() {
+		var received Payload
+		status := http.StatusOK
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_ = json.NewDecoder(req.Body).Decode(&received)
+			rw.WriteHeader(status)
+		}))
+		defer server.Close()
+
+		Convey("The payload is posted as JSON", func() {
+			hook := HTTPHook{URL: server.URL + "/build/secret-token"}
+			So(hook.Fire(context.Background(), NewPayload("example.com", testStats())), ShouldBeNil)
+			So(received.Domain, ShouldEqual, "example.com")
+			So(received.New, ShouldEqual, 2)
+		})
+
+		Convey("A non-2xx response is an error", func() {
+			status = http.StatusInternalServerError
+			err := HTTPHook{URL: server.URL}.Fire(context.Background(), Payload{})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "500")
+		})
+
+		Convey("The URL is kept out of errors and the hook's name", func() {
+			hook := HTTPHook{URL: "http://127.0.0.1:1/build/secret-token"}
+			err := hook.Fire(context.Background(), Payload{})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldNotContainSubstring, "secret-token")
+			So(hook.String(), ShouldEqual, "http:127.0.0.1:1")
+		})
+	})
+}
+
+func TestFromConfig(t *testing.T) {
+	Convey("Commands come before URLs", t, func() {
+		hooks := FromConfig([]string{"make"}, []string{"https://example.com/hook"})
+		So(hooks, ShouldHaveLength, 2)
+		So(hooks[0], ShouldHaveSameTypeAs, ExecHook{})
+		So(hooks[1], ShouldHaveSameTypeAs, HTTPHook{})
+		So(FromConfig(nil, nil), ShouldBeEmpty)
+	})
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"github.com/blbecker/webmentionR/cmd/authors"
 	"github.com/blbecker/webmentionR/cmd/fetch"
+	"github.com/blbecker/webmentionR/cmd/private"
+	"github.com/blbecker/webmentionR/cmd/review"
+	"github.com/blbecker/webmentionR/cmd/state"
+	"github.com/blbecker/webmentionR/cmd/watch"
 	"github.com/urfave/cli/v2"
 	"os"
 
@@ -11,7 +16,12 @@ import (
 func main() {
 	app := &cli.App{
 		Commands: []*cli.Command{
+			&authors.Command,
 			&fetch.Command,
+			&private.Command,
+			&review.Command,
+			&state.Command,
+			&watch.Command,
 		},
 	}
 
@@ -0,0 +1,381 @@
+package mastodon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+	"github.com/charmbracelet/log"
+	"gopkg.in/yaml.v3"
+)
+
+//=== Bindings for tests
+
+var ReadFileFunc = os.ReadFile
+
+//=== Bindings for tests
+
+// Protocol is the wm-protocol of the mentions converted from Mastodon.
+const Protocol = "mastodon"
+
+// PageLimit is the number of accounts requested per page, the most Mastodon returns.
+const PageLimit = 80
+
+// Account is the part of a Mastodon account a mention needs.
+type Account struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	Acct        string `json:"acct"`
+	DisplayName string `json:"display_name"`
+	URL         string `json:"url"`
+	Avatar      string `json:"avatar"`
+}
+
+// Status is the part of a Mastodon status a mention needs.
+type Status struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	URI         string    `json:"uri"`
+	CreatedAt   time.Time `json:"created_at"`
+	InReplyToID string    `json:"in_reply_to_id"`
+	Visibility  string    `json:"visibility"`
+	Content     string    `json:"content"`
+	Account     Account   `json:"account"`
+}
+
+type statusContext struct {
+	Descendants []Status `json:"descendants"`
+}
+
+// statusPath matches the status URLs of Mastodon and compatible servers: /@name/id, /users/name/statuses/id,
+// /web/statuses/id and Pleroma's /notice/id.
+var statusPath = regexp.MustCompile(`^/(?:@[^/]+|users/[^/]+/statuses|web/statuses|notice)/([0-9A-Za-z]+)/?$`)
+
+// ParseStatusURL splits the URL of a status into the base URL of its server's API and its ID.
+func ParseStatusURL(statusURL string) (string, string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(statusURL))
+	if err != nil || parsed.Host == "" {
+		return "", "", fmt.Errorf("invalid status URL '%s'", statusURL)
+	}
+	match := statusPath.FindStringSubmatch(parsed.Path)
+	if match == nil {
+		return "", "", fmt.Errorf("invalid status URL '%s', expected e.g. https://mastodon.example/@name/123", statusURL)
+	}
+	return parsed.Scheme + "://" + parsed.Host, match[1], nil
+}
+
+// SyntheticID returns the stable wm-id of the mention identified by key. Synthetic IDs are negative, so they never
+// collide with webmention.io's and never advance the fetch cursor, and fit in 53 bits for JavaScript.
+func SyntheticID(key string) int {
+	sum := sha256.Sum256([]byte(key))
+	return -int(binary.BigEndian.Uint64(sum[:8])&(1<<52-1)) - 1
+}
+
+// Statuses maps the URL of each post to the URLs of the statuses it was syndicated to.
+type Statuses map[string][]string
+
+// statusList accepts a single status URL as well as a list.
+type statusList []string
+
+func (l *statusList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = statusList{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// LoadStatuses reads the YAML file at path mapping post URLs to a status URL or a list of them.
+func LoadStatuses(path string) (Statuses, error) {
+	data, err := ReadFileFunc(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read Mastodon statuses: %w", err)
+	}
+	var file map[string]statusList
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("cannot parse Mastodon statuses: %w", err)
+	}
+	statuses := Statuses{}
+	for target, list := range file {
+		for _, status := range list {
+			if _, _, err := ParseStatusURL(status); err != nil {
+				return nil, fmt.Errorf("post %s: %w", target, err)
+			}
+		}
+		statuses[target] = list
+	}
+	return statuses, nil
+}
+
+// Source is a webmention.Getter converting the favourites, boosts and replies of syndicated statuses into mentions
+// of their posts, one page per status. Replies include the whole thread below the status.
+type Source struct {
+	Statuses Statuses
+	// Token, when set, authenticates the API requests to Instance, which private replies and some servers require.
+	// It is never sent to any other server.
+	Token string
+	// Instance is the base URL of the server Token belongs to, e.g. https://mastodon.social.
+	Instance string
+	// Context cancels the API requests, context.Background() when nil.
+	Context context.Context
+	// HTTPClient sends the API requests, with a 30 second timeout when nil.
+	HTTPClient *http.Client
+	// Seen maps status URLs to the wm-ids of the interactions imported from them before. Those are skipped, so
+	// mentions already stored, or held and then approved or rejected, aren't imported again. See Imported.
+	Seen map[string][]int
+
+	// pending are the target and status URL pairs not fetched yet.
+	pending [][2]string
+	started bool
+	// imported is what Imported returns.
+	imported map[string][]int
+}
+
+// start queues every status of Statuses and carries over what was seen of them before.
+func (s *Source) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.imported = map[string][]int{}
+	for target, statuses := range s.Statuses {
+		for _, status := range statuses {
+			s.pending = append(s.pending, [2]string{target, status})
+			if seen, ok := s.Seen[status]; ok {
+				s.imported[status] = seen
+			}
+		}
+	}
+	sort.Slice(s.pending, func(i, j int) bool {
+		return s.pending[i][0]+" "+s.pending[i][1] < s.pending[j][0]+" "+s.pending[j][1]
+	})
+}
+
+// Imported returns the Seen of the next run: the wm-ids of the interactions found on the statuses fetched so far,
+// and the Seen wm-ids of the others. Statuses no longer in Statuses are left out.
+func (s *Source) Imported() map[string][]int {
+	s.start()
+	return maps.Clone(s.imported)
+}
+
+// GetMentions returns the mentions of the next status with any not seen before, and an empty response once every
+// status was fetched. Statuses that no longer exist are skipped.
+func (s *Source) GetMentions() (*webmention.Response, error) {
+	s.start()
+	for len(s.pending) > 0 {
+		target, status := s.pending[0][0], s.pending[0][1]
+		s.pending = s.pending[1:]
+		mentions, err := s.statusMentions(target, status)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && (statusErr.Code == http.StatusNotFound || statusErr.Code == http.StatusGone) {
+			log.Warn("Skipping missing Mastodon status", "status", status, "target", target)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error fetching Mastodon status %s: %w", status, err)
+		}
+		seen := s.Seen[status]
+		found := make([]int, len(mentions))
+		for i, mention := range mentions {
+			found[i] = mention.WMID
+		}
+		s.imported[status] = found
+		mentions = slices.DeleteFunc(mentions, func(mention webmention.Mention) bool {
+			return slices.Contains(seen, mention.WMID)
+		})
+		if len(mentions) > 0 {
+			return &webmention.Response{Type: "feed", Name: "Mastodon", Children: mentions}, nil
+		}
+	}
+	return &webmention.Response{Type: "feed", Name: "Mastodon", Children: []webmention.Mention{}}, nil
+}
+
+// statusMentions converts the interactions with status into mentions of target.
+func (s *Source) statusMentions(target, statusURL string) ([]webmention.Mention, error) {
+	base, id, err := ParseStatusURL(statusURL)
+	if err != nil {
+		return nil, err
+	}
+	statusAPI := base + "/api/v1/statuses/" + url.PathEscape(id)
+
+	var mentions []webmention.Mention
+	for _, interaction := range []struct{ path, property, fragment string }{
+		{"/favourited_by", "like-of", "favorited-by"},
+		{"/reblogged_by", "repost-of", "reblogged-by"},
+	} {
+		accounts, err := s.accounts(statusAPI + interaction.path)
+		if err != nil {
+			return nil, err
+		}
+		for _, account := range accounts {
+			source := statusURL + "#" + interaction.fragment + "-" + account.ID
+			mentions = append(mentions, webmention.Mention{
+				Type:       "entry",
+				Author:     author(account),
+				URL:        source,
+				WMID:       SyntheticID(interaction.property + " " + source),
+				WMSource:   source,
+				WMTarget:   target,
+				WMProtocol: Protocol,
+				WMProperty: interaction.property,
+			})
+		}
+	}
+
+	var thread statusContext
+	if _, err := s.get(statusAPI+"/context", &thread); err != nil {
+		return nil, err
+	}
+	urls := map[string]string{id: target}
+	for _, reply := range thread.Descendants {
+		urls[reply.ID] = statusURLOf(reply)
+	}
+	for _, reply := range thread.Descendants {
+		// Direct replies answer the post; replies to replies answer their parent, so threads can be rebuilt.
+		inReplyTo, ok := urls[reply.InReplyToID]
+		if !ok {
+			inReplyTo = target
+		}
+		content := webmention.Content{HTML: reply.Content,
+			Text: webmention.NormalizeText(webmention.HTMLText(reply.Content))}
+		mentions = append(mentions, webmention.Mention{
+			Type:       "entry",
+			Author:     author(reply.Account),
+			URL:        statusURLOf(reply),
+			Published:  reply.CreatedAt,
+			WMReceived: reply.CreatedAt,
+			WMID:       SyntheticID("in-reply-to " + statusURLOf(reply)),
+			WMSource:   statusURLOf(reply),
+			WMTarget:   target,
+			WMProtocol: Protocol,
+			Content:    content,
+			InReplyTo:  inReplyTo,
+			WMProperty: "in-reply-to",
+			WMPrivate:  reply.Visibility == "private" || reply.Visibility == "direct",
+		})
+	}
+	return mentions, nil
+}
+
+func author(account Account) webmention.Author {
+	name := account.DisplayName
+	if name == "" {
+		name = account.Username
+	}
+	return webmention.Author{Type: "card", Name: name, Photo: account.Avatar, URL: account.URL}
+}
+
+func statusURLOf(status Status) string {
+	if status.URL != "" {
+		return status.URL
+	}
+	return status.URI
+}
+
+// accounts reads every page of the accounts at endpoint, following the Link headers as long as they stay on the
+// server of endpoint.
+func (s *Source) accounts(endpoint string) ([]Account, error) {
+	var all []Account
+	next := endpoint + "?limit=" + fmt.Sprint(PageLimit)
+	for next != "" {
+		var page []Account
+		current := next
+		var err error
+		if next, err = s.get(current, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if len(page) == 0 {
+			break
+		}
+		if next != "" && !sameOrigin(current, next) {
+			log.Warn("Not following Mastodon page link to another server", "endpoint", endpoint, "next", next)
+			break
+		}
+	}
+	return all, nil
+}
+
+// sameOrigin tells whether both URLs have the same scheme and host.
+func sameOrigin(a, b string) bool {
+	parsedA, errA := url.Parse(a)
+	parsedB, errB := url.Parse(b)
+	return errA == nil && errB == nil && parsedA.Host != "" &&
+		strings.EqualFold(parsedA.Scheme, parsedB.Scheme) && strings.EqualFold(parsedA.Host, parsedB.Host)
+}
+
+// StatusError is returned for API responses other than 2xx.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return "unexpected response: " + e.Status
+}
+
+// get decodes the JSON response of endpoint into into and returns the URL of its next page, if any.
+func (s *Source) get(endpoint string, into any) (string, error) {
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Accept", "application/json")
+	if s.Token != "" && sameOrigin(s.Instance, endpoint) {
+		request.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	client := s.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return "", &StatusError{Code: response.StatusCode, Status: response.Status}
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response: %w", err)
+	}
+	if err := json.Unmarshal(body, into); err != nil {
+		return "", fmt.Errorf("error unmarshalling JSON: %w", err)
+	}
+	return nextLink(response.Header.Get("Link")), nil
+}
+
+// nextLink returns the rel="next" URL of a Link header.
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+		if ok && strings.Contains(params, `rel="next"`) {
+			return strings.Trim(strings.TrimSpace(target), "<>")
+		}
+	}
+	return ""
+}
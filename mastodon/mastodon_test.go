@@ -0,0 +1,266 @@
+package mastodon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// standIn serves a Mastodon API with one status, 1, having two favourites over two pages, a boost and a thread of
+// two replies. Status 404 doesn't exist.
+func standIn(authorizations *[]string) *httptest.Server {
+	var server *httptest.Server
+	account := func(id, name string) Account {
+		return Account{ID: id, Username: name, DisplayName: name + " Example", URL: server.URL + "/@" + name,
+			Avatar: server.URL + "/avatars/" + name + ".png"}
+	}
+	reply := func(id, inReplyTo, name, content, visibility string) Status {
+		return Status{ID: id, URL: server.URL + "/@" + name + "/" + id, InReplyToID: inReplyTo, Visibility: visibility,
+			CreatedAt: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), Content: content, Account: account(id+"0", name)}
+	}
+	mux := http.NewServeMux()
+	write := func(w http.ResponseWriter, r *http.Request, value any) {
+		*authorizations = append(*authorizations, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(value)
+	}
+	mux.HandleFunc("/api/v1/statuses/1/favourited_by", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("max_id") {
+		case "":
+			w.Header().Set("Link", `<`+server.URL+`/api/v1/statuses/1/favourited_by?max_id=5>; rel="next", <`+
+				server.URL+`/api/v1/statuses/1/favourited_by?since_id=9>; rel="prev"`)
+			write(w, r, []Account{account("9", "ada")})
+		case "5":
+			w.Header().Set("Link", `<`+server.URL+`/api/v1/statuses/1/favourited_by?max_id=1>; rel="next"`)
+			write(w, r, []Account{account("5", "grace")})
+		default:
+			write(w, r, []Account{})
+		}
+	})
+	mux.HandleFunc("/api/v1/statuses/1/reblogged_by", func(w http.ResponseWriter, r *http.Request) {
+		write(w, r, []Account{account("7", "linus")})
+	})
+	mux.HandleFunc("/api/v1/statuses/1/context", func(w http.ResponseWriter, r *http.Request) {
+		write(w, r, statusContext{Descendants: []Status{
+			reply("2", "1", "ada", "<p>Great  post!</p>", "public"),
+			reply("3", "2", "grace", "<p>Agreed</p>", "private"),
+		}})
+	})
+	server = httptest.NewServer(mux)
+	return server
+}
+
+func TestParseStatusURL(t *testing.T) {
+	Convey("Status URLs are split into the API base and the status ID", t, func() {
+		for _, statusURL := range []string{"https://mastodon.example/@ada/123", "https://mastodon.example/users/ada/statuses/123",
+			"https://mastodon.example/web/statuses/123/"} {
+			base, id, err := ParseStatusURL(statusURL)
+			So(err, ShouldBeNil)
+			So(base, ShouldEqual, "https://mastodon.example")
+			So(id, ShouldEqual, "123")
+		}
+		_, id, err := ParseStatusURL("https://pleroma.example/notice/AbC9")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "AbC9")
+
+		_, _, err = ParseStatusURL("https://blog.example/posts/hello")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSyntheticID(t *testing.T) {
+	Convey("Synthetic IDs are stable, negative and safe in JavaScript", t, func() {
+		id := SyntheticID("like-of https://mastodon.example/@ada/1#favorited-by-9")
+		So(id, ShouldEqual, SyntheticID("like-of https://mastodon.example/@ada/1#favorited-by-9"))
+		So(id, ShouldBeLessThan, 0)
+		So(id, ShouldBeGreaterThanOrEqualTo, -(1 << 52))
+		So(SyntheticID("repost-of https://mastodon.example/@ada/1#favorited-by-9"), ShouldNotEqual, id)
+	})
+}
+
+func TestLoadStatuses(t *testing.T) {
+	Convey("The mapping file takes a status or a list of them per post", t, func() {
+		ReadFileFunc = os.ReadFile
+		path := filepath.Join(t.TempDir(), "statuses.yaml")
+		So(os.WriteFile(path, []byte("https://blog.example/a: https://mastodon.example/@ada/1\n"+
+			"https://blog.example/b:\n  - https://mastodon.example/@ada/2\n  - https://other.example/@ada/3\n"), 0644), ShouldBeNil)
+		statuses, err := LoadStatuses(path)
+		So(err, ShouldBeNil)
+		So(statuses, ShouldResemble, Statuses{
+			"https://blog.example/a": {"https://mastodon.example/@ada/1"},
+			"https://blog.example/b": {"https://mastodon.example/@ada/2", "https://other.example/@ada/3"},
+		})
+
+		So(os.WriteFile(path, []byte("https://blog.example/a: https://blog.example/a\n"), 0644), ShouldBeNil)
+		_, err = LoadStatuses(path)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSource(t *testing.T) {
+	Convey("Given a Mastodon API stand-in", t, func() {
+		var authorizations []string
+		server := standIn(&authorizations)
+		defer server.Close()
+		target := "https://blog.example/posts/hello"
+		newSource := func() *Source {
+			return &Source{Token: "secret", Instance: server.URL, Statuses: Statuses{
+				target:                      {server.URL + "/@owner/1"},
+				"https://blog.example/gone": {server.URL + "/@owner/404"},
+			}}
+		}
+
+		Convey("Favourites, boosts and replies flow through a FetchWorker as mentions", func() {
+			mentionChan := make(chan webmention.Mention, 20)
+			worker := webmention.FetchWorker{}
+			So(worker.DoFetch(context.Background(), newSource(), mentionChan), ShouldBeNil)
+			var mentions []webmention.Mention
+			for mention := range mentionChan {
+				mentions = append(mentions, mention)
+			}
+			So(mentions, ShouldHaveLength, 5)
+			So(authorizations, ShouldNotBeEmpty)
+			So(authorizations[0], ShouldEqual, "Bearer secret")
+
+			byProperty := map[string]int{}
+			for _, mention := range mentions {
+				byProperty[mention.WMProperty]++
+				So(mention.WMID, ShouldBeLessThan, 0)
+				So(mention.WMTarget, ShouldEqual, target)
+				So(mention.WMProtocol, ShouldEqual, Protocol)
+				So(webmention.ClassifyOrigin(mention).Network, ShouldEqual, webmention.NetworkMastodon)
+			}
+			So(byProperty, ShouldResemble, map[string]int{"like-of": 2, "repost-of": 1, "in-reply-to": 2})
+
+			like := mentions[0]
+			So(like.WMSource, ShouldEqual, server.URL+"/@owner/1#favorited-by-9")
+			So(like.Author, ShouldResemble, webmention.Author{Type: "card", Name: "ada Example",
+				Photo: server.URL + "/avatars/ada.png", URL: server.URL + "/@ada"})
+
+			reply, nested := mentions[3], mentions[4]
+			So(reply.URL, ShouldEqual, server.URL+"/@ada/2")
+			So(reply.InReplyTo, ShouldEqual, target)
+			So(reply.Content.Text, ShouldEqual, "Great post!")
+			So(reply.Published, ShouldEqual, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+			So(reply.WMPrivate, ShouldBeFalse)
+			So(nested.InReplyTo, ShouldEqual, reply.URL)
+			So(nested.WMPrivate, ShouldBeTrue)
+
+			Convey("and get the same IDs in every run", func() {
+				again := make(chan webmention.Mention, 20)
+				So(worker.DoFetch(context.Background(), newSource(), again), ShouldBeNil)
+				i := 0
+				for mention := range again {
+					So(mention.WMID, ShouldEqual, mentions[i].WMID)
+					i++
+				}
+			})
+		})
+
+		Convey("Interactions seen in an earlier run are skipped", func() {
+			first := newSource()
+			mentionChan := make(chan webmention.Mention, 20)
+			So((&webmention.FetchWorker{}).DoFetch(context.Background(), first, mentionChan), ShouldBeNil)
+			var wmIDs []int
+			for mention := range mentionChan {
+				wmIDs = append(wmIDs, mention.WMID)
+			}
+			imported := first.Imported()
+			So(imported, ShouldHaveLength, 1)
+			So(imported[server.URL+"/@owner/1"], ShouldResemble, wmIDs)
+
+			// One interaction is left out of the seen set, as if it had been undone and done again.
+			second := newSource()
+			second.Seen = map[string][]int{server.URL + "/@owner/1": wmIDs[1:], server.URL + "/@owner/unmapped": {-1}}
+			again := make(chan webmention.Mention, 20)
+			So((&webmention.FetchWorker{}).DoFetch(context.Background(), second, again), ShouldBeNil)
+			var imports []webmention.Mention
+			for mention := range again {
+				imports = append(imports, mention)
+			}
+			So(imports, ShouldHaveLength, 1)
+			So(imports[0].WMID, ShouldEqual, wmIDs[0])
+			So(second.Imported(), ShouldResemble, imported)
+		})
+
+		Convey("The token is only sent to its instance", func() {
+			source := newSource()
+			source.Instance = "https://mastodon.example"
+			_, err := source.GetMentions()
+			So(err, ShouldBeNil)
+			So(authorizations, ShouldNotBeEmpty)
+			for _, authorization := range authorizations {
+				So(authorization, ShouldBeEmpty)
+			}
+		})
+
+		Convey("Page links to another server are not followed", func() {
+			var elsewhere []string
+			other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				elsewhere = append(elsewhere, r.Header.Get("Authorization"))
+				_, _ = w.Write([]byte(`[{"id": "6", "username": "mallory"}]`))
+			}))
+			defer other.Close()
+			redirecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/v1/statuses/1/favourited_by":
+					w.Header().Set("Link", `<`+other.URL+`/api/v1/statuses/1/favourited_by?max_id=5>; rel="next"`)
+					_, _ = w.Write([]byte(`[{"id": "9", "username": "ada"}]`))
+				case "/api/v1/statuses/1/context":
+					_, _ = w.Write([]byte(`{"descendants": []}`))
+				default:
+					_, _ = w.Write([]byte(`[]`))
+				}
+			}))
+			defer redirecting.Close()
+			source := &Source{Token: "secret", Instance: redirecting.URL,
+				Statuses: Statuses{target: {redirecting.URL + "/@owner/1"}}}
+			response, err := source.GetMentions()
+			So(err, ShouldBeNil)
+			So(response.Children, ShouldHaveLength, 1)
+			So(elsewhere, ShouldBeEmpty)
+		})
+
+		Convey("Cancelling the context stops the requests", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			source := newSource()
+			source.Context = ctx
+			_, err := source.GetMentions()
+			So(err, ShouldNotBeNil)
+			So(authorizations, ShouldBeEmpty)
+		})
+
+		Convey("Server errors other than missing statuses fail the fetch", func() {
+			source := &Source{Statuses: Statuses{target: {server.URL + "/@owner/1"}},
+				HTTPClient: &http.Client{Transport: failingTransport{}}, Seen: map[string][]int{server.URL + "/@owner/1": {-7}}}
+			_, err := source.GetMentions()
+			So(err, ShouldNotBeNil)
+			So(source.Imported(), ShouldResemble, map[string][]int{server.URL + "/@owner/1": {-7}})
+		})
+	})
+}
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable",
+		Body: http.NoBody, Header: http.Header{}}, nil
+}
+
+func TestNextLink(t *testing.T) {
+	Convey("The next page is taken from the Link header", t, func() {
+		So(nextLink(`<https://m.example/a?max_id=1>; rel="next", <https://m.example/a?since_id=2>; rel="prev"`),
+			ShouldEqual, "https://m.example/a?max_id=1")
+		So(nextLink(`<https://m.example/a?since_id=2>; rel="prev"`), ShouldBeEmpty)
+		So(nextLink(""), ShouldBeEmpty)
+	})
+}
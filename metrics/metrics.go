@@ -0,0 +1,281 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+	"github.com/charmbracelet/log"
+)
+
+// ContentType is the media type of the Prometheus text exposition format written by Registry.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// RequestBuckets are the upper bounds, in seconds, of the API request latency histogram.
+var RequestBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Registry collects the exporter's metrics across every domain of a run. It implements
+// webmention.RequestObserver, and MentionObserver returns the webmention.MentionObserver for one domain.
+type Registry struct {
+	mu               sync.Mutex
+	mentions         *family
+	requests         *family
+	requestErrors    *family
+	requestDurations map[string]*histogram
+	runs             *family
+	lastSuccess      *family
+	lastRun          *family
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		mentions: newFamily("webmentionr_mentions_fetched_total", "counter",
+			"Mentions received from the webmention.io API."),
+		requests: newFamily("webmentionr_api_requests_total", "counter",
+			"Requests sent to the webmention.io API."),
+		requestErrors: newFamily("webmentionr_api_request_errors_total", "counter",
+			"Requests to the webmention.io API that failed."),
+		requestDurations: map[string]*histogram{},
+		runs: newFamily("webmentionr_runs_total", "counter",
+			"Fetch runs by outcome."),
+		lastSuccess: newFamily("webmentionr_last_success_timestamp_seconds", "gauge",
+			"Unix time the last successful run finished."),
+		lastRun: newFamily("webmentionr_last_run_timestamp_seconds", "gauge",
+			"Unix time the last run finished, successful or not."),
+	}
+}
+
+// ObserveRequest records one API request of domain.
+func (r *Registry) ObserveRequest(domain string, duration time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	labels := []label{{"domain", domain}}
+	r.requests.add(labels, 1)
+	if err != nil {
+		r.requestErrors.add(labels, 1)
+	}
+	h, ok := r.requestDurations[domain]
+	if !ok {
+		h = newHistogram(RequestBuckets)
+		r.requestDurations[domain] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// RecordRun records the outcome of a run of domain that finished at finished.
+func (r *Registry) RecordRun(domain string, finished time.Time, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	} else {
+		r.lastSuccess.set([]label{{"domain", domain}}, unixSeconds(finished))
+	}
+	r.runs.add([]label{{"domain", domain}, {"result", result}}, 1)
+	r.lastRun.set([]label{{"domain", domain}}, unixSeconds(finished))
+}
+
+// MentionObserver returns an observer counting the mentions fetched for domain by wm-property, target and source
+// host. Private mentions aren't counted, so their targets never become labels.
+func (r *Registry) MentionObserver(domain string) webmention.MentionObserver {
+	return &mentionObserver{registry: r, domain: domain}
+}
+
+type mentionObserver struct {
+	registry *Registry
+	domain   string
+}
+
+func (o *mentionObserver) Update(mention webmention.Mention) {
+	if mention.WMPrivate {
+		return
+	}
+	o.registry.mu.Lock()
+	defer o.registry.mu.Unlock()
+
+	o.registry.mentions.add([]label{
+		{"domain", o.domain},
+		{"property", mention.WMProperty},
+		{"target", mention.WMTarget},
+		{"source_host", sourceHost(mention.WMSource)},
+	}, 1)
+}
+
+func sourceHost(source string) string {
+	parsed, err := url.Parse(source)
+	if err != nil || parsed.Host == "" {
+		return "unknown"
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, r.render())
+	return int64(n), err
+}
+
+func (r *Registry) render() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var out strings.Builder
+	r.mentions.write(&out)
+	r.requests.write(&out)
+	r.requestErrors.write(&out)
+	r.writeDurations(&out)
+	r.runs.write(&out)
+	r.lastSuccess.write(&out)
+	r.lastRun.write(&out)
+	return out.String()
+}
+
+func (r *Registry) writeDurations(out *strings.Builder) {
+	if len(r.requestDurations) == 0 {
+		return
+	}
+	const name = "webmentionr_api_request_duration_seconds"
+	fmt.Fprintf(out, "# HELP %s Latency of requests to the webmention.io API.\n# TYPE %s histogram\n", name, name)
+
+	domains := make([]string, 0, len(r.requestDurations))
+	for domain := range r.requestDurations {
+		domains = append(domains, domain)
+	}
+	slices.Sort(domains)
+	for _, domain := range domains {
+		h := r.requestDurations[domain]
+		for i, bound := range h.bounds {
+			fmt.Fprintf(out, "%s_bucket%s %d\n", name,
+				formatLabels([]label{{"domain", domain}, {"le", formatFloat(bound)}}), h.counts[i])
+		}
+		fmt.Fprintf(out, "%s_bucket%s %d\n", name, formatLabels([]label{{"domain", domain}, {"le", "+Inf"}}), h.count)
+		fmt.Fprintf(out, "%s_sum%s %s\n", name, formatLabels([]label{{"domain", domain}}), formatFloat(h.sum))
+		fmt.Fprintf(out, "%s_count%s %d\n", name, formatLabels([]label{{"domain", domain}}), h.count)
+	}
+}
+
+// Handler serves the metrics for scraping.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", ContentType)
+		if _, err := r.WriteTo(rw); err != nil {
+			log.Error("Cannot write metrics", "err", err)
+		}
+	})
+}
+
+// WriteTextfile writes the metrics to path for the node_exporter textfile collector. The file is written next to
+// path first and renamed into place, so the collector never reads a partial file.
+func (r *Registry) WriteTextfile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot create metrics file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := r.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write metrics file: %w", err)
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write metrics file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write metrics file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("cannot move metrics file into place: %w", err)
+	}
+	return nil
+}
+
+type label struct {
+	name, value string
+}
+
+// family is a counter or gauge with one value per label set.
+type family struct {
+	name, kind, help string
+	values           map[string]float64
+}
+
+func newFamily(name, kind, help string) *family {
+	return &family{name: name, kind: kind, help: help, values: map[string]float64{}}
+}
+
+func (f *family) add(labels []label, delta float64) {
+	f.values[formatLabels(labels)] += delta
+}
+
+func (f *family) set(labels []label, value float64) {
+	f.values[formatLabels(labels)] = value
+}
+
+func (f *family) write(out *strings.Builder) {
+	if len(f.values) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+	keys := make([]string, 0, len(f.values))
+	for key := range f.values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		fmt.Fprintf(out, "%s%s %s\n", f.name, key, formatFloat(f.values[key]))
+	}
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+// observe adds value to every bucket whose bound it doesn't exceed, as Prometheus buckets are cumulative.
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []label) string {
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = l.name + `="` + labelEscaper.Replace(l.value) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixMilli()) / 1000
+}
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func render(registry *Registry) string {
+	var out strings.Builder
+	_, err := registry.WriteTo(&out)
+	So(err, ShouldBeNil)
+	return out.String()
+}
+
+func TestRegistry(t *testing.T) {
+	Convey("Given a registry", t, func() {
+		registry := NewRegistry()
+
+		Convey("An empty registry writes nothing", func() {
+			So(render(registry), ShouldBeEmpty)
+		})
+
+		Convey("Mentions are counted by property, target and source host", func() {
+			observer := registry.MentionObserver("example.com")
+			mention := webmention.Mention{WMProperty: "like-of", WMTarget: "https://example.com/post",
+				WMSource: "https://Brid.gy/like/1"}
+			observer.Update(mention)
+			observer.Update(mention)
+			observer.Update(webmention.Mention{WMProperty: "in-reply-to", WMTarget: "https://example.com/\"quoted\""})
+
+			out := render(registry)
+			So(out, ShouldContainSubstring, "# TYPE webmentionr_mentions_fetched_total counter\n")
+			So(out, ShouldContainSubstring, `webmentionr_mentions_fetched_total{domain="example.com",property="like-of",`+
+				`target="https://example.com/post",source_host="brid.gy"} 2`+"\n")
+			So(out, ShouldContainSubstring, `target="https://example.com/\"quoted\"",source_host="unknown"} 1`)
+		})
+
+		Convey("Private mentions are not counted", func() {
+			observer := registry.MentionObserver("example.com")
+			observer.Update(webmention.Mention{WMProperty: "in-reply-to", WMTarget: "https://example.com/secret",
+				WMPrivate: true})
+			So(render(registry), ShouldNotContainSubstring, "secret")
+		})
+
+		Convey("Requests are counted and their latency bucketed", func() {
+			registry.ObserveRequest("example.com", 200*time.Millisecond, nil)
+			registry.ObserveRequest("example.com", 3*time.Second, errors.New("timeout"))
+
+			out := render(registry)
+			So(out, ShouldContainSubstring, `webmentionr_api_requests_total{domain="example.com"} 2`)
+			So(out, ShouldContainSubstring, `webmentionr_api_request_errors_total{domain="example.com"} 1`)
+			So(out, ShouldContainSubstring, "# TYPE webmentionr_api_request_duration_seconds histogram\n")
+			So(out, ShouldContainSubstring, `webmentionr_api_request_duration_seconds_bucket{domain="example.com",le="0.1"} 0`)
+			So(out, ShouldContainSubstring, `webmentionr_api_request_duration_seconds_bucket{domain="example.com",le="0.25"} 1`)
+			So(out, ShouldContainSubstring, `webmentionr_api_request_duration_seconds_bucket{domain="example.com",le="5"} 2`)
+			So(out, ShouldContainSubstring, `webmentionr_api_request_duration_seconds_bucket{domain="example.com",le="+Inf"} 2`)
+			So(out, ShouldContainSubstring, `webmentionr_api_request_duration_seconds_sum{domain="example.com"} 3.2`)
+			So(out, ShouldContainSubstring, `webmentionr_api_request_duration_seconds_count{domain="example.com"} 2`)
+		})
+
+		Convey("Runs record their outcome and the last success", func() {
+			finished := time.Unix(1700000000, 500*int64(time.Millisecond))
+			registry.RecordRun("example.com", finished, nil)
+			registry.RecordRun("example.com", finished.Add(time.Minute), errors.New("unauthorized"))
+
+			out := render(registry)
+			So(out, ShouldContainSubstring, `webmentionr_runs_total{domain="example.com",result="success"} 1`)
+			So(out, ShouldContainSubstring, `webmentionr_runs_total{domain="example.com",result="failure"} 1`)
+			So(out, ShouldContainSubstring, `webmentionr_last_success_timestamp_seconds{domain="example.com"} 1.7000000005e+09`)
+			So(out, ShouldContainSubstring, `webmentionr_last_run_timestamp_seconds{domain="example.com"} 1.7000000605e+09`)
+		})
+
+		Convey("The handler serves the text format", func() {
+			registry.RecordRun("example.com", time.Now(), nil)
+			recorder := httptest.NewRecorder()
+			registry.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			So(recorder.Header().Get("Content-Type"), ShouldEqual, ContentType)
+			So(recorder.Body.String(), ShouldContainSubstring, "webmentionr_runs_total")
+		})
+
+		Convey("The textfile is replaced in one step", func() {
+			registry.RecordRun("example.com", time.Now(), nil)
+			dir := t.TempDir()
+			path := filepath.Join(dir, "webmentionr.prom")
+			So(os.WriteFile(path, []byte("stale"), 0o644), ShouldBeNil)
+
+			So(registry.WriteTextfile(path), ShouldBeNil)
+			data, err := os.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, render(registry))
+
+			entries, err := os.ReadDir(dir)
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+		})
+
+		Convey("A textfile in a missing directory is an error", func() {
+			So(registry.WriteTextfile(filepath.Join(t.TempDir(), "missing", "webmentionr.prom")), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,92 @@
+package moderation
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/blbecker/webmentionR/webmention"
+)
+
+// BlocklistSuffix is appended to the destination directory to form the default path of its blocklist.
+const BlocklistSuffix = ".blocklist"
+
+// BlocklistPath returns the default blocklist path of destination.
+func BlocklistPath(destination string) string {
+	return filepath.Clean(destination) + BlocklistSuffix
+}
+
+// Sender identifies who sent mention: the author's URL when known, otherwise the host of wm-source.
+func Sender(mention webmention.Mention) string {
+	if author := normalizeURL(mention.Author.URL); author != "" {
+		return author
+	}
+	if parsed, err := url.Parse(mention.WMSource); err == nil {
+		return strings.ToLower(parsed.Hostname())
+	}
+	return ""
+}
+
+// LoadBlocklist reads the blocklist at path, one sender per line: an author URL, or a domain blocking every source
+// on it and its subdomains. Blank lines and lines starting with # are skipped, and a missing file is empty.
+func LoadBlocklist(path string) ([]string, error) {
+	data, err := ReadFileFunc(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read blocklist: %w", err)
+	}
+	var senders []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			senders = append(senders, line)
+		}
+	}
+	return senders, nil
+}
+
+// Block appends sender to the blocklist at path unless it is listed already.
+func Block(path, sender string) error {
+	senders, err := LoadBlocklist(path)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(senders, sender) {
+		return nil
+	}
+	file, err := OpenFileFunc(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open blocklist: %w", err)
+	}
+	if _, err := file.WriteString(sender + "\n"); err != nil {
+		file.Close()
+		return fmt.Errorf("cannot write blocklist: %w", err)
+	}
+	return file.Close()
+}
+
+// BlocklistRules returns the rules dropping mentions from senders.
+func BlocklistRules(senders []string) []Rule {
+	var domains, authors []string
+	for _, sender := range senders {
+		if strings.Contains(sender, "://") {
+			authors = append(authors, sender)
+		} else {
+			domains = append(domains, sender)
+		}
+	}
+	var rules []Rule
+	if len(domains) > 0 {
+		rules = append(rules, Rule{Name: "blocklist", SourceDomain: domains, Action: Drop})
+	}
+	if len(authors) > 0 {
+		rules = append(rules, Rule{Name: "blocklist", AuthorURL: authors, Action: Drop})
+	}
+	return rules
+}
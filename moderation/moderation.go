@@ -0,0 +1,296 @@
+package moderation
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+	"github.com/charmbracelet/log"
+	"gopkg.in/yaml.v3"
+)
+
+//=== Bindings for tests
+
+var ReadFileFunc = os.ReadFile
+
+var OpenFileFunc = os.OpenFile
+
+var WriteFileFunc = os.WriteFile
+
+//=== Bindings for tests
+
+// Action is what a matching rule does with a mention.
+type Action string
+
+const (
+	// Allow keeps the mention and skips the remaining rules, e.g. for trusted authors.
+	Allow Action = "allow"
+	// Drop discards the mention.
+	Drop Action = "drop"
+	// Hold keeps the mention out of the published files until it is reviewed.
+	Hold Action = "hold"
+	// Hide stores the mention marked hidden.
+	Hide Action = "hide"
+)
+
+// Rule matches mentions on every criterion it sets; a list criterion matches when any of its entries does.
+type Rule struct {
+	Name string `yaml:"name"`
+	// SourceDomain matches the host of wm-source and its subdomains.
+	SourceDomain []string `yaml:"source-domain"`
+	// AuthorURL matches the author's URL, ignoring case and a trailing slash.
+	AuthorURL []string `yaml:"author-url"`
+	// AuthorName matches the author's name, ignoring case.
+	AuthorName []string `yaml:"author-name"`
+	// Content is a regular expression matched against the content text and name.
+	Content  string   `yaml:"content"`
+	Property []string `yaml:"property"`
+	// LinksOnly matches mentions whose text is nothing but links.
+	LinksOnly bool `yaml:"links-only"`
+	// FirstTime matches mentions from senders without a mention in the destination yet.
+	FirstTime bool   `yaml:"first-time"`
+	Action    Action `yaml:"action"`
+
+	content *regexp.Regexp
+}
+
+// File is the on-disk rules file.
+type File struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Catch records one mention a rule caught, as written to the moderation log.
+type Catch struct {
+	Time   time.Time `json:"time"`
+	Rule   string    `json:"rule"`
+	Action Action    `json:"action"`
+	WMID   int       `json:"wm-id"`
+	Source string    `json:"wm-source"`
+	Target string    `json:"wm-target"`
+	Author string    `json:"author,omitempty"`
+}
+
+// Moderator applies its rules in order, the first matching rule deciding. It is a webmention.Stage.
+type Moderator struct {
+	Rules []Rule
+	// LogPath, when set, is appended a JSON line for every caught mention.
+	LogPath string
+	// Known holds the senders of the stored mentions, as returned by Sender, for the first-time criterion.
+	Known map[string]bool
+
+	mu     sync.Mutex
+	caught map[string]int
+}
+
+// Load reads and validates the rules file at path.
+func Load(path string) (*Moderator, error) {
+	data, err := ReadFileFunc(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read rules file: %w", err)
+	}
+	var file File
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("cannot parse rules file: %w", err)
+	}
+	return New(file.Rules)
+}
+
+// New validates rules and returns a Moderator applying them.
+func New(rules []Rule) (*Moderator, error) {
+	for i := range rules {
+		if err := rules[i].compile(i); err != nil {
+			return nil, err
+		}
+	}
+	return &Moderator{Rules: rules}, nil
+}
+
+func (r *Rule) compile(index int) error {
+	if r.Name == "" {
+		r.Name = fmt.Sprintf("rule %d", index+1)
+	}
+	if !slices.Contains([]Action{Allow, Drop, Hold, Hide}, r.Action) {
+		return fmt.Errorf("%s: unknown action '%s', expected allow, drop, hold or hide", r.Name, r.Action)
+	}
+	if len(r.SourceDomain) == 0 && len(r.AuthorURL) == 0 && len(r.AuthorName) == 0 && r.Content == "" &&
+		len(r.Property) == 0 && !r.LinksOnly && !r.FirstTime {
+		return fmt.Errorf("%s: a rule needs at least one of source-domain, author-url, author-name, content, "+
+			"property, links-only or first-time", r.Name)
+	}
+	if r.Content != "" {
+		content, err := regexp.Compile(r.Content)
+		if err != nil {
+			return fmt.Errorf("%s: invalid content pattern: %w", r.Name, err)
+		}
+		r.content = content
+	}
+	return nil
+}
+
+// Matches reports whether every criterion of the rule matches mention. The first-time criterion is left to the
+// Moderator, which knows the stored mentions.
+func (r *Rule) Matches(mention webmention.Mention) bool {
+	if len(r.SourceDomain) > 0 && !slices.ContainsFunc(r.SourceDomain, hostMatcher(mention.WMSource)) {
+		return false
+	}
+	if len(r.AuthorURL) > 0 && !slices.ContainsFunc(r.AuthorURL, func(authorURL string) bool {
+		return normalizeURL(authorURL) == normalizeURL(mention.Author.URL)
+	}) {
+		return false
+	}
+	if len(r.AuthorName) > 0 && !slices.ContainsFunc(r.AuthorName, func(name string) bool {
+		return strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(mention.Author.Name))
+	}) {
+		return false
+	}
+	if r.content != nil && !r.content.MatchString(mention.Content.Text) && !r.content.MatchString(mention.Name) {
+		return false
+	}
+	if len(r.Property) > 0 && !slices.Contains(r.Property, mention.WMProperty) {
+		return false
+	}
+	if r.LinksOnly && !linksOnly(mention.Content.Text) {
+		return false
+	}
+	return true
+}
+
+var linkPattern = regexp.MustCompile(`https?://\S+`)
+
+// linksOnly reports whether text holds at least one link and nothing else but whitespace.
+func linksOnly(text string) bool {
+	return linkPattern.MatchString(text) && strings.TrimSpace(linkPattern.ReplaceAllString(text, "")) == ""
+}
+
+func hostMatcher(source string) func(domain string) bool {
+	parsed, err := url.Parse(source)
+	host := ""
+	if err == nil {
+		host = strings.ToLower(parsed.Hostname())
+	}
+	return func(domain string) bool {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "*."))
+		return host != "" && (host == domain || strings.HasSuffix(host, "."+domain))
+	}
+}
+
+func normalizeURL(rawURL string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(rawURL)), "/")
+}
+
+// Match returns the first rule matching mention, or nil.
+func (m *Moderator) Match(mention webmention.Mention) *Rule {
+	for i := range m.Rules {
+		rule := &m.Rules[i]
+		if rule.Matches(mention) && (!rule.FirstTime || !m.Known[Sender(mention)]) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// NeedsKnown reports whether a rule uses the first-time criterion, which needs Known.
+func (m *Moderator) NeedsKnown() bool {
+	return slices.ContainsFunc(m.Rules, func(rule Rule) bool { return rule.FirstTime })
+}
+
+// KnownSenders returns the senders of the mentions stored in destination.
+func KnownSenders(destination string) (map[string]bool, error) {
+	mentionsByPath, err := webmention.LoadAll(destination)
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, mentions := range mentionsByPath {
+		for _, mention := range mentions {
+			known[Sender(mention)] = true
+		}
+	}
+	return known, nil
+}
+
+// Process applies the first matching rule to mention.
+func (m *Moderator) Process(mention webmention.Mention) (webmention.Mention, webmention.Verdict) {
+	rule := m.Match(mention)
+	if rule == nil {
+		return mention, webmention.Keep
+	}
+	m.record(rule, mention)
+
+	switch rule.Action {
+	case Drop:
+		return mention, webmention.Drop
+	case Hold:
+		return mention, webmention.Hold
+	case Hide:
+		mention.Hidden = true
+	}
+	return mention, webmention.Keep
+}
+
+// Derives tells that the moderator decides whether every mention it keeps is hidden.
+func (m *Moderator) Derives() webmention.Derived {
+	return webmention.Derived{Hidden: true}
+}
+
+func (m *Moderator) record(rule *Rule, mention webmention.Mention) {
+	catch := Catch{
+		Time:   time.Now(),
+		Rule:   rule.Name,
+		Action: rule.Action,
+		WMID:   mention.WMID,
+		Source: mention.WMSource,
+		Target: mention.WMTarget,
+		Author: mention.Author.URL,
+	}
+	log.Info("Moderation rule matched", "rule", catch.Rule, "action", catch.Action, "WMID", catch.WMID,
+		"source", catch.Source)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.caught == nil {
+		m.caught = map[string]int{}
+	}
+	m.caught[rule.Name]++
+
+	if m.LogPath != "" {
+		if err := appendCatch(m.LogPath, catch); err != nil {
+			log.Error("Cannot write moderation log", "err", err)
+		}
+	}
+}
+
+func appendCatch(path string, catch Catch) error {
+	data, err := json.Marshal(catch)
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON: %w", err)
+	}
+	file, err := OpenFileFunc(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Caught returns how many mentions each rule caught so far, by rule name.
+func (m *Moderator) Caught() map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return maps.Clone(m.caught)
+}
+
+func (m *Moderator) String() string {
+	return "moderation"
+}
@@ -0,0 +1,141 @@
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const rulesFile = `
+rules:
+  - name: trusted friend
+    author-url: [https://friend.example/]
+    action: allow
+  - name: spam network
+    source-domain: [spam.example]
+    action: drop
+  - name: casino replies
+    content: "(?i)casino"
+    property: [in-reply-to]
+    action: hold
+  - author-name: [Troll]
+    action: hide
+`
+
+func TestLoad(t *testing.T) {
+	Convey("Given a rules file", t, func() {
+		ReadFileFunc = func(string) ([]byte, error) { return []byte(rulesFile), nil }
+
+		Convey("The rules are loaded in order and unnamed rules numbered", func() {
+			moderator, err := Load("rules.yaml")
+			So(err, ShouldBeNil)
+			So(moderator.Rules, ShouldHaveLength, 4)
+			So(moderator.Rules[1].Name, ShouldEqual, "spam network")
+			So(moderator.Rules[3].Name, ShouldEqual, "rule 4")
+		})
+
+		Convey("An unreadable file is an error", func() {
+			ReadFileFunc = func(string) ([]byte, error) { return nil, errors.New("permission denied") }
+			_, err := Load("rules.yaml")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Invalid rules are rejected", t, func() {
+		for _, rule := range []Rule{
+			{SourceDomain: []string{"spam.example"}, Action: "delete"},
+			{Action: Drop},
+			{Content: "(unclosed", Action: Drop},
+		} {
+			_, err := New([]Rule{rule})
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestModerator(t *testing.T) {
+	Convey("Given a moderator", t, func() {
+		ReadFileFunc = func(string) ([]byte, error) { return []byte(rulesFile), nil }
+		moderator, err := Load("rules.yaml")
+		So(err, ShouldBeNil)
+
+		Convey("Source domains match subdomains", func() {
+			_, verdict := moderator.Process(webmention.Mention{WMSource: "https://www.SPAM.example/post"})
+			So(verdict, ShouldEqual, webmention.Drop)
+			_, verdict = moderator.Process(webmention.Mention{WMSource: "https://notspam.example/post"})
+			So(verdict, ShouldEqual, webmention.Keep)
+		})
+
+		Convey("Every criterion of a rule must match", func() {
+			_, verdict := moderator.Process(webmention.Mention{WMProperty: "in-reply-to",
+				Content: webmention.Content{Text: "Visit my Casino"}})
+			So(verdict, ShouldEqual, webmention.Hold)
+			_, verdict = moderator.Process(webmention.Mention{WMProperty: "like-of",
+				Content: webmention.Content{Text: "Visit my Casino"}})
+			So(verdict, ShouldEqual, webmention.Keep)
+		})
+
+		Convey("Hidden mentions are kept and marked", func() {
+			mention, verdict := moderator.Process(webmention.Mention{Author: webmention.Author{Name: "troll "}})
+			So(verdict, ShouldEqual, webmention.Keep)
+			So(mention.Hidden, ShouldBeTrue)
+		})
+
+		Convey("The first matching rule decides", func() {
+			_, verdict := moderator.Process(webmention.Mention{WMSource: "https://spam.example/post",
+				Author: webmention.Author{URL: "https://FRIEND.example"}})
+			So(verdict, ShouldEqual, webmention.Keep)
+		})
+
+		Convey("Links-only and first-time rules hold unknown senders", func() {
+			moderator, err := New([]Rule{
+				{Name: "links", LinksOnly: true, Action: Hold},
+				{Name: "newcomers", FirstTime: true, Property: []string{"in-reply-to"}, Action: Hold},
+			})
+			So(err, ShouldBeNil)
+			moderator.Known = map[string]bool{"https://friend.example": true}
+
+			_, verdict := moderator.Process(webmention.Mention{Content: webmention.Content{
+				Text: " https://a.example/x\nhttp://b.example "}})
+			So(verdict, ShouldEqual, webmention.Hold)
+			_, verdict = moderator.Process(webmention.Mention{Content: webmention.Content{
+				Text: "Read https://a.example/x"}})
+			So(verdict, ShouldEqual, webmention.Keep)
+
+			_, verdict = moderator.Process(webmention.Mention{WMProperty: "in-reply-to",
+				Author: webmention.Author{URL: "https://stranger.example/"}})
+			So(verdict, ShouldEqual, webmention.Hold)
+			_, verdict = moderator.Process(webmention.Mention{WMProperty: "in-reply-to",
+				Author: webmention.Author{URL: "https://friend.example/"}})
+			So(verdict, ShouldEqual, webmention.Keep)
+			So(moderator.NeedsKnown(), ShouldBeTrue)
+		})
+
+		Convey("Every catch is counted per rule and logged", func() {
+			logPath := filepath.Join(t.TempDir(), "moderation.log")
+			OpenFileFunc = os.OpenFile
+			moderator.LogPath = logPath
+
+			moderator.Process(webmention.Mention{WMID: 1, WMSource: "https://spam.example/a"})
+			moderator.Process(webmention.Mention{WMID: 2, WMSource: "https://spam.example/b"})
+			moderator.Process(webmention.Mention{WMID: 3, WMSource: "https://ok.example/c"})
+			So(moderator.Caught(), ShouldResemble, map[string]int{"spam network": 2})
+
+			data, err := os.ReadFile(logPath)
+			So(err, ShouldBeNil)
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			So(lines, ShouldHaveLength, 2)
+			var catch Catch
+			So(json.Unmarshal([]byte(lines[1]), &catch), ShouldBeNil)
+			So(catch.Rule, ShouldEqual, "spam network")
+			So(catch.Action, ShouldEqual, Drop)
+			So(catch.WMID, ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,124 @@
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+)
+
+// QueueSuffix is appended to the destination directory to form the default path of its held-mentions queue, e.g.
+// data/webmentions.held.json for data/webmentions. It is kept outside the directory so site generators reading
+// every file in it never see held mentions.
+const QueueSuffix = ".held.json"
+
+// QueuePath returns the default queue path of destination.
+func QueuePath(destination string) string {
+	return filepath.Clean(destination) + QueueSuffix
+}
+
+// Held is a mention waiting in the queue for review.
+type Held struct {
+	Mention webmention.Mention `json:"mention"`
+	Domain  string             `json:"domain"`
+	// Reason names the rule or stage that held the mention.
+	Reason string    `json:"reason"`
+	Held   time.Time `json:"held"`
+}
+
+// Queue is the held-mentions queue of one destination, newest held first.
+type Queue struct {
+	Path     string
+	Mentions []Held
+}
+
+// queueMu serializes updates of queue files by the domains fetched concurrently.
+var queueMu sync.Mutex
+
+// LoadQueue reads the queue at path. A missing file yields an empty queue.
+func LoadQueue(path string) (*Queue, error) {
+	queue := Queue{Path: path}
+	data, err := ReadFileFunc(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &queue, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read held mentions: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &queue.Mentions); err != nil {
+			return nil, fmt.Errorf("cannot parse held mentions: %w", err)
+		}
+	}
+	return &queue, nil
+}
+
+// Add queues held, replacing a queued mention with the same WMID.
+func (q *Queue) Add(held Held) {
+	q.Mentions = slices.DeleteFunc(q.Mentions, func(queued Held) bool {
+		return queued.Mention.WMID == held.Mention.WMID
+	})
+	q.Mentions = append(q.Mentions, held)
+	sort.SliceStable(q.Mentions, func(i, j int) bool {
+		return q.Mentions[i].Held.After(q.Mentions[j].Held)
+	})
+}
+
+// Find returns the queued mention with wmID.
+func (q *Queue) Find(wmID int) (Held, bool) {
+	index := slices.IndexFunc(q.Mentions, func(held Held) bool { return held.Mention.WMID == wmID })
+	if index < 0 {
+		return Held{}, false
+	}
+	return q.Mentions[index], true
+}
+
+// Remove takes the mention with wmID out of the queue, returning it.
+func (q *Queue) Remove(wmID int) (Held, bool) {
+	held, ok := q.Find(wmID)
+	if ok {
+		q.Mentions = slices.DeleteFunc(q.Mentions, func(queued Held) bool { return queued.Mention.WMID == wmID })
+	}
+	return held, ok
+}
+
+// Save writes the queue back to its path.
+func (q *Queue) Save() error {
+	mentions := q.Mentions
+	if mentions == nil {
+		mentions = []Held{}
+	}
+	data, err := json.MarshalIndent(mentions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON: %w", err)
+	}
+	if err := WriteFileFunc(q.Path, data, 0644); err != nil {
+		return fmt.Errorf("cannot write held mentions: %w", err)
+	}
+	return nil
+}
+
+// Enqueue adds mentions to the queue at path.
+func Enqueue(path string, mentions []Held) error {
+	if len(mentions) == 0 {
+		return nil
+	}
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	queue, err := LoadQueue(path)
+	if err != nil {
+		return err
+	}
+	for _, held := range mentions {
+		queue.Add(held)
+	}
+	return queue.Save()
+}
@@ -0,0 +1,110 @@
+package moderation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQueue(t *testing.T) {
+	Convey("Given a queue path", t, func() {
+		ReadFileFunc = os.ReadFile
+		WriteFileFunc = os.WriteFile
+		path := QueuePath(filepath.Join(t.TempDir(), "webmentions") + "/")
+		So(path, ShouldEndWith, "webmentions.held.json")
+
+		Convey("A missing queue is empty", func() {
+			queue, err := LoadQueue(path)
+			So(err, ShouldBeNil)
+			So(queue.Mentions, ShouldBeEmpty)
+		})
+
+		Convey("Enqueued mentions are kept newest first and replaced by WMID", func() {
+			now := time.Now()
+			So(Enqueue(path, []Held{
+				{Mention: webmention.Mention{WMID: 1}, Reason: "a", Held: now.Add(-time.Minute)},
+				{Mention: webmention.Mention{WMID: 2}, Reason: "b", Held: now},
+			}), ShouldBeNil)
+			So(Enqueue(path, []Held{{Mention: webmention.Mention{WMID: 1}, Reason: "c", Held: now.Add(time.Minute)}}),
+				ShouldBeNil)
+
+			queue, err := LoadQueue(path)
+			So(err, ShouldBeNil)
+			So(queue.Mentions, ShouldHaveLength, 2)
+			So(queue.Mentions[0].Reason, ShouldEqual, "c")
+			So(queue.Mentions[1].Mention.WMID, ShouldEqual, 2)
+
+			Convey("and removed by WMID", func() {
+				held, ok := queue.Remove(2)
+				So(ok, ShouldBeTrue)
+				So(held.Reason, ShouldEqual, "b")
+				_, ok = queue.Remove(2)
+				So(ok, ShouldBeFalse)
+				So(queue.Save(), ShouldBeNil)
+
+				reloaded, err := LoadQueue(path)
+				So(err, ShouldBeNil)
+				So(reloaded.Mentions, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("Enqueueing nothing doesn't create the file", func() {
+			So(Enqueue(path, nil), ShouldBeNil)
+			_, err := os.Stat(path)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("A corrupt queue is an error", func() {
+			So(os.WriteFile(path, []byte("["), 0644), ShouldBeNil)
+			_, err := LoadQueue(path)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestBlocklist(t *testing.T) {
+	Convey("Given a blocklist path", t, func() {
+		ReadFileFunc = os.ReadFile
+		OpenFileFunc = os.OpenFile
+		path := filepath.Join(t.TempDir(), "webmentions.blocklist")
+
+		Convey("Blocked senders are appended once", func() {
+			So(os.WriteFile(path, []byte("# rejected\n\nspam.example\n"), 0644), ShouldBeNil)
+			So(Block(path, "https://troll.example"), ShouldBeNil)
+			So(Block(path, "spam.example"), ShouldBeNil)
+
+			senders, err := LoadBlocklist(path)
+			So(err, ShouldBeNil)
+			So(senders, ShouldResemble, []string{"spam.example", "https://troll.example"})
+
+			Convey("and dropped by the blocklist rules", func() {
+				moderator, err := New(BlocklistRules(senders))
+				So(err, ShouldBeNil)
+				_, verdict := moderator.Process(webmention.Mention{WMSource: "https://www.spam.example/a"})
+				So(verdict, ShouldEqual, webmention.Drop)
+				_, verdict = moderator.Process(webmention.Mention{WMSource: "https://brid.gy/x",
+					Author: webmention.Author{URL: "https://troll.example/"}})
+				So(verdict, ShouldEqual, webmention.Drop)
+				_, verdict = moderator.Process(webmention.Mention{WMSource: "https://brid.gy/y"})
+				So(verdict, ShouldEqual, webmention.Keep)
+			})
+		})
+
+		Convey("A missing blocklist is empty", func() {
+			senders, err := LoadBlocklist(path)
+			So(err, ShouldBeNil)
+			So(senders, ShouldBeEmpty)
+			So(BlocklistRules(senders), ShouldBeEmpty)
+		})
+
+		Convey("The sender is the author's URL, or else the source host", func() {
+			So(Sender(webmention.Mention{WMSource: "https://Blog.example/post",
+				Author: webmention.Author{URL: "https://Me.example/"}}), ShouldEqual, "https://me.example")
+			So(Sender(webmention.Mention{WMSource: "https://Blog.example/post"}), ShouldEqual, "blog.example")
+		})
+	})
+}
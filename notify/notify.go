@@ -0,0 +1,177 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/blbecker/webmentionR/webmention"
+	"github.com/charmbracelet/log"
+)
+
+//=== Bindings for tests
+
+var ReadFileFunc = os.ReadFile
+
+//=== Bindings for tests
+
+// DefaultMessage is the template of the message text. Its first line doubles as the email subject.
+const DefaultMessage = `{{len .Mentions}} new webmention{{if ne (len .Mentions) 1}}s{{end}} for {{.Domain}}
+{{range .Mentions}}
+- {{or .Author.Name "Someone"}} {{verb .WMProperty}} {{.WMTarget}}
+  {{.WMSource}}{{end}}
+`
+
+// Batch is the mentions a run stored for the first time.
+type Batch struct {
+	Domain   string               `json:"domain"`
+	Mentions []webmention.Mention `json:"mentions"`
+}
+
+// Message is the data templates are executed with: the batch plus its rendered message text.
+type Message struct {
+	Batch
+	Text string `json:"text"`
+}
+
+// Notifier delivers a batch of new mentions.
+type Notifier interface {
+	Notify(ctx context.Context, message Message) error
+}
+
+var funcs = template.FuncMap{
+	"verb":     verb,
+	"json":     toJSON,
+	"truncate": truncate,
+}
+
+// NewTemplate parses text as a message or body template. Templates may use verb to describe a wm-property, json to
+// encode any value as JSON and truncate to limit a string to n runes.
+func NewTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse template %s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// LoadTemplate parses the template file at path, or fallback when path is empty.
+func LoadTemplate(path, fallback string) (*template.Template, error) {
+	if path == "" {
+		return NewTemplate("default", fallback)
+	}
+	data, err := ReadFileFunc(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read template: %w", err)
+	}
+	return NewTemplate(path, string(data))
+}
+
+func execute(tmpl *template.Template, data any) (string, error) {
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("cannot execute template %s: %w", tmpl.Name(), err)
+	}
+	return out.String(), nil
+}
+
+func verb(property string) string {
+	switch property {
+	case "like-of":
+		return "liked"
+	case "repost-of":
+		return "reposted"
+	case "bookmark-of":
+		return "bookmarked"
+	case "in-reply-to":
+		return "replied to"
+	case "rsvp":
+		return "RSVPed to"
+	default:
+		return "mentioned"
+	}
+}
+
+func toJSON(value any) (string, error) {
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+func truncate(n int, s string) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:max(n-1, 0)]) + "…"
+}
+
+// Observer collects the mentions a run stored for the first time and delivers them to every notifier as one batch
+// when flushed. Subscribe it to the run's Bus, where it picks the new mentions of every TargetPersisted, or Update it
+// with mentions directly.
+type Observer struct {
+	Domain    string
+	Notifiers []Notifier
+	// Template renders the message text, DefaultMessage when nil.
+	Template *template.Template
+
+	mu       sync.Mutex
+	mentions []webmention.Mention
+}
+
+func (o *Observer) Update(mention webmention.Mention) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !slices.ContainsFunc(o.mentions, func(m webmention.Mention) bool { return m.WMID == mention.WMID }) {
+		o.mentions = append(o.mentions, mention)
+	}
+}
+
+// Handle collects the mentions a TargetPersisted stored for the first time.
+func (o *Observer) Handle(event webmention.Event) {
+	if persisted, ok := event.(webmention.TargetPersisted); ok {
+		for _, mention := range persisted.Stats.New {
+			o.Update(mention)
+		}
+	}
+}
+
+// Flush delivers the collected mentions, oldest first, and starts a new batch. Nothing is sent when no mention was
+// collected. A failing notifier doesn't stop the others.
+func (o *Observer) Flush(ctx context.Context) error {
+	o.mu.Lock()
+	batch := Batch{Domain: o.Domain, Mentions: o.mentions}
+	o.mentions = nil
+	o.mu.Unlock()
+
+	if len(batch.Mentions) == 0 || len(o.Notifiers) == 0 {
+		return nil
+	}
+	slices.SortFunc(batch.Mentions, func(a, b webmention.Mention) int { return a.WMID - b.WMID })
+
+	tmpl := o.Template
+	if tmpl == nil {
+		tmpl = template.Must(NewTemplate("default", DefaultMessage))
+	}
+	text, err := execute(tmpl, batch)
+	if err != nil {
+		return err
+	}
+	message := Message{Batch: batch, Text: strings.TrimSpace(text)}
+
+	var errs []error
+	for _, notifier := range o.Notifiers {
+		log.Info("Sending notification", "notifier", notifier, "mentions", len(batch.Mentions))
+		if err := notifier.Notify(ctx, message); err != nil {
+			errs = append(errs, fmt.Errorf("notifier %s failed: %w", notifier, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"text/template"
+
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recordingNotifier remembers every message it was asked to deliver.
+type recordingNotifier struct {
+	messages []Message
+	err      error
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, message Message) error {
+	n.messages = append(n.messages, message)
+	return n.err
+}
+
+func testMentions() []webmention.Mention {
+	return []webmention.Mention{
+		{WMID: 7, WMProperty: "like-of", WMTarget: "https://example.com/post", WMSource: "https://a.example/like",
+			Author: webmention.Author{Name: "Ada"}},
+		{WMID: 3, WMProperty: "in-reply-to", WMTarget: "https://example.com/post", WMSource: "https://b.example/reply"},
+	}
+}
+
+func TestObserver(t *testing.T) {
+	Convey("Given an observer with two notifiers", t, func() {
+		first, second := &recordingNotifier{}, &recordingNotifier{}
+		observer := &Observer{Domain: "example.com", Notifiers: []Notifier{first, second}}
+
+		Convey("Nothing is sent when no mention was observed", func() {
+			So(observer.Flush(context.Background()), ShouldBeNil)
+			So(first.messages, ShouldBeEmpty)
+		})
+
+		Convey("Observed mentions are sent as one batch, oldest first", func() {
+			for _, mention := range testMentions() {
+				observer.Update(mention)
+			}
+			observer.Update(testMentions()[0])
+			So(observer.Flush(context.Background()), ShouldBeNil)
+
+			So(first.messages, ShouldHaveLength, 1)
+			message := first.messages[0]
+			So(message.Domain, ShouldEqual, "example.com")
+			So(message.Mentions, ShouldHaveLength, 2)
+			So(message.Mentions[0].WMID, ShouldEqual, 3)
+			So(message.Text, ShouldStartWith, "2 new webmentions for example.com\n")
+			So(message.Text, ShouldContainSubstring, "- Ada liked https://example.com/post")
+			So(message.Text, ShouldContainSubstring, "- Someone replied to https://example.com/post")
+			So(second.messages, ShouldHaveLength, 1)
+
+			Convey("And the next flush starts a new batch", func() {
+				So(observer.Flush(context.Background()), ShouldBeNil)
+				So(first.messages, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("A failing notifier doesn't stop the others", func() {
+			first.err = errors.New("unreachable")
+			observer.Update(testMentions()[0])
+			err := observer.Flush(context.Background())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unreachable")
+			So(second.messages, ShouldHaveLength, 1)
+		})
+
+		Convey("Persisted targets contribute only the mentions stored for the first time", func() {
+			observer.Handle(webmention.TargetPersisted{Stats: webmention.PersistStats{
+				Persisted: testMentions(), New: testMentions()[:1]}})
+			observer.Handle(webmention.MentionReceived{Mention: testMentions()[1]})
+			So(observer.Flush(context.Background()), ShouldBeNil)
+			So(first.messages[0].Mentions, ShouldHaveLength, 1)
+			So(first.messages[0].Mentions[0].WMID, ShouldEqual, 7)
+		})
+
+		Convey("A custom template renders the text", func() {
+			observer.Template = template.Must(NewTemplate("custom", "{{.Domain}}: {{range .Mentions}}{{.WMID}} {{end}}"))
+			observer.Update(testMentions()[0])
+			So(observer.Flush(context.Background()), ShouldBeNil)
+			So(first.messages[0].Text, ShouldEqual, "example.com: 7")
+		})
+	})
+}
+
+func TestLoadTemplate(t *testing.T) {
+	Convey("Given template files", t, func() {
+		ReadFileFunc = func(name string) ([]byte, error) {
+			if name == "missing.tmpl" {
+				return nil, errors.New("no such file")
+			}
+			return []byte("{{.Domain"), nil
+		}
+
+		Convey("Without a path the fallback is parsed", func() {
+			tmpl, err := LoadTemplate("", DefaultMessage)
+			So(err, ShouldBeNil)
+			So(tmpl, ShouldNotBeNil)
+		})
+
+		Convey("An unreadable or invalid file is an error", func() {
+			_, err := LoadTemplate("missing.tmpl", DefaultMessage)
+			So(err, ShouldNotBeNil)
+			_, err = LoadTemplate("broken.tmpl", DefaultMessage)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestTruncate(t *testing.T) {
+	Convey("Long strings are cut to n runes including the ellipsis", t, func() {
+		So(truncate(5, "héllo"), ShouldEqual, "héllo")
+		So(truncate(4, "héllo"), ShouldEqual, "hél…")
+	})
+}
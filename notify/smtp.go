@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+//=== Bindings for tests
+
+var SendMailFunc = smtp.SendMail
+
+//=== Bindings for tests
+
+// Email sends the message as a plain text email through the SMTP server at Addr. The first line of the message text
+// is the subject and the rest the body.
+type Email struct {
+	Addr     string
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+func (e Email) Notify(_ context.Context, message Message) error {
+	if e.From == "" || len(e.To) == 0 {
+		return fmt.Errorf("a sender and at least one recipient are required")
+	}
+
+	subject, body, _ := strings.Cut(message.Text, "\n")
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.TrimSpace(body), "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, err := net.SplitHostPort(e.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address '%s': %w", e.Addr, err)
+		}
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	if err := SendMailFunc(e.Addr, auth, e.From, e.To, []byte(msg.String())); err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+	return nil
+}
+
+func (e Email) String() string {
+	return "smtp:" + e.Addr
+}
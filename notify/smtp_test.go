@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeSMTPServer accepts a single SMTP session on a local port and records the envelope and message it received.
+type fakeSMTPServer struct {
+	listener   net.Listener
+	from       string
+	recipients []string
+	data       string
+	done       chan struct{}
+}
+
+func newFakeSMTPServer() (*fakeSMTPServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &fakeSMTPServer{listener: listener, done: make(chan struct{})}
+	go server.serve()
+	return server, nil
+}
+
+func (s *fakeSMTPServer) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.recipients = append(s.recipients, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			s.data = data.String()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	Convey("Given a local SMTP server", t, func() {
+		SendMailFunc = smtp.SendMail
+		server, err := newFakeSMTPServer()
+		So(err, ShouldBeNil)
+		defer server.listener.Close()
+
+		message := Message{
+			Batch: Batch{Domain: "example.com", Mentions: testMentions()},
+			Text:  "2 new webmentions for example.com\n\n- Ada liked https://example.com/post",
+		}
+
+		Convey("The message is sent with its first line as the subject", func() {
+			email := Email{Addr: server.listener.Addr().String(), From: "wm@example.com", To: []string{"me@example.com"}}
+			So(email.Notify(context.Background(), message), ShouldBeNil)
+			<-server.done
+
+			So(server.from, ShouldEqual, "wm@example.com")
+			So(server.recipients, ShouldResemble, []string{"me@example.com"})
+			So(server.data, ShouldContainSubstring, "Subject: 2 new webmentions for example.com\r\n")
+			So(server.data, ShouldContainSubstring, "\r\n\r\n- Ada liked https://example.com/post\r\n")
+		})
+	})
+
+	Convey("A sender and recipient are required", t, func() {
+		So(Email{Addr: "127.0.0.1:25"}.Notify(context.Background(), Message{}), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"text/template"
+	"time"
+
+	"github.com/blbecker/webmentionR/secret"
+)
+
+// WebhookFormats are the built-in webhook body templates, keyed by format name.
+var WebhookFormats = map[string]string{
+	"json":    `{"domain": {{json .Domain}}, "text": {{json .Text}}, "mentions": {{json .Mentions}}}`,
+	"slack":   `{"text": {{json .Text}}}`,
+	"discord": `{"content": {{json (truncate 2000 .Text)}}}`,
+	"matrix":  `{"msgtype": "m.notice", "body": {{json .Text}}}`,
+}
+
+// WebhookFormat returns the body template for the named built-in format.
+func WebhookFormat(name string) (*template.Template, error) {
+	text, ok := WebhookFormats[name]
+	if !ok {
+		names := make([]string, 0, len(WebhookFormats))
+		for format := range WebhookFormats {
+			names = append(names, format)
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("unknown webhook format '%s', expected one of %v", name, names)
+	}
+	return NewTemplate(name, text)
+}
+
+// Webhook POSTs a JSON body rendered from Template to URL.
+type Webhook struct {
+	URL      string
+	Template *template.Template
+	// Client sends the request, a client with a 30 second timeout when nil.
+	Client *http.Client
+}
+
+func (w Webhook) Notify(ctx context.Context, message Message) error {
+	body, err := execute(w.Template, message)
+	if err != nil {
+		return err
+	}
+	if !json.Valid([]byte(body)) {
+		return fmt.Errorf("template %s did not render valid JSON", w.Template.Name())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader([]byte(body)))
+	if err != nil {
+		return fmt.Errorf("error building request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", secret.RedactString(err.Error(), w.URL))
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return nil
+}
+
+// String names the webhook by host only, as chat webhook URLs embed their credentials.
+func (w Webhook) String() string {
+	parsed, err := url.Parse(w.URL)
+	if err != nil {
+		return "webhook:invalid-url"
+	}
+	return "webhook:" + parsed.Host
+}
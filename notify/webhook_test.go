@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebhook(t *testing.T) {
+	Convey("Given a chat server", t, func() {
+		var received map[string]any
+		status := http.StatusNoContent
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			body, _ := io.ReadAll(req.Body)
+			received = nil
+			_ = json.Unmarshal(body, &received)
+			rw.WriteHeader(status)
+		}))
+		defer server.Close()
+
+		message := Message{Batch: Batch{Domain: "example.com", Mentions: testMentions()}, Text: "2 new \"webmentions\""}
+
+		Convey("Every built-in format posts valid JSON", func() {
+			expectations := map[string]string{"slack": "text", "discord": "content", "matrix": "body", "json": "text"}
+			for format, key := range expectations {
+				body, err := WebhookFormat(format)
+				So(err, ShouldBeNil)
+				So(Webhook{URL: server.URL, Template: body}.Notify(context.Background(), message), ShouldBeNil)
+				So(received[key], ShouldEqual, message.Text)
+				if format == "json" {
+					So(received["mentions"], ShouldHaveLength, 2)
+				}
+			}
+		})
+
+		Convey("An unknown format is an error", func() {
+			_, err := WebhookFormat("irc")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A template that doesn't render JSON is an error", func() {
+			body := template.Must(NewTemplate("broken", "text: {{.Text}}"))
+			So(Webhook{URL: server.URL, Template: body}.Notify(context.Background(), message), ShouldNotBeNil)
+		})
+
+		Convey("A non-2xx response is an error", func() {
+			status = http.StatusForbidden
+			body, _ := WebhookFormat("slack")
+			err := Webhook{URL: server.URL, Template: body}.Notify(context.Background(), message)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "403")
+		})
+
+		Convey("The URL is kept out of errors and the notifier's name", func() {
+			body, _ := WebhookFormat("slack")
+			webhook := Webhook{URL: "http://127.0.0.1:1/services/secret-token", Template: body}
+			err := webhook.Notify(context.Background(), message)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldNotContainSubstring, "secret-token")
+			So(webhook.String(), ShouldEqual, "webhook:127.0.0.1:1")
+		})
+	})
+}
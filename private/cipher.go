@@ -0,0 +1,123 @@
+package private
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// magic starts every encrypted file, followed by the salt, the nonce and the AES-256-GCM sealed JSON.
+var magic = []byte("WMR1")
+
+const (
+	saltSize = 16
+	keySize  = 32
+)
+
+// Cipher encrypts the files of a Store, with a key derived from a passphrase or read from a key file.
+type Cipher struct {
+	passphrase []byte
+	key        []byte
+}
+
+// NewPassphraseCipher returns a Cipher deriving a key from passphrase and a random salt for every file, with scrypt.
+func NewPassphraseCipher(passphrase string) (*Cipher, error) {
+	if passphrase == "" {
+		return nil, errors.New("the passphrase is empty")
+	}
+	return &Cipher{passphrase: []byte(passphrase)}, nil
+}
+
+// NewKeyFileCipher returns a Cipher using the key in the file at path: 32 bytes, hex-encoded, e.g. as written by
+// `openssl rand -hex 32`.
+func NewKeyFileCipher(path string) (*Cipher, error) {
+	data, err := ReadFileFunc(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key file: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("key file %s must hold %d hex-encoded bytes", path, keySize)
+	}
+	return &Cipher{key: key}, nil
+}
+
+func (c *Cipher) deriveKey(salt []byte) ([]byte, error) {
+	if c.key != nil {
+		return c.key, nil
+	}
+	return scrypt.Key(c.passphrase, salt, 1<<15, 8, 1, keySize)
+}
+
+func (c *Cipher) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := c.deriveKey(salt)
+	if err != nil {
+		return nil, fmt.Errorf("cannot derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts plaintext.
+func (c *Cipher) Seal(plaintext []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := c.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(append(append(bytes.Clone(magic), salt...), nonce...), aead.Seal(nil, nonce, plaintext, magic)...)
+	return sealed, nil
+}
+
+// Open decrypts data sealed by Seal with the same passphrase or key.
+func (c *Cipher) Open(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, magic) || len(data) < len(magic)+saltSize {
+		return nil, errors.New("not an encrypted mentions file")
+	}
+	data = data[len(magic):]
+	salt, data := data[:saltSize], data[saltSize:]
+	aead, err := c.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("not an encrypted mentions file")
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, magic)
+	if err != nil {
+		return nil, errors.New("cannot decrypt, wrong passphrase or key")
+	}
+	return plaintext, nil
+}
+
+// NewCipher returns the Cipher for a passphrase or a key file, nil when neither is given.
+func NewCipher(passphrase, keyFile string) (*Cipher, error) {
+	switch {
+	case passphrase != "" && keyFile != "":
+		return nil, errors.New("set either a passphrase or a key file, not both")
+	case passphrase != "":
+		return NewPassphraseCipher(passphrase)
+	case keyFile != "":
+		return NewKeyFileCipher(keyFile)
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,159 @@
+package private
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/blbecker/webmentionR/webmention"
+)
+
+//=== Bindings for tests
+
+var ReadFileFunc = os.ReadFile
+
+var WriteFileFunc = os.WriteFile
+
+var MkdirAllFunc = os.MkdirAll
+
+//=== Bindings for tests
+
+// DirSuffix is appended to the destination directory to form the default directory of its private store, e.g.
+// data/webmentions.private for data/webmentions. It is kept outside the destination so site generators never read it.
+const DirSuffix = ".private"
+
+// EncryptedSuffix is the file name suffix of encrypted target files.
+const EncryptedSuffix = ".json.enc"
+
+// Dir returns the default private store directory of destination.
+func Dir(destination string) string {
+	return filepath.Clean(destination) + DirSuffix
+}
+
+// Store keeps private mentions in one file per target, like the destination directory, encrypted when Cipher is set.
+type Store struct {
+	Dir    string
+	Cipher *Cipher
+
+	mu sync.Mutex
+}
+
+// Path returns the file the store keeps the mentions of target in.
+func (s *Store) Path(target webmention.Mention) (string, error) {
+	slug, err := target.GenerateSlug()
+	if err != nil {
+		return "", err
+	}
+	if s.Cipher != nil {
+		return filepath.Join(s.Dir, slug+EncryptedSuffix), nil
+	}
+	return filepath.Join(s.Dir, slug+".json"), nil
+}
+
+// Save merges mentions into their targets' files, returning the mentions stored for the first time. derived are the
+// derived fields of the pipeline the mentions came through, as for webmention.MergeMention.
+func (s *Store) Save(mentions []webmention.Mention, derived webmention.Derived) ([]webmention.Mention, error) {
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := MkdirAllFunc(s.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create private store: %w", err)
+	}
+	mentionsByPath := map[string][]webmention.Mention{}
+	for _, mention := range mentions {
+		path, err := s.Path(mention)
+		if err != nil {
+			return nil, err
+		}
+		mentionsByPath[path] = append(mentionsByPath[path], mention)
+	}
+
+	var added []webmention.Mention
+	for path, mentions := range mentionsByPath {
+		stored, err := s.load(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, mention := range mentions {
+			var result webmention.MergeResult
+			stored, result = webmention.MergeMention(stored, mention, derived)
+			if result == webmention.Inserted {
+				added = append(added, mention)
+			}
+		}
+		if err := s.save(path, stored); err != nil {
+			return nil, err
+		}
+	}
+	return added, nil
+}
+
+// LoadAll returns the stored mentions keyed by file path.
+func (s *Store) LoadAll() (map[string][]webmention.Mention, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	suffix := ".json"
+	if s.Cipher != nil {
+		suffix = EncryptedSuffix
+	}
+	paths, err := filepath.Glob(filepath.Join(s.Dir, "*"+suffix))
+	if err != nil {
+		return nil, fmt.Errorf("error listing private mention files: %w", err)
+	}
+	mentionsByPath := map[string][]webmention.Mention{}
+	for _, path := range paths {
+		mentions, err := s.load(path)
+		if err != nil {
+			return nil, err
+		}
+		mentionsByPath[path] = mentions
+	}
+	return mentionsByPath, nil
+}
+
+func (s *Store) load(path string) ([]webmention.Mention, error) {
+	data, err := ReadFileFunc(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading private mentions: %w", err)
+	}
+	if s.Cipher != nil {
+		if data, err = s.Cipher.Open(data); err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+	}
+	var mentions []webmention.Mention
+	if len(strings.TrimSpace(string(data))) > 0 {
+		if err := json.Unmarshal(data, &mentions); err != nil {
+			return nil, fmt.Errorf("error unmarshalling JSON: %w", err)
+		}
+	}
+	return mentions, nil
+}
+
+func (s *Store) save(path string, mentions []webmention.Mention) error {
+	data, err := json.MarshalIndent(mentions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON: %w", err)
+	}
+	if s.Cipher != nil {
+		if data, err = s.Cipher.Seal(data); err != nil {
+			return fmt.Errorf("cannot encrypt private mentions: %w", err)
+		}
+	}
+	if err := WriteFileFunc(path, data, 0600); err != nil {
+		return fmt.Errorf("error writing private mentions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package private
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCipher(t *testing.T) {
+	Convey("Given a passphrase cipher", t, func() {
+		cipher, err := NewPassphraseCipher("correct horse")
+		So(err, ShouldBeNil)
+
+		Convey("Sealed data opens with the same passphrase only", func() {
+			sealed, err := cipher.Seal([]byte(`[{"wm-id":1}]`))
+			So(err, ShouldBeNil)
+			So(bytes.Contains(sealed, []byte("wm-id")), ShouldBeFalse)
+
+			opened, err := cipher.Open(sealed)
+			So(err, ShouldBeNil)
+			So(string(opened), ShouldEqual, `[{"wm-id":1}]`)
+
+			other, _ := NewPassphraseCipher("battery staple")
+			_, err = other.Open(sealed)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Plain JSON isn't opened", func() {
+			_, err := cipher.Open([]byte("[]"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a key file", t, func() {
+		ReadFileFunc = os.ReadFile
+		path := filepath.Join(t.TempDir(), "private.key")
+
+		Convey("A hex-encoded 32-byte key is used as is", func() {
+			So(os.WriteFile(path, []byte(strings.Repeat("ab", 32)+"\n"), 0600), ShouldBeNil)
+			cipher, err := NewCipher("", path)
+			So(err, ShouldBeNil)
+			sealed, err := cipher.Seal([]byte("secret"))
+			So(err, ShouldBeNil)
+			opened, err := cipher.Open(sealed)
+			So(err, ShouldBeNil)
+			So(string(opened), ShouldEqual, "secret")
+		})
+
+		Convey("Short or malformed keys are rejected", func() {
+			So(os.WriteFile(path, []byte("abcd"), 0600), ShouldBeNil)
+			_, err := NewKeyFileCipher(path)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A passphrase and a key file together are rejected", func() {
+			_, err := NewCipher("pass", path)
+			So(err, ShouldNotBeNil)
+			cipher, err := NewCipher("", "")
+			So(err, ShouldBeNil)
+			So(cipher, ShouldBeNil)
+		})
+	})
+}
+
+func TestStore(t *testing.T) {
+	Convey("Given a private store", t, func() {
+		ReadFileFunc = os.ReadFile
+		WriteFileFunc = os.WriteFile
+		MkdirAllFunc = os.MkdirAll
+		destination := filepath.Join(t.TempDir(), "webmentions")
+		store := &Store{Dir: Dir(destination)}
+		So(store.Dir, ShouldEqual, destination+".private")
+
+		mentions := []webmention.Mention{
+			{WMID: 1, WMTarget: "https://example.com/post", WMPrivate: true},
+			{WMID: 2, WMTarget: "https://example.com/other", WMPrivate: true},
+		}
+
+		Convey("Mentions are saved per target and merged on later runs", func() {
+			added, err := store.Save(mentions, webmention.Derived{})
+			So(err, ShouldBeNil)
+			So(added, ShouldHaveLength, 2)
+			added, err = store.Save(mentions[:1], webmention.Derived{})
+			So(err, ShouldBeNil)
+			So(added, ShouldBeEmpty)
+
+			mentionsByPath, err := store.LoadAll()
+			So(err, ShouldBeNil)
+			So(mentionsByPath, ShouldHaveLength, 2)
+			So(mentionsByPath[filepath.Join(store.Dir, "post.json")][0].WMID, ShouldEqual, 1)
+		})
+
+		Convey("Encrypted stores write no plain JSON", func() {
+			store.Cipher, _ = NewPassphraseCipher("correct horse")
+			_, err := store.Save(mentions, webmention.Derived{})
+			So(err, ShouldBeNil)
+
+			data, err := os.ReadFile(filepath.Join(store.Dir, "post"+EncryptedSuffix))
+			So(err, ShouldBeNil)
+			So(bytes.Contains(data, []byte("example.com")), ShouldBeFalse)
+
+			mentionsByPath, err := store.LoadAll()
+			So(err, ShouldBeNil)
+			So(mentionsByPath, ShouldHaveLength, 2)
+
+			store.Cipher, _ = NewPassphraseCipher("wrong")
+			_, err = store.LoadAll()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Saving nothing creates nothing", func() {
+			added, err := store.Save(nil, webmention.Derived{})
+			So(err, ShouldBeNil)
+			So(added, ShouldBeEmpty)
+			_, err = os.Stat(store.Dir)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,183 @@
+package sanitize
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/blbecker/webmentionR/webmention"
+	"golang.org/x/net/html"
+)
+
+// DefaultAllow is the allowlist of tags and their attributes applied when none is configured.
+var DefaultAllow = []string{
+	"a:href,title", "abbr:title", "b", "blockquote:cite", "br", "cite", "code", "del", "em", "figcaption", "figure",
+	"hr", "i", "img:src,alt,title,width,height", "li", "ol", "p", "pre", "q:cite", "s", "small", "span", "strong",
+	"sub", "sup", "time:datetime", "u", "ul",
+}
+
+// dropContent are the tags removed together with everything inside them. Other tags not on the allowlist are
+// removed but keep their content.
+var dropContent = []string{"script", "style", "iframe", "object", "embed", "template", "noscript", "textarea",
+	"select", "svg", "math"}
+
+// urlAttributes hold URLs, which must be relative or use one of urlSchemes.
+var urlAttributes = []string{"href", "src", "cite"}
+
+var urlSchemes = []string{"http", "https", "mailto"}
+
+// LinkRel is forced on every link, so search engines don't credit third-party links and readers know them as
+// user-generated.
+const LinkRel = "nofollow ugc"
+
+// Policy maps each allowed tag to its allowed attributes. Event handler attributes are never allowed.
+type Policy map[string][]string
+
+// ParsePolicy builds a policy from specs of the form tag or tag:attribute,attribute.
+func ParsePolicy(specs []string) (Policy, error) {
+	policy := Policy{}
+	for _, spec := range specs {
+		tag, attributes, _ := strings.Cut(strings.TrimSpace(spec), ":")
+		tag = strings.ToLower(tag)
+		if tag == "" {
+			return nil, fmt.Errorf("invalid allowlist entry '%s', expected tag or tag:attribute,attribute", spec)
+		}
+		for _, attribute := range strings.Split(attributes, ",") {
+			if attribute = strings.ToLower(strings.TrimSpace(attribute)); attribute != "" {
+				if strings.HasPrefix(attribute, "on") {
+					return nil, fmt.Errorf("invalid allowlist entry '%s', event handlers can't be allowed", spec)
+				}
+				policy[tag] = append(policy[tag], attribute)
+			}
+		}
+		if _, ok := policy[tag]; !ok {
+			policy[tag] = nil
+		}
+	}
+	return policy, nil
+}
+
+// Sanitize returns fragment with every tag and attribute not on the allowlist removed, unsafe URLs dropped and
+// rel="nofollow ugc" set on links. Comments are removed, as are scripts, styles and other embedded content.
+func (p Policy) Sanitize(fragment string) string {
+	var out strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(fragment))
+	// skipping counts the dropContent elements the tokenizer is inside of.
+	skipping := 0
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return out.String()
+		case html.TextToken:
+			if skipping == 0 {
+				out.WriteString(textEscaper.Replace(string(tokenizer.Text())))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if slices.Contains(dropContent, token.Data) {
+				if token.Type == html.StartTagToken && !isVoid(token.Data) {
+					skipping++
+				}
+				continue
+			}
+			if skipping == 0 {
+				p.writeStartTag(&out, token)
+			}
+		case html.EndTagToken:
+			token := tokenizer.Token()
+			if slices.Contains(dropContent, token.Data) {
+				skipping = max(skipping-1, 0)
+				continue
+			}
+			if _, ok := p[token.Data]; ok && skipping == 0 && !isVoid(token.Data) {
+				out.WriteString("</" + token.Data + ">")
+			}
+		}
+	}
+}
+
+func (p Policy) writeStartTag(out *strings.Builder, token html.Token) {
+	allowed, ok := p[token.Data]
+	if !ok {
+		return
+	}
+	var attributes []html.Attribute
+	for _, attribute := range token.Attr {
+		name := strings.ToLower(attribute.Key)
+		if attribute.Namespace != "" || strings.HasPrefix(name, "on") || name == "rel" || !slices.Contains(allowed, name) {
+			continue
+		}
+		if slices.Contains(urlAttributes, name) && !safeURL(attribute.Val) {
+			continue
+		}
+		attributes = append(attributes, html.Attribute{Key: name, Val: attribute.Val})
+	}
+	if token.Data == "a" {
+		attributes = append(attributes, html.Attribute{Key: "rel", Val: LinkRel})
+	}
+	sort.SliceStable(attributes, func(i, j int) bool { return attributes[i].Key < attributes[j].Key })
+
+	out.WriteString("<" + token.Data)
+	for _, attribute := range attributes {
+		out.WriteString(" " + attribute.Key + `="` + attributeEscaper.Replace(attribute.Val) + `"`)
+	}
+	if token.Type == html.SelfClosingTagToken {
+		out.WriteString("/>")
+	} else {
+		out.WriteString(">")
+	}
+}
+
+// The escapers escape only what's needed, so sanitizing leaves safe HTML unchanged as often as possible.
+var (
+	textEscaper      = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\u00a0", "&nbsp;")
+	attributeEscaper = strings.NewReplacer("&", "&amp;", `"`, "&quot;", "<", "&lt;", ">", "&gt;")
+)
+
+// safeURL reports whether rawURL is relative or uses an allowed scheme. Browsers ignore control characters and
+// whitespace in schemes, so they are stripped before the scheme is checked.
+func safeURL(rawURL string) bool {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, rawURL)
+	parsed, err := url.Parse(cleaned)
+	if err != nil {
+		return false
+	}
+	return parsed.Scheme == "" || slices.Contains(urlSchemes, strings.ToLower(parsed.Scheme))
+}
+
+func isVoid(tag string) bool {
+	return slices.Contains([]string{"br", "hr", "img", "wbr", "area", "col", "embed", "input", "source", "track"}, tag)
+}
+
+// Sanitizer sanitizes the HTML content of every mention with Policy. It is a webmention.Stage. With KeepRaw, the
+// original is kept in the content's raw-html for auditing when sanitizing changed the HTML. That field is saved to
+// the destination with the mention, so it is off by default.
+type Sanitizer struct {
+	Policy  Policy
+	KeepRaw bool
+}
+
+func (s Sanitizer) Process(mention webmention.Mention) (webmention.Mention, webmention.Verdict) {
+	if mention.Content.HTML == "" {
+		return mention, webmention.Keep
+	}
+	sanitized := s.Policy.Sanitize(mention.Content.HTML)
+	if sanitized != mention.Content.HTML {
+		if s.KeepRaw {
+			mention.Content.RawHTML = mention.Content.HTML
+		}
+		mention.Content.HTML = sanitized
+	}
+	return mention, webmention.Keep
+}
+
+func (s Sanitizer) String() string {
+	return "sanitize"
+}
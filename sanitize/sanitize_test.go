@@ -0,0 +1,86 @@
+package sanitize
+
+import (
+	"testing"
+
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSanitize(t *testing.T) {
+	Convey("Given the default policy", t, func() {
+		policy, err := ParsePolicy(DefaultAllow)
+		So(err, ShouldBeNil)
+
+		Convey("Safe markup is left unchanged", func() {
+			safe := `<p>Nice <em>post</em> &amp; thanks<br>see <img alt="cat" src="https://x.example/cat.jpg"/></p>`
+			So(policy.Sanitize(safe), ShouldEqual, safe)
+		})
+
+		Convey("Scripts, styles and comments are removed with their content", func() {
+			So(policy.Sanitize(`<p>hi<script>alert(1)</script><style>p{}</style><!-- x --></p>`), ShouldEqual, "<p>hi</p>")
+			So(policy.Sanitize(`<iframe src="https://x.example"><p>inside</p></iframe>after`), ShouldEqual, "after")
+		})
+
+		Convey("Tags not on the allowlist are removed but keep their content", func() {
+			So(policy.Sanitize(`<div class="h-entry"><marquee>text</marquee></div>`), ShouldEqual, "text")
+		})
+
+		Convey("Event handlers, styles and unknown attributes are removed", func() {
+			So(policy.Sanitize(`<p onclick="evil()" style="color:red" class="x">hi</p>`), ShouldEqual, "<p>hi</p>")
+			So(policy.Sanitize(`<img src="a.jpg" onerror="evil()">`), ShouldEqual, `<img src="a.jpg">`)
+		})
+
+		Convey("Links get rel=nofollow ugc and unsafe URLs are dropped", func() {
+			So(policy.Sanitize(`<a href="https://x.example" rel="me" target="_blank">x</a>`), ShouldEqual,
+				`<a href="https://x.example" rel="nofollow ugc">x</a>`)
+			So(policy.Sanitize(`<a href="java&#x09;script:alert(1)">x</a>`), ShouldEqual, `<a rel="nofollow ugc">x</a>`)
+			So(policy.Sanitize(`<a href=" JAVASCRIPT:alert(1)">x</a>`), ShouldEqual, `<a rel="nofollow ugc">x</a>`)
+			So(policy.Sanitize(`<img src="data:image/svg+xml,<svg onload=alert(1)>">`), ShouldEqual, `<img>`)
+		})
+
+		Convey("Text is escaped", func() {
+			So(policy.Sanitize(`1 &lt; 2 &lt;script&gt;`), ShouldEqual, `1 &lt; 2 &lt;script&gt;`)
+		})
+	})
+
+	Convey("A configured allowlist replaces the default", t, func() {
+		policy, err := ParsePolicy([]string{"p", "a:href, title"})
+		So(err, ShouldBeNil)
+		So(policy.Sanitize(`<p><em>x</em> <a href="/y" title="t" id="i">y</a></p>`), ShouldEqual,
+			`<p>x <a href="/y" rel="nofollow ugc" title="t">y</a></p>`)
+
+		_, err = ParsePolicy([]string{"p:onclick"})
+		So(err, ShouldNotBeNil)
+		_, err = ParsePolicy([]string{":href"})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSanitizer(t *testing.T) {
+	Convey("Given a sanitizer", t, func() {
+		policy, _ := ParsePolicy(DefaultAllow)
+		sanitizer := Sanitizer{Policy: policy}
+		raw := `<p>hi<script>x</script></p>`
+
+		Convey("Changed HTML is sanitized without keeping the original", func() {
+			mention, verdict := sanitizer.Process(webmention.Mention{Content: webmention.Content{HTML: raw}})
+			So(verdict, ShouldEqual, webmention.Keep)
+			So(mention.Content.HTML, ShouldEqual, "<p>hi</p>")
+			So(mention.Content.RawHTML, ShouldBeEmpty)
+		})
+
+		Convey("The original of changed HTML is kept when asked to", func() {
+			sanitizer.KeepRaw = true
+			mention, _ := sanitizer.Process(webmention.Mention{Content: webmention.Content{HTML: raw}})
+			So(mention.Content.HTML, ShouldEqual, "<p>hi</p>")
+			So(mention.Content.RawHTML, ShouldEqual, raw)
+		})
+
+		Convey("Safe HTML keeps no copy", func() {
+			sanitizer.KeepRaw = true
+			mention, _ := sanitizer.Process(webmention.Mention{Content: webmention.Content{HTML: "<p>hi</p>"}})
+			So(mention.Content.RawHTML, ShouldBeEmpty)
+		})
+	})
+}
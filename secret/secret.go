@@ -0,0 +1,113 @@
+package secret
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// Redacted replaces secret values wherever they would otherwise be logged or returned in an error.
+const Redacted = "REDACTED"
+
+// Source describes where a secret is read from. At most one of Value, Env, File and Command may be set;
+// config.Settings.Secret keeps only the sources given at the highest precedence layer.
+type Source struct {
+	// Name is the setting the secret is given as, "token" when empty, naming the secret in errors.
+	Name string
+	// Value is the secret itself, as given with --token.
+	Value string
+	// Env names an environment variable holding the secret.
+	Env string
+	// File is a path to a file holding the secret, as mounted by Docker or Kubernetes secrets.
+	File string
+	// Command is a shell command printing the secret on stdout, e.g. `pass show webmention.io`.
+	Command string
+}
+
+//=== Bindings for tests
+
+var LookupEnvFunc = os.LookupEnv
+
+var ReadFileFunc = os.ReadFile
+
+var StatFunc = os.Stat
+
+//=== Bindings for tests
+
+// Resolve returns the secret from whichever source is configured, with surrounding whitespace trimmed.
+func (s Source) Resolve(ctx context.Context) (string, error) {
+	name := s.Name
+	if name == "" {
+		name = "token"
+	}
+	configured := 0
+	for _, value := range []string{s.Value, s.Env, s.File, s.Command} {
+		if value != "" {
+			configured++
+		}
+	}
+	if configured > 1 {
+		return "", fmt.Errorf("only one of %[1]s, %[1]s-env, %[1]s-file and %[1]s-command may be set", name)
+	}
+
+	switch {
+	case s.Env != "":
+		token, ok := LookupEnvFunc(s.Env)
+		if !ok {
+			return "", fmt.Errorf("%s environment variable %s is not set", name, s.Env)
+		}
+		return strings.TrimSpace(token), nil
+	case s.File != "":
+		return readTokenFile(name, s.File)
+	case s.Command != "":
+		return runTokenCommand(ctx, name, s.Command)
+	default:
+		return strings.TrimSpace(s.Value), nil
+	}
+}
+
+func readTokenFile(name, path string) (string, error) {
+	info, err := StatFunc(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot stat %s file: %w", name, err)
+	}
+	if info.Mode().Perm()&0o004 != 0 {
+		log.Warn("Secret file is readable by every user, consider restricting it with chmod o-r", "setting", name+"-file", "path", path, "mode", info.Mode().Perm())
+	}
+
+	data, err := ReadFileFunc(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read %s file: %w", name, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func runTokenCommand(ctx context.Context, name, command string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	// The command itself is not secret, but its output is, so it is never included in errors.
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s command failed: %w", name, err)
+	}
+	token := strings.TrimSpace(stdout.String())
+	if token == "" {
+		return "", fmt.Errorf("%s command printed nothing", name)
+	}
+	return token, nil
+}
+
+// RedactString replaces every occurrence of secret in s.
+func RedactString(s, secret string) string {
+	if secret == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, secret, Redacted)
+}
@@ -0,0 +1,91 @@
+package secret
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSource_Resolve(t *testing.T) {
+	Convey("Given a token source", t, func() {
+		ctx := context.Background()
+
+		Convey("A literal value is returned trimmed", func() {
+			token, err := Source{Value: " literal-token\n"}.Resolve(ctx)
+			So(err, ShouldBeNil)
+			So(token, ShouldEqual, "literal-token")
+		})
+
+		Convey("An environment variable is read", func() {
+			t.Setenv("TEST_WEBMENTION_TOKEN", "env-token")
+			token, err := Source{Env: "TEST_WEBMENTION_TOKEN"}.Resolve(ctx)
+			So(err, ShouldBeNil)
+			So(token, ShouldEqual, "env-token")
+		})
+
+		Convey("A missing environment variable is an error", func() {
+			_, err := Source{Env: "TEST_WEBMENTION_TOKEN_UNSET"}.Resolve(ctx)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A token file is read", func() {
+			path := filepath.Join(t.TempDir(), "token")
+			So(os.WriteFile(path, []byte("file-token\n"), 0600), ShouldBeNil)
+
+			token, err := Source{File: path}.Resolve(ctx)
+			So(err, ShouldBeNil)
+			So(token, ShouldEqual, "file-token")
+		})
+
+		Convey("A world-readable token file is still read", func() {
+			path := filepath.Join(t.TempDir(), "token")
+			So(os.WriteFile(path, []byte("file-token"), 0644), ShouldBeNil)
+			So(os.Chmod(path, 0644), ShouldBeNil)
+
+			token, err := Source{File: path}.Resolve(ctx)
+			So(err, ShouldBeNil)
+			So(token, ShouldEqual, "file-token")
+		})
+
+		Convey("A missing token file is an error", func() {
+			_, err := Source{File: filepath.Join(t.TempDir(), "missing")}.Resolve(ctx)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A command's output is used", func() {
+			token, err := Source{Command: "echo command-token"}.Resolve(ctx)
+			So(err, ShouldBeNil)
+			So(token, ShouldEqual, "command-token")
+		})
+
+		Convey("A failing command is an error", func() {
+			_, err := Source{Command: "exit 3"}.Resolve(ctx)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A command printing nothing is an error", func() {
+			_, err := Source{Command: "true"}.Resolve(ctx)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Setting several sources is an error", func() {
+			_, err := Source{Value: "a", Env: "B"}.Resolve(ctx)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Errors name the secret", func() {
+			_, err := Source{Name: "mastodon-token", Env: "TEST_WEBMENTION_TOKEN_UNSET"}.Resolve(ctx)
+			So(err.Error(), ShouldStartWith, "mastodon-token environment variable")
+		})
+	})
+}
+
+func TestRedactString(t *testing.T) {
+	Convey("Secrets are replaced wherever they occur", t, func() {
+		So(RedactString("token=abc&x=abc", "abc"), ShouldEqual, "token=REDACTED&x=REDACTED")
+		So(RedactString("nothing here", ""), ShouldEqual, "nothing here")
+	})
+}
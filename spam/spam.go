@@ -0,0 +1,183 @@
+package spam
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+)
+
+// The signals a Scorer looks for, as recorded in a mention's spam-signals.
+const (
+	// LinkDensity fires when the content links more than once per LinkDensityLimit words.
+	LinkDensity = "link-density"
+	// DomainMismatch fires when wm-target isn't on the domain being fetched.
+	DomainMismatch = "domain-mismatch"
+	// Phrase fires when the text or name contains one of the spam phrases.
+	Phrase = "spam-phrase"
+	// NoAuthor fires when the mention has neither an author name nor URL.
+	NoAuthor = "no-author"
+	// Burst fires when a source host sent more than BurstLimit mentions within BurstWindow.
+	Burst = "burst"
+)
+
+// DefaultWeights are the points each signal adds to a score. Scores are capped at 100.
+var DefaultWeights = map[string]int{
+	LinkDensity:    40,
+	DomainMismatch: 30,
+	Phrase:         50,
+	NoAuthor:       20,
+	Burst:          30,
+}
+
+// DefaultPhrases are matched case-insensitively against the text and name of every mention.
+var DefaultPhrases = []string{
+	"backlinks", "bitcoin", "buy now", "casino", "cheap", "crypto", "essay writing", "loan", "seo services", "viagra",
+}
+
+const (
+	LinkDensityLimit = 10
+	BurstLimit       = 5
+	BurstWindow      = time.Hour
+)
+
+var linkPattern = regexp.MustCompile(`(?i)<a\s[^>]*href\s*=`)
+
+// Scorer scores every mention from 0 to 100 and stores the score and the signals that fired with the mention. It is
+// a webmention.Stage: mentions scoring at least Threshold get Verdict, every mention when Threshold is 0 is only
+// scored.
+type Scorer struct {
+	// Domain is the site being fetched, for the domain-mismatch signal.
+	Domain    string
+	Threshold int
+	Verdict   webmention.Verdict
+	// Weights and Phrases default to DefaultWeights and DefaultPhrases when nil.
+	Weights map[string]int
+	Phrases []string
+
+	mu    sync.Mutex
+	sends map[string][]time.Time
+}
+
+// Score returns the score of mention and the signals that fired, in the order of the constants above. The mention
+// counts towards the burst of its source host.
+func (s *Scorer) Score(mention webmention.Mention) (int, []string) {
+	var signals []string
+	if linkDensity(mention.Content) {
+		signals = append(signals, LinkDensity)
+	}
+	if s.Domain != "" && !onDomain(mention.WMTarget, s.Domain) {
+		signals = append(signals, DomainMismatch)
+	}
+	if s.containsPhrase(mention) {
+		signals = append(signals, Phrase)
+	}
+	if mention.Author.Name == "" && mention.Author.URL == "" {
+		signals = append(signals, NoAuthor)
+	}
+	if s.burst(mention) {
+		signals = append(signals, Burst)
+	}
+
+	weights := s.Weights
+	if weights == nil {
+		weights = DefaultWeights
+	}
+	score := 0
+	for _, signal := range signals {
+		score += weights[signal]
+	}
+	return min(score, 100), signals
+}
+
+func (s *Scorer) Process(mention webmention.Mention) (webmention.Mention, webmention.Verdict) {
+	mention.SpamScore, mention.SpamSignals = s.Score(mention)
+	if s.Threshold > 0 && mention.SpamScore >= s.Threshold {
+		return mention, s.Verdict
+	}
+	return mention, webmention.Keep
+}
+
+func (s *Scorer) Derives() webmention.Derived {
+	return webmention.Derived{Spam: true}
+}
+
+func (s *Scorer) String() string {
+	return fmt.Sprintf("spam-score>=%d", s.Threshold)
+}
+
+// linkDensity reports whether content links more than once and at least once per LinkDensityLimit words.
+func linkDensity(content webmention.Content) bool {
+	links := len(linkPattern.FindAllStringIndex(content.HTML, -1))
+	words := len(strings.Fields(content.Text))
+	return links > 1 && links*LinkDensityLimit >= words
+}
+
+func onDomain(target, domain string) bool {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	domain = strings.ToLower(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+func (s *Scorer) containsPhrase(mention webmention.Mention) bool {
+	phrases := s.Phrases
+	if phrases == nil {
+		phrases = DefaultPhrases
+	}
+	text := strings.ToLower(mention.Content.Text + "\n" + mention.Name)
+	return slices.ContainsFunc(phrases, func(phrase string) bool {
+		return phrase != "" && strings.Contains(text, strings.ToLower(phrase))
+	})
+}
+
+// burst records the mention's wm-received time against its source host and reports whether the host now has more
+// than BurstLimit mentions within BurstWindow of it. Times outside BurstWindow of it are forgotten for every host, so
+// a long-running scorer doesn't grow with every host it has seen.
+func (s *Scorer) burst(mention webmention.Mention) bool {
+	parsed, err := url.Parse(mention.WMSource)
+	if err != nil || parsed.Host == "" || mention.WMReceived.IsZero() {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	received := mention.WMReceived
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sends == nil {
+		s.sends = map[string][]time.Time{}
+	}
+	// The API returns mentions newest first, so times outside the window on either side are dropped.
+	outside := func(sent time.Time) bool {
+		return sent.Sub(received).Abs() > BurstWindow
+	}
+	for other, sends := range s.sends {
+		if sends = slices.DeleteFunc(sends, outside); len(sends) == 0 {
+			delete(s.sends, other)
+		} else {
+			s.sends[other] = sends
+		}
+	}
+	s.sends[host] = append(s.sends[host], received)
+	return len(s.sends[host]) > BurstLimit
+}
+
+// ParseVerdict returns the verdict for a spam action, hold or drop.
+func ParseVerdict(action string) (webmention.Verdict, error) {
+	switch action {
+	case "hold":
+		return webmention.Hold, nil
+	case "drop":
+		return webmention.Drop, nil
+	default:
+		return webmention.Keep, fmt.Errorf("unknown spam action '%s', expected hold or drop", action)
+	}
+}
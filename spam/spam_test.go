@@ -0,0 +1,121 @@
+package spam
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blbecker/webmentionR/webmention"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScorer(t *testing.T) {
+	Convey("Given a scorer for example.com", t, func() {
+		scorer := &Scorer{Domain: "example.com", Threshold: 50, Verdict: webmention.Hold}
+		clean := webmention.Mention{
+			WMSource: "https://friend.example/reply",
+			WMTarget: "https://www.example.com/post",
+			Author:   webmention.Author{Name: "Friend"},
+			Content: webmention.Content{
+				Text: "I enjoyed this post about gardening and wrote a reply on my own site about it",
+				HTML: `I enjoyed <a href="https://www.example.com/post">this post</a> about gardening and wrote a reply`,
+			},
+		}
+
+		Convey("A regular reply scores 0 and is kept", func() {
+			mention, verdict := scorer.Process(clean)
+			So(verdict, ShouldEqual, webmention.Keep)
+			So(mention.SpamScore, ShouldEqual, 0)
+			So(mention.SpamSignals, ShouldBeEmpty)
+		})
+
+		Convey("Each signal adds its weight", func() {
+			linky := clean
+			linky.Content = webmention.Content{Text: "cheap stuff here", HTML: strings.Repeat(`<a href="https://x.example">x</a> `, 3)}
+			score, signals := scorer.Score(linky)
+			So(signals, ShouldResemble, []string{LinkDensity, Phrase})
+			So(score, ShouldEqual, 90)
+
+			elsewhere := clean
+			elsewhere.WMTarget = "https://notexample.com/post"
+			elsewhere.Author = webmention.Author{}
+			score, signals = scorer.Score(elsewhere)
+			So(signals, ShouldResemble, []string{DomainMismatch, NoAuthor})
+			So(score, ShouldEqual, 50)
+		})
+
+		Convey("Mentions reaching the threshold get the verdict with their score stored", func() {
+			spammy := clean
+			spammy.Name = "Online CASINO"
+			spammy.Author = webmention.Author{}
+			mention, verdict := scorer.Process(spammy)
+			So(verdict, ShouldEqual, webmention.Hold)
+			So(mention.SpamScore, ShouldEqual, 70)
+			So(mention.SpamSignals, ShouldResemble, []string{Phrase, NoAuthor})
+		})
+
+		Convey("Without a threshold mentions are only scored", func() {
+			scorer.Threshold = 0
+			spammy := clean
+			spammy.Name = "casino"
+			mention, verdict := scorer.Process(spammy)
+			So(verdict, ShouldEqual, webmention.Keep)
+			So(mention.SpamScore, ShouldEqual, 50)
+		})
+
+		Convey("Custom phrases and weights replace the defaults", func() {
+			scorer.Phrases = []string{"gardening"}
+			scorer.Weights = map[string]int{Phrase: 80}
+			score, signals := scorer.Score(clean)
+			So(signals, ShouldResemble, []string{Phrase})
+			So(score, ShouldEqual, 80)
+		})
+
+		Convey("A burst of mentions from one host is flagged once it exceeds the limit within the window", func() {
+			received := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+			burst := clean
+			burst.WMSource = "https://flood.example/a"
+			for i := 0; i < BurstLimit; i++ {
+				burst.WMReceived = received.Add(-time.Duration(i) * time.Minute)
+				_, signals := scorer.Score(burst)
+				So(signals, ShouldNotContain, Burst)
+			}
+			burst.WMReceived = received.Add(-10 * time.Minute)
+			_, signals := scorer.Score(burst)
+			So(signals, ShouldContain, Burst)
+
+			burst.WMReceived = received.Add(-3 * BurstWindow)
+			_, signals = scorer.Score(burst)
+			So(signals, ShouldNotContain, Burst)
+		})
+
+		Convey("Hosts that sent nothing within the window are forgotten", func() {
+			received := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+			old := clean
+			old.WMSource = "https://once.example/a"
+			old.WMReceived = received.Add(-2 * BurstWindow)
+			scorer.Score(old)
+			So(scorer.sends, ShouldContainKey, "once.example")
+
+			recent := clean
+			recent.WMSource = "https://other.example/a"
+			recent.WMReceived = received
+			scorer.Score(recent)
+			So(scorer.sends, ShouldNotContainKey, "once.example")
+			So(scorer.sends, ShouldContainKey, "other.example")
+		})
+	})
+}
+
+func TestParseVerdict(t *testing.T) {
+	Convey("Spam actions map to verdicts", t, func() {
+		verdict, err := ParseVerdict("drop")
+		So(err, ShouldBeNil)
+		So(verdict, ShouldEqual, webmention.Drop)
+		verdict, err = ParseVerdict("hold")
+		So(err, ShouldBeNil)
+		So(verdict, ShouldEqual, webmention.Hold)
+		_, err = ParseVerdict("hide")
+		So(err, ShouldNotBeNil)
+	})
+}
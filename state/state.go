@@ -5,15 +5,163 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"maps"
 	"os"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/charmbracelet/log"
 )
 
+// SchemaVersion is the version of the state file layout written by WriteState.
+const SchemaVersion = 2
+
+// HistoryLimit bounds the number of runs kept per domain.
+const HistoryLimit = 20
+
 type State struct {
 	mu      sync.RWMutex
-	SinceID int `json:"sinceID"`
+	Version int `json:"version"`
+	// SinceID is the cursor of state files written before state was kept per domain, when a state file served a
+	// single domain. It is moved into the first domain given an entry and then cleared, so domains added later start
+	// from the beginning rather than from another site's cursor.
+	SinceID int                     `json:"sinceID,omitempty"`
+	Domains map[string]*DomainState `json:"domains,omitempty"`
+}
+
+// DomainState is the fetch cursor of a single domain along with the outcome of its recent runs.
+type DomainState struct {
+	SinceID     int       `json:"sinceID"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastAttempt time.Time `json:"lastAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+	// History holds the most recent runs, oldest first, bounded by HistoryLimit.
+	History []Run `json:"history,omitempty"`
+	// Mastodon maps the Mastodon statuses imported for the domain to the wm-ids of the interactions seen on them, so
+	// they aren't imported again.
+	Mastodon map[string][]int `json:"mastodon,omitempty"`
+}
+
+// Run records the outcome of fetching one domain.
+type Run struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Fetched  int       `json:"fetched"`
+	New      int       `json:"new"`
+	Updated  int       `json:"updated"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// SinceIDFor returns the cursor for domain, migrating the legacy cursor into it when it is the first domain.
+func (s *State) SinceIDFor(domain string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.domain(domain).SinceID
+}
+
+// SetSinceID records the cursor for domain.
+func (s *State) SetSinceID(domain string, sinceID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.domain(domain).SinceID = sinceID
+}
+
+// MastodonSeen returns a copy of the wm-ids seen per Mastodon status of domain.
+func (s *State) MastodonSeen(domain string) map[string][]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if domainState, ok := s.Domains[domain]; ok {
+		return maps.Clone(domainState.Mastodon)
+	}
+	return nil
+}
+
+// SetMastodonSeen records the wm-ids seen per Mastodon status of domain, replacing those recorded before.
+func (s *State) SetMastodonSeen(domain string, seen map[string][]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.domain(domain).Mastodon = seen
+}
+
+// ResetSinceIDs sets the cursor of every domain, and the legacy cursor, back to zero so the next fetch starts over.
+func (s *State) ResetSinceIDs() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, domainState := range s.Domains {
+		domainState.SinceID = 0
+	}
+	s.SinceID = 0
+}
+
+// RecordRun appends run to the history of domain and updates its last attempt, success and error.
+func (s *State) RecordRun(domain string, run Run) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	domainState := s.domain(domain)
+	domainState.LastAttempt = run.Finished
+	domainState.LastError = run.Error
+	if run.Error == "" {
+		domainState.LastSuccess = run.Finished
+	}
+	domainState.History = append(domainState.History, run)
+	if len(domainState.History) > HistoryLimit {
+		domainState.History = domainState.History[len(domainState.History)-HistoryLimit:]
+	}
+}
+
+// Domain returns a copy of the state of domain. A domain without an entry yet has the legacy cursor it would migrate.
+func (s *State) Domain(domain string) DomainState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	domainState, ok := s.Domains[domain]
+	if !ok {
+		if len(s.Domains) > 0 {
+			return DomainState{}
+		}
+		return DomainState{SinceID: s.SinceID}
+	}
+	copied := *domainState
+	copied.History = append([]Run(nil), domainState.History...)
+	copied.Mastodon = maps.Clone(domainState.Mastodon)
+	return copied
+}
+
+// DomainNames returns the domains with state in sorted order.
+func (s *State) DomainNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.Domains))
+	for name := range s.Domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// domain returns the entry for domain, creating it if needed. The first entry created takes over the legacy cursor,
+// which is cleared once any entry exists. The caller must hold the write lock.
+func (s *State) domain(domain string) *DomainState {
+	if s.Domains == nil {
+		s.Domains = map[string]*DomainState{}
+	}
+	if _, ok := s.Domains[domain]; !ok {
+		domainState := &DomainState{}
+		if len(s.Domains) == 0 {
+			domainState.SinceID = s.SinceID
+		}
+		s.Domains[domain] = domainState
+		s.SinceID = 0
+	}
+	return s.Domains[domain]
 }
 
 //===  Bindings for tests
@@ -53,6 +201,9 @@ func ReadState(stateFilePath string) (*State, error) {
 		if err != nil {
 			return &fetchState, fmt.Errorf("error parsing stateFile: %v", err.Error())
 		}
+		if fetchState.Version > SchemaVersion {
+			return &State{}, fmt.Errorf("state file has version %d, this build supports up to %d", fetchState.Version, SchemaVersion)
+		}
 	}
 
 	return &fetchState, nil
@@ -62,6 +213,7 @@ func WriteState(filepath string, s *State) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.Version = SchemaVersion
 	// Marshal the updated state-file back to JSON
 	data, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
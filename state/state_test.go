@@ -6,6 +6,7 @@ import (
 	"io/fs"
 	"os"
 	"testing"
+	"time"
 )
 
 // MockFileWriter is a custom mock for testing.
@@ -153,3 +154,143 @@ func TestWriteState(t *testing.T) {
 		})
 	})
 }
+
+func TestState_SinceIDFor(t *testing.T) {
+	Convey("Given a state file written before cursors were kept per domain", t, func() {
+		state := &State{SinceID: 42}
+
+		Convey("The first domain starts from the legacy cursor, which is then cleared", func() {
+			So(state.Domain("example.com").SinceID, ShouldEqual, 42)
+			So(state.SinceIDFor("example.com"), ShouldEqual, 42)
+			So(state.SinceID, ShouldEqual, 0)
+		})
+
+		Convey("A domain added after the migration starts at 0", func() {
+			state.SetSinceID("example.com", 50)
+			So(state.SinceIDFor("example.com"), ShouldEqual, 50)
+			So(state.Domain("other.example.com").SinceID, ShouldEqual, 0)
+			So(state.SinceIDFor("other.example.com"), ShouldEqual, 0)
+		})
+
+		Convey("Recording a run or Mastodon interactions migrates the legacy cursor just the same", func() {
+			state.RecordRun("example.com", Run{Finished: time.Now()})
+			state.SetMastodonSeen("other.example.com", map[string][]int{})
+			So(state.SinceIDFor("example.com"), ShouldEqual, 42)
+			So(state.SinceIDFor("other.example.com"), ShouldEqual, 0)
+		})
+
+		Convey("A domain keeps the cursor it migrated when other domains record theirs", func() {
+			So(state.SinceIDFor("example.com"), ShouldEqual, 42)
+			state.SetSinceID("other.example.com", 0)
+			So(state.Domain("example.com").SinceID, ShouldEqual, 42)
+		})
+	})
+
+	Convey("Given per-domain cursors", t, func() {
+		mockReader := &MockFileReader{
+			WantedData: []byte(`{"domains":{"a.example":{"sinceID":1},"b.example":{"sinceID":2}}}`),
+		}
+		ReadFileFunc = mockReader.ReadFile
+
+		state, err := ReadState("dummy_path")
+		So(err, ShouldBeNil)
+		So(state.SinceIDFor("a.example"), ShouldEqual, 1)
+		So(state.SinceIDFor("b.example"), ShouldEqual, 2)
+	})
+
+	Convey("Given a legacy cursor left behind next to per-domain cursors", t, func() {
+		mockReader := &MockFileReader{
+			WantedData: []byte(`{"sinceID":42,"domains":{"a.example":{"sinceID":1}}}`),
+		}
+		ReadFileFunc = mockReader.ReadFile
+
+		state, err := ReadState("dummy_path")
+		So(err, ShouldBeNil)
+		So(state.Domain("c.example").SinceID, ShouldEqual, 0)
+		So(state.SinceIDFor("c.example"), ShouldEqual, 0)
+		So(state.SinceID, ShouldEqual, 0)
+	})
+}
+
+func TestState_MastodonSeen(t *testing.T) {
+	Convey("Given a state file with the Mastodon interactions seen for a domain", t, func() {
+		mockReader := &MockFileReader{
+			WantedData: []byte(`{"domains":{"a.example":{"sinceID":1,"mastodon":{"https://social.example/@a/1":[-1,-2]}}}}`),
+		}
+		ReadFileFunc = mockReader.ReadFile
+
+		state, err := ReadState("dummy_path")
+		So(err, ShouldBeNil)
+		So(state.MastodonSeen("a.example"), ShouldResemble, map[string][]int{"https://social.example/@a/1": {-1, -2}})
+		So(state.MastodonSeen("b.example"), ShouldBeEmpty)
+
+		Convey("Recording what was seen replaces it", func() {
+			state.SetMastodonSeen("a.example", map[string][]int{"https://social.example/@a/2": {-3}})
+			So(state.MastodonSeen("a.example"), ShouldResemble, map[string][]int{"https://social.example/@a/2": {-3}})
+			So(state.SinceIDFor("a.example"), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestState_RecordRun(t *testing.T) {
+	Convey("Given a state", t, func() {
+		state := &State{}
+		started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+		Convey("Recording a successful run updates the last success and attempt", func() {
+			state.RecordRun("example.com", Run{Started: started, Finished: started.Add(time.Second), Fetched: 3, New: 2, Updated: 1})
+
+			domainState := state.Domain("example.com")
+			So(domainState.LastSuccess, ShouldEqual, started.Add(time.Second))
+			So(domainState.LastAttempt, ShouldEqual, started.Add(time.Second))
+			So(domainState.LastError, ShouldBeEmpty)
+			So(domainState.History, ShouldHaveLength, 1)
+			So(domainState.History[0].New, ShouldEqual, 2)
+		})
+
+		Convey("Recording a failed run keeps the last success and records the error", func() {
+			state.RecordRun("example.com", Run{Started: started, Finished: started})
+			state.RecordRun("example.com", Run{Started: started.Add(time.Hour), Finished: started.Add(time.Hour), Error: "unauthorized"})
+
+			domainState := state.Domain("example.com")
+			So(domainState.LastSuccess, ShouldEqual, started)
+			So(domainState.LastAttempt, ShouldEqual, started.Add(time.Hour))
+			So(domainState.LastError, ShouldEqual, "unauthorized")
+		})
+
+		Convey("The history is bounded, keeping the newest runs", func() {
+			for i := 0; i < HistoryLimit+5; i++ {
+				state.RecordRun("example.com", Run{Fetched: i})
+			}
+
+			history := state.Domain("example.com").History
+			So(history, ShouldHaveLength, HistoryLimit)
+			So(history[len(history)-1].Fetched, ShouldEqual, HistoryLimit+4)
+		})
+
+		Convey("Domain returns a copy that doesn't change with the state", func() {
+			state.RecordRun("example.com", Run{Fetched: 1})
+			domainState := state.Domain("example.com")
+			state.RecordRun("example.com", Run{Fetched: 2})
+			So(domainState.History, ShouldHaveLength, 1)
+			So(state.DomainNames(), ShouldResemble, []string{"example.com"})
+		})
+	})
+}
+
+func TestState_Version(t *testing.T) {
+	Convey("Writing a state stamps the current schema version", t, func() {
+		mockWriter := &MockFileWriter{}
+		WriteFileFunc = mockWriter.WriteFile
+
+		So(WriteState("dummy_path", &State{}), ShouldBeNil)
+		So(string(mockWriter.Data), ShouldContainSubstring, fmt.Sprintf("\"version\": %d", SchemaVersion))
+	})
+
+	Convey("Reading a state written by a newer version is an error", t, func() {
+		ReadFileFunc = (&MockFileReader{WantedData: []byte(fmt.Sprintf(`{"version":%d}`, SchemaVersion+1))}).ReadFile
+
+		_, err := ReadState("dummy_path")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -7,7 +7,9 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
+	"github.com/blbecker/webmentionR/secret"
 	"github.com/charmbracelet/log"
 )
 
@@ -18,14 +20,33 @@ type Client struct {
 	Token    string
 	SinceID  int
 	PageSize int
-	page     int
+	// HTTPClient sends the API requests, http.DefaultClient when nil. Long-lived callers share one to reuse
+	// connections between polls.
+	HTTPClient *http.Client
+	// RequestObserver, when set, is told the latency and outcome of every API request.
+	RequestObserver RequestObserver
+	page            int
 }
 
 type Getter interface {
 	GetMentions() (*Response, error)
 }
 
+// RequestObserver is notified of every request a Client sends to the API.
+type RequestObserver interface {
+	ObserveRequest(domain string, duration time.Duration, err error)
+}
+
 func (client *Client) GetMentions() (*Response, error) {
+	started := time.Now()
+	result, err := client.getMentions()
+	if client.RequestObserver != nil {
+		client.RequestObserver.ObserveRequest(client.Domain, time.Since(started), err)
+	}
+	return result, err
+}
+
+func (client *Client) getMentions() (*Response, error) {
 	// Define query parameters
 	params := url.Values{}
 	params.Add("domain", client.Domain)
@@ -37,11 +58,16 @@ func (client *Client) GetMentions() (*Response, error) {
 	// Construct the full URL with query parameters
 	requestURL := fmt.Sprintf("%s?%s", BaseUrl, params.Encode())
 
-	log.Debug("Querying api", "url", requestURL, "params", params)
+	log.Debug("Querying api", "url", secret.RedactString(requestURL, url.QueryEscape(client.Token)))
 	// Send the GET request
-	resp, err := http.Get(requestURL)
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Get(requestURL)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching webmentions: %v", err.Error())
+		// Transport errors embed the request URL, which carries the token
+		return nil, fmt.Errorf("error fetching webmentions: %v", secret.RedactString(err.Error(), url.QueryEscape(client.Token)))
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
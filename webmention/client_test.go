@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-faker/faker/v4"
 
@@ -76,5 +77,50 @@ func TestClient_GetMentions(t *testing.T) {
 			So(err, ShouldNotBeNil)
 			So(result, ShouldBeNil)
 		})
+
+		Convey("When the request cannot be sent, the token is not leaked in the error", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			BaseUrl = server.URL
+			server.Close()
+
+			result, err := client.GetMentions()
+
+			So(err, ShouldNotBeNil)
+			So(result, ShouldBeNil)
+			So(err.Error(), ShouldNotContainSubstring, token)
+			So(err.Error(), ShouldContainSubstring, "REDACTED")
+		})
+
+		Convey("When a RequestObserver is set, it is told about every request", func() {
+			status := http.StatusOK
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(`{"children": []}`))
+			}))
+			defer server.Close()
+			BaseUrl = server.URL
+
+			observer := &recordingRequestObserver{}
+			client.RequestObserver = observer
+			_, err := client.GetMentions()
+			So(err, ShouldBeNil)
+			status = http.StatusBadGateway
+			_, err = client.GetMentions()
+			So(err, ShouldNotBeNil)
+
+			So(observer.domains, ShouldResemble, []string{domain, domain})
+			So(observer.errs[0], ShouldBeNil)
+			So(observer.errs[1], ShouldNotBeNil)
+		})
 	})
 }
+
+type recordingRequestObserver struct {
+	domains []string
+	errs    []error
+}
+
+func (o *recordingRequestObserver) ObserveRequest(domain string, _ time.Duration, err error) {
+	o.domains = append(o.domains, domain)
+	o.errs = append(o.errs, err)
+}
@@ -0,0 +1,135 @@
+package webmention
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is published on a Bus as a run progresses. It is one of RunStarted, PageFetched, MentionReceived,
+// TargetPersisted, RunFinished or Error.
+type Event interface {
+	event()
+}
+
+// RunStarted is published before the first page of a domain is requested.
+type RunStarted struct {
+	Domain  string
+	SinceID int
+	Time    time.Time
+}
+
+// PageFetched is published for every page the API returned, including the final empty one.
+type PageFetched struct {
+	Domain   string
+	Page     int
+	Mentions int
+}
+
+// MentionReceived is published for every mention the API returned, before it is persisted.
+type MentionReceived struct {
+	Domain  string
+	Mention Mention
+}
+
+// TargetPersisted is published once a target's mentions were saved.
+type TargetPersisted struct {
+	Domain string
+	Stats  PersistStats
+}
+
+// RunFinished is published when a domain's run ended, successfully or not.
+type RunFinished struct {
+	Domain   string
+	Fetched  int
+	New      int
+	Updated  int
+	Duration time.Duration
+	Err      error
+}
+
+// Error is published when a stage of a run failed: fetch, hold, private, persist, hooks or notify.
+type Error struct {
+	Domain string
+	Stage  string
+	Err    error
+}
+
+func (RunStarted) event()      {}
+func (PageFetched) event()     {}
+func (MentionReceived) event() {}
+func (TargetPersisted) event() {}
+func (RunFinished) event()     {}
+func (Error) event()           {}
+
+// Subscriber handles the events published on a Bus.
+type Subscriber interface {
+	Handle(event Event)
+}
+
+// SubscriberFunc adapts a function to a Subscriber.
+type SubscriberFunc func(event Event)
+
+func (f SubscriberFunc) Handle(event Event) {
+	f(event)
+}
+
+// Bus delivers every published event to its subscribers, synchronously and in the order they subscribed. Domain
+// names the domain the workers publishing on the bus are working for.
+type Bus struct {
+	Domain      string
+	mu          sync.RWMutex
+	subscribers []Subscriber
+}
+
+// NewBus returns a Bus for domain without subscribers.
+func NewBus(domain string) *Bus {
+	return &Bus{Domain: domain}
+}
+
+func (b *Bus) Subscribe(subscriber Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, subscriber)
+}
+
+func (b *Bus) Publish(event Event) {
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+
+	for _, subscriber := range subscribers {
+		subscriber.Handle(event)
+	}
+}
+
+// ReceivedMentions adapts observer to a Subscriber updated with every MentionReceived.
+func ReceivedMentions(observer MentionObserver) Subscriber {
+	return SubscriberFunc(func(event Event) {
+		if received, ok := event.(MentionReceived); ok {
+			observer.Update(received.Mention)
+		}
+	})
+}
+
+// PersistedMentions adapts observer to a Subscriber updated with every mention each TargetPersisted persisted.
+func PersistedMentions(observer MentionObserver) Subscriber {
+	return SubscriberFunc(func(event Event) {
+		if persisted, ok := event.(TargetPersisted); ok {
+			for _, mention := range persisted.Stats.Persisted {
+				observer.Update(mention)
+			}
+		}
+	})
+}
+
+// StoredMentions adapts observer to a Subscriber updated with the mentions each TargetPersisted stored for the first
+// time.
+func StoredMentions(observer MentionObserver) Subscriber {
+	return SubscriberFunc(func(event Event) {
+		if persisted, ok := event.(TargetPersisted); ok {
+			for _, mention := range persisted.Stats.New {
+				observer.Update(mention)
+			}
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package webmention
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// eventRecorder subscribes to a bus and keeps every event it receives.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) Handle(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func TestBus(t *testing.T) {
+	Convey("Given a bus with two subscribers", t, func() {
+		bus := NewBus("example.com")
+		var order []string
+		bus.Subscribe(SubscriberFunc(func(Event) { order = append(order, "first") }))
+		bus.Subscribe(SubscriberFunc(func(Event) { order = append(order, "second") }))
+
+		Convey("Every event reaches every subscriber in subscription order", func() {
+			bus.Publish(RunStarted{Domain: bus.Domain})
+			bus.Publish(Error{Domain: bus.Domain, Stage: "fetch", Err: errors.New("unauthorized")})
+			So(order, ShouldResemble, []string{"first", "second", "first", "second"})
+		})
+	})
+}
+
+func TestMentionObserverAdapters(t *testing.T) {
+	Convey("Given a metrics observer", t, func() {
+		var observer MetricsObserver
+		stored := TargetPersisted{Stats: PersistStats{Persisted: []Mention{{WMID: 2}, {WMID: 3}, {WMID: 4}},
+			New: []Mention{{WMID: 2}}, Updated: []Mention{{WMID: 3}}}}
+
+		Convey("ReceivedMentions updates it with every received mention only", func() {
+			subscriber := ReceivedMentions(&observer)
+			subscriber.Handle(MentionReceived{Mention: Mention{WMID: 1}})
+			subscriber.Handle(stored)
+			So(observer.GetMetrics().UniqueMentions, ShouldResemble, []int{1})
+		})
+
+		Convey("PersistedMentions updates it with every persisted mention", func() {
+			subscriber := PersistedMentions(&observer)
+			subscriber.Handle(MentionReceived{Mention: Mention{WMID: 1}})
+			subscriber.Handle(stored)
+			So(observer.GetMetrics().UniqueMentions, ShouldResemble, []int{2, 3, 4})
+		})
+
+		Convey("StoredMentions updates it with newly stored mentions only", func() {
+			subscriber := StoredMentions(&observer)
+			subscriber.Handle(MentionReceived{Mention: Mention{WMID: 1}})
+			subscriber.Handle(stored)
+			So(observer.GetMetrics().UniqueMentions, ShouldResemble, []int{2})
+		})
+	})
+}
+
+func TestFetchWorker_Events(t *testing.T) {
+	Convey("DoFetch publishes every page and mention", t, func() {
+		recorder := &eventRecorder{}
+		bus := NewBus("example.com")
+		bus.Subscribe(recorder)
+		fetchWorker := FetchWorker{Bus: bus}
+		mentionChannel := make(chan Mention)
+		mockClient := &MockClient{mentions: []Mention{{WMID: 1}, {WMID: 2}}, pages: 2}
+
+		go func() {
+			_ = fetchWorker.DoFetch(context.Background(), mockClient, mentionChannel)
+		}()
+		for range mentionChannel {
+		}
+
+		var pages []PageFetched
+		received := 0
+		for _, event := range recorder.events {
+			switch event := event.(type) {
+			case PageFetched:
+				pages = append(pages, event)
+			case MentionReceived:
+				So(event.Domain, ShouldEqual, "example.com")
+				received++
+			}
+		}
+		So(received, ShouldEqual, 4)
+		So(pages, ShouldResemble, []PageFetched{
+			{Domain: "example.com", Page: 0, Mentions: 2},
+			{Domain: "example.com", Page: 1, Mentions: 2},
+			{Domain: "example.com", Page: 2, Mentions: 0},
+		})
+	})
+}
+
+func TestPersistenceWorker_Events(t *testing.T) {
+	Convey("DoPersist publishes what it saved", t, func() {
+		LoadFunc = func(string) ([]Mention, error) {
+			return []Mention{{WMID: 1, WMTarget: "https://example.com/post"}}, nil
+		}
+		SaveFunc = func(string, []Mention) error { return nil }
+		recorder := &eventRecorder{}
+		bus := NewBus("example.com")
+		bus.Subscribe(recorder)
+		persistenceWorker := PersistenceWorker{Bus: bus}
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		err := persistenceWorker.DoPersist([]Mention{
+			{WMID: 1, WMTarget: "https://example.com/post"},
+			{WMID: 2, WMTarget: "https://example.com/post"},
+		}, &wg)
+		So(err, ShouldBeNil)
+
+		So(recorder.events, ShouldHaveLength, 1)
+		persisted, ok := recorder.events[0].(TargetPersisted)
+		So(ok, ShouldBeTrue)
+		So(persisted.Domain, ShouldEqual, "example.com")
+		So(persisted.Stats.Target, ShouldEqual, "https://example.com/post")
+		So(persisted.Stats.New, ShouldHaveLength, 1)
+		So(persisted.Stats.Unchanged, ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,131 @@
+package webmention
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/net/html"
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultExcerptLength is the number of characters, counted as grapheme clusters, an excerpt is cut to by default.
+const DefaultExcerptLength = 280
+
+// Excerpt is a short plain-text version of a mention's content for comment layouts.
+type Excerpt struct {
+	Text string `json:"text"`
+	// Truncated is set when Text was cut short, in which case ReadMore links to the full mention on its source.
+	Truncated bool   `json:"truncated,omitempty"`
+	ReadMore  string `json:"read-more,omitempty"`
+}
+
+// blockTags separate the text of their content from the text around them.
+var blockTags = []string{"address", "article", "blockquote", "br", "dd", "div", "dl", "dt", "figcaption", "figure",
+	"footer", "h1", "h2", "h3", "h4", "h5", "h6", "header", "hr", "li", "ol", "p", "pre", "section", "table", "td",
+	"th", "tr", "ul"}
+
+// HTMLText converts an HTML fragment to plain text, leaving out scripts and styles.
+func HTMLText(fragment string) string {
+	var out strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(fragment))
+	skipping := 0
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			return out.String()
+		case html.TextToken:
+			if skipping == 0 {
+				out.Write(tokenizer.Text())
+			}
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if tag == "script" || tag == "style" {
+				if tokenType == html.EndTagToken {
+					skipping = max(skipping-1, 0)
+				} else if tokenType == html.StartTagToken {
+					skipping++
+				}
+			} else if slices.Contains(blockTags, tag) {
+				out.WriteString("\n")
+			}
+		}
+	}
+}
+
+// NormalizeText composes text to Unicode NFC and collapses every run of whitespace to a single space.
+func NormalizeText(text string) string {
+	return strings.Join(strings.Fields(norm.NFC.String(text)), " ")
+}
+
+// Truncate shortens text to at most length grapheme clusters, including the trailing ellipsis, so emoji and
+// combined characters are never split. It cuts at the last space when one is close enough to the limit. It reports
+// whether text was shortened.
+func Truncate(text string, length int) (string, bool) {
+	if length < 1 || uniseg.GraphemeClusterCount(text) <= length {
+		return text, false
+	}
+
+	var cut strings.Builder
+	lastSpace := -1
+	graphemes := uniseg.NewGraphemes(text)
+	for count := 0; count < length-1 && graphemes.Next(); count++ {
+		cluster := graphemes.Str()
+		if strings.TrimSpace(cluster) == "" {
+			lastSpace = cut.Len()
+		}
+		cut.WriteString(cluster)
+	}
+	shortened := cut.String()
+	// A word ending right at the cut is kept whole.
+	endsWord := graphemes.Next() && strings.TrimSpace(graphemes.Str()) == ""
+	if !endsWord && lastSpace > len(shortened)/2 {
+		shortened = shortened[:lastSpace]
+	}
+	shortened = strings.TrimRightFunc(shortened, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r) && r != ')' && r != '"'
+	})
+	return shortened + "…", true
+}
+
+// ExcerptContent adds an excerpt of at most Length characters of the content to every mention with content. The
+// text is taken from the content's text, or converted from its HTML when the text is empty.
+type ExcerptContent struct {
+	Length int
+}
+
+func (s ExcerptContent) Process(mention Mention) (Mention, Verdict) {
+	text := mention.Content.Text
+	if strings.TrimSpace(text) == "" {
+		text = HTMLText(mention.Content.HTML)
+	}
+	text = NormalizeText(text)
+	if text == "" {
+		mention.Excerpt = nil
+		return mention, Keep
+	}
+
+	length := s.Length
+	if length < 1 {
+		length = DefaultExcerptLength
+	}
+	excerpt := Excerpt{}
+	excerpt.Text, excerpt.Truncated = Truncate(text, length)
+	if excerpt.Truncated {
+		excerpt.ReadMore = mention.WMSource
+	}
+	mention.Excerpt = &excerpt
+	return mention, Keep
+}
+
+func (s ExcerptContent) Derives() Derived {
+	return Derived{Excerpt: true}
+}
+
+func (s ExcerptContent) String() string {
+	return "excerpt=" + strconv.Itoa(s.Length)
+}
@@ -0,0 +1,67 @@
+package webmention
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rivo/uniseg"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHTMLText(t *testing.T) {
+	Convey("HTML is converted to text with blocks separated and scripts left out", t, func() {
+		text := HTMLText(`<p>Hello <b>there</b>&amp; welcome</p><p>Second<br>line</p><script>alert(1)</script>`)
+		So(NormalizeText(text), ShouldEqual, "Hello there& welcome Second line")
+	})
+}
+
+func TestTruncate(t *testing.T) {
+	Convey("Given text", t, func() {
+		Convey("Short text is left alone", func() {
+			text, truncated := Truncate("short", 10)
+			So(text, ShouldEqual, "short")
+			So(truncated, ShouldBeFalse)
+		})
+
+		Convey("Long text is cut at a space and gets an ellipsis", func() {
+			text, truncated := Truncate("The quick brown fox jumps over the lazy dog", 20)
+			So(truncated, ShouldBeTrue)
+			So(text, ShouldEqual, "The quick brown fox…")
+		})
+
+		Convey("Emoji and combined characters are never split", func() {
+			family := "👨‍👩‍👧‍👦"
+			text, truncated := Truncate(strings.Repeat(family, 10), 4)
+			So(truncated, ShouldBeTrue)
+			So(text, ShouldEqual, strings.Repeat(family, 3)+"…")
+			So(uniseg.GraphemeClusterCount(text), ShouldEqual, 4)
+
+			text, _ = Truncate("café café café", 5)
+			So(text, ShouldEqual, "café…")
+		})
+	})
+}
+
+func TestExcerptContent(t *testing.T) {
+	Convey("Given an excerpt stage", t, func() {
+		stage := ExcerptContent{Length: 20}
+
+		Convey("Short text is excerpted whole, normalized", func() {
+			mention, verdict := stage.Process(Mention{Content: Content{Text: "  Nice\n\n post!  "}})
+			So(verdict, ShouldEqual, Keep)
+			So(mention.Excerpt, ShouldResemble, &Excerpt{Text: "Nice post!"})
+		})
+
+		Convey("Long content links to the source to read more", func() {
+			mention, _ := stage.Process(Mention{WMSource: "https://blog.example/reply",
+				Content: Content{HTML: "<p>The quick brown fox</p><p>jumps over the lazy dog</p>"}})
+			So(mention.Excerpt, ShouldResemble, &Excerpt{Text: "The quick brown fox…", Truncated: true,
+				ReadMore: "https://blog.example/reply"})
+		})
+
+		Convey("Mentions without content get no excerpt", func() {
+			mention, _ := stage.Process(Mention{WMProperty: "like-of"})
+			So(mention.Excerpt, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,159 @@
+package webmention
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// The networks a mention can originate from. Native mentions were sent by an IndieWeb site itself.
+const (
+	NetworkNative    = "native"
+	NetworkMastodon  = "mastodon"
+	NetworkFediverse = "fediverse"
+	NetworkBluesky   = "bluesky"
+	NetworkTwitter   = "twitter"
+	NetworkGitHub    = "github"
+	NetworkReddit    = "reddit"
+	NetworkFlickr    = "flickr"
+	NetworkInstagram = "instagram"
+	NetworkFacebook  = "facebook"
+)
+
+// The bridges that send mentions on behalf of silos.
+const (
+	BridgeBridgy    = "bridgy"
+	BridgeBridgyFed = "bridgy-fed"
+)
+
+var bridgeHosts = map[string]string{
+	"brid.gy":      BridgeBridgy,
+	"fed.brid.gy":  BridgeBridgyFed,
+	"ap.brid.gy":   BridgeBridgyFed,
+	"bsky.brid.gy": BridgeBridgyFed,
+	"web.brid.gy":  BridgeBridgyFed,
+}
+
+var siloHosts = map[string]string{
+	"bsky.app":           NetworkBluesky,
+	"twitter.com":        NetworkTwitter,
+	"mobile.twitter.com": NetworkTwitter,
+	"x.com":              NetworkTwitter,
+	"github.com":         NetworkGitHub,
+	"reddit.com":         NetworkReddit,
+	"old.reddit.com":     NetworkReddit,
+	"flickr.com":         NetworkFlickr,
+	"instagram.com":      NetworkInstagram,
+	"facebook.com":       NetworkFacebook,
+	"m.facebook.com":     NetworkFacebook,
+}
+
+// bridgySilos maps the silo names in Bridgy's source paths, /{type}/{silo}/..., to networks.
+var bridgySilos = map[string]string{
+	"mastodon":  NetworkMastodon,
+	"bluesky":   NetworkBluesky,
+	"twitter":   NetworkTwitter,
+	"github":    NetworkGitHub,
+	"reddit":    NetworkReddit,
+	"flickr":    NetworkFlickr,
+	"instagram": NetworkInstagram,
+	"facebook":  NetworkFacebook,
+}
+
+// mastodonPath matches the paths of Mastodon posts and profiles, on whichever instance. Plenty of personal sites use
+// the same paths, so it is only trusted for mentions relayed by a bridge or imported from Mastodon.
+var mastodonPath = regexp.MustCompile(`^/(@[^/]+(/\d+)?|users/[^/]+(/statuses/\d+)?)/?$`)
+
+// mastodonProtocol is the wm-protocol of the mentions imported from the Mastodon API.
+const mastodonProtocol = "mastodon"
+
+// Origin is where a mention was posted: its network, the bridge that relayed it, if any, and the permalink of the
+// original post.
+type Origin struct {
+	Network   string
+	Bridge    string
+	Permalink string
+}
+
+// ClassifyOrigin tells the network of mention from its wm-source, URL and author URL.
+func ClassifyOrigin(mention Mention) Origin {
+	source, _ := url.Parse(mention.WMSource)
+	origin := Origin{Permalink: mention.URL}
+	if source != nil {
+		origin.Bridge = bridgeHosts[bareHost(source)]
+	}
+	permalink, _ := url.Parse(origin.Permalink)
+	if origin.Bridge == BridgeBridgyFed && source != nil && (permalink == nil || mention.URL == "" ||
+		bridgeHosts[bareHost(permalink)] != "") {
+		// Bridgy Fed sources embed the original post, as in /r/https://instance.example/@name/1.
+		if _, embedded, ok := strings.Cut(source.Path, "/r/"); ok {
+			origin.Permalink = embedded
+			permalink, _ = url.Parse(embedded)
+		}
+	}
+	if origin.Permalink == "" {
+		origin.Permalink = mention.WMSource
+		permalink = source
+	}
+
+	if origin.Bridge == BridgeBridgy && source != nil {
+		if segments := strings.Split(strings.Trim(source.Path, "/"), "/"); len(segments) > 1 {
+			if network, ok := bridgySilos[segments[1]]; ok {
+				origin.Network = network
+				return origin
+			}
+		}
+	}
+	if permalink != nil {
+		if network, ok := siloHosts[bareHost(permalink)]; ok {
+			origin.Network = network
+			return origin
+		}
+		if permalink.Scheme == "at" {
+			origin.Network = NetworkBluesky
+			return origin
+		}
+	}
+	author, _ := url.Parse(mention.Author.URL)
+	relayed := origin.Bridge != "" || mention.WMProtocol == mastodonProtocol
+	if relayed && ((permalink != nil && mastodonPath.MatchString(permalink.Path)) ||
+		(author != nil && mastodonPath.MatchString(author.Path))) {
+		origin.Network = NetworkMastodon
+		return origin
+	}
+	switch {
+	case source != nil && bareHost(source) == "bsky.brid.gy":
+		origin.Network = NetworkBluesky
+	case origin.Bridge != "":
+		origin.Network = NetworkFediverse
+	default:
+		origin.Network = NetworkNative
+	}
+	return origin
+}
+
+// IsBridgeHost tells whether host is one of a bridge, which relays the mentions of many authors.
+func IsBridgeHost(host string) bool {
+	return bridgeHosts[strings.TrimPrefix(strings.ToLower(host), "www.")] != ""
+}
+
+func bareHost(parsed *url.URL) string {
+	return strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+}
+
+// ClassifyNetwork sets the network, bridge and permalink of every mention.
+type ClassifyNetwork struct{}
+
+func (ClassifyNetwork) Process(mention Mention) (Mention, Verdict) {
+	origin := ClassifyOrigin(mention)
+	mention.Network, mention.Bridge, mention.Permalink = origin.Network, origin.Bridge, origin.Permalink
+	return mention, Keep
+}
+
+func (ClassifyNetwork) Derives() Derived {
+	return Derived{Network: true}
+}
+
+func (ClassifyNetwork) String() string {
+	return "classify-network"
+}
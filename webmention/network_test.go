@@ -0,0 +1,91 @@
+package webmention
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClassifyOrigin(t *testing.T) {
+	Convey("Mentions are classified by the network they were posted on", t, func() {
+		cases := []struct {
+			mention Mention
+			want    Origin
+		}{
+			{
+				Mention{WMSource: "https://blog.example/reply", URL: "https://blog.example/reply"},
+				Origin{Network: NetworkNative, Permalink: "https://blog.example/reply"},
+			},
+			{
+				Mention{WMSource: "https://blog.example/reply"},
+				Origin{Network: NetworkNative, Permalink: "https://blog.example/reply"},
+			},
+			{
+				Mention{WMSource: "https://brid.gy/repost/mastodon/@ada@mastodon.social/1/2",
+					URL: "https://mastodon.social/@ada/2#reblogged-by-3"},
+				Origin{Network: NetworkMastodon, Bridge: BridgeBridgy, Permalink: "https://mastodon.social/@ada/2#reblogged-by-3"},
+			},
+			{
+				Mention{WMSource: "https://brid.gy/comment/bluesky/did:plc:abc/1/2",
+					URL: "https://bsky.app/profile/ada.example/post/2"},
+				Origin{Network: NetworkBluesky, Bridge: BridgeBridgy, Permalink: "https://bsky.app/profile/ada.example/post/2"},
+			},
+			{
+				Mention{WMSource: "https://brid.gy/like/unknown/x/1/2", URL: "https://github.com/ada/repo/issues/1"},
+				Origin{Network: NetworkGitHub, Bridge: BridgeBridgy, Permalink: "https://github.com/ada/repo/issues/1"},
+			},
+			{
+				Mention{WMSource: "https://fed.brid.gy/r/https://hachyderm.io/@grace/42"},
+				Origin{Network: NetworkMastodon, Bridge: BridgeBridgyFed, Permalink: "https://hachyderm.io/@grace/42"},
+			},
+			{
+				Mention{WMSource: "https://fed.brid.gy/r/https://pixel.example/p/grace/42",
+					Author: Author{URL: "https://pixel.example/users/grace"}},
+				Origin{Network: NetworkMastodon, Bridge: BridgeBridgyFed, Permalink: "https://pixel.example/p/grace/42"},
+			},
+			{
+				Mention{WMSource: "https://fed.brid.gy/r/https://lemmy.example/post/42"},
+				Origin{Network: NetworkFediverse, Bridge: BridgeBridgyFed, Permalink: "https://lemmy.example/post/42"},
+			},
+			{
+				Mention{WMSource: "https://bsky.brid.gy/convert/web/at://did:plc:abc/app.bsky.feed.post/1"},
+				Origin{Network: NetworkBluesky, Bridge: BridgeBridgyFed,
+					Permalink: "https://bsky.brid.gy/convert/web/at://did:plc:abc/app.bsky.feed.post/1"},
+			},
+			{
+				Mention{WMSource: "https://blog.example/reply", Author: Author{URL: "https://mastodon.social/@ada"}},
+				Origin{Network: NetworkNative, Permalink: "https://blog.example/reply"},
+			},
+			{
+				Mention{WMSource: "https://blog.example/@ada/123", URL: "https://blog.example/@ada/123"},
+				Origin{Network: NetworkNative, Permalink: "https://blog.example/@ada/123"},
+			},
+			{
+				Mention{WMSource: "https://blog.example/users/ada/statuses/123"},
+				Origin{Network: NetworkNative, Permalink: "https://blog.example/users/ada/statuses/123"},
+			},
+			{
+				Mention{WMSource: "https://social.example/@ada/123", WMProtocol: "mastodon"},
+				Origin{Network: NetworkMastodon, Permalink: "https://social.example/@ada/123"},
+			},
+		}
+		for _, c := range cases {
+			So(ClassifyOrigin(c.mention), ShouldResemble, c.want)
+		}
+	})
+
+	Convey("The classify-network stage stores the origin on the mention", t, func() {
+		mention, verdict := ClassifyNetwork{}.Process(Mention{WMSource: "https://brid.gy/comment/twitter/ada/1/2",
+			URL: "https://twitter.com/ada/status/2"})
+		So(verdict, ShouldEqual, Keep)
+		So(mention.Network, ShouldEqual, NetworkTwitter)
+		So(mention.Bridge, ShouldEqual, BridgeBridgy)
+		So(mention.Permalink, ShouldEqual, "https://twitter.com/ada/status/2")
+	})
+
+	Convey("Bridge hosts are recognized whatever their case", t, func() {
+		So(IsBridgeHost("brid.gy"), ShouldBeTrue)
+		So(IsBridgeHost("Fed.Brid.gy"), ShouldBeTrue)
+		So(IsBridgeHost("friend.example"), ShouldBeFalse)
+	})
+}
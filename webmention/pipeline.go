@@ -0,0 +1,215 @@
+package webmention
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Verdict is a Stage's decision about a mention.
+type Verdict int
+
+const (
+	// Keep passes the mention on to the next stage and, after the last one, to persistence.
+	Keep Verdict = iota
+	// Drop discards the mention.
+	Drop
+	// Hold keeps the mention out of the published files until it is reviewed.
+	Hold
+)
+
+// Stage filters, transforms or enriches a mention between fetch and persist.
+type Stage interface {
+	Process(mention Mention) (Mention, Verdict)
+}
+
+// StageFunc adapts a function to a Stage.
+type StageFunc func(mention Mention) (Mention, Verdict)
+
+func (f StageFunc) Process(mention Mention) (Mention, Verdict) {
+	return f(mention)
+}
+
+// Pipeline runs its stages in order.
+type Pipeline []Stage
+
+// Process passes mention through every stage until one drops or holds it, returning the mention as the last stage left
+// it and the stage that decided, if any.
+func (p Pipeline) Process(mention Mention) (Mention, Verdict, Stage) {
+	for _, stage := range p {
+		var verdict Verdict
+		mention, verdict = stage.Process(mention)
+		if verdict != Keep {
+			return mention, verdict, stage
+		}
+	}
+	return mention, Keep, nil
+}
+
+// Derived tells which of the fields stages derive from a mention's source, rather than take from it, a pipeline
+// sets. Merging a fetched mention into a stored one takes those fields from the fetched mention even when they are
+// empty, e.g. when the spam score dropped to 0, and keeps the stored ones otherwise.
+type Derived struct {
+	Hidden   bool
+	Spam     bool
+	Excerpt  bool
+	Network  bool
+	AuthorID bool
+}
+
+// Deriver is implemented by stages that set derived fields of every mention they keep.
+type Deriver interface {
+	Derives() Derived
+}
+
+// Derived returns the derived fields set by the stages of the pipeline.
+func (p Pipeline) Derived() Derived {
+	var derived Derived
+	for _, stage := range p {
+		deriver, ok := stage.(Deriver)
+		if !ok {
+			continue
+		}
+		stageDerived := deriver.Derives()
+		derived.Hidden = derived.Hidden || stageDerived.Hidden
+		derived.Spam = derived.Spam || stageDerived.Spam
+		derived.Excerpt = derived.Excerpt || stageDerived.Excerpt
+		derived.Network = derived.Network || stageDerived.Network
+		derived.AuthorID = derived.AuthorID || stageDerived.AuthorID
+	}
+	return derived
+}
+
+// DropPrivate drops mentions flagged wm-private.
+type DropPrivate struct{}
+
+func (DropPrivate) Process(mention Mention) (Mention, Verdict) {
+	if mention.WMPrivate {
+		return mention, Drop
+	}
+	return mention, Keep
+}
+
+func (DropPrivate) String() string {
+	return "drop-private"
+}
+
+// DropProperty drops mentions whose wm-property is one of Properties, e.g. like-of.
+type DropProperty struct {
+	Properties []string
+}
+
+func (s DropProperty) Process(mention Mention) (Mention, Verdict) {
+	if slices.Contains(s.Properties, mention.WMProperty) {
+		return mention, Drop
+	}
+	return mention, Keep
+}
+
+func (s DropProperty) String() string {
+	return "drop-property=" + strings.Join(s.Properties, ",")
+}
+
+// RewriteTarget replaces the From prefix of wm-target with To, e.g. after a site moved to a new domain. Mentions
+// are grouped into files by their rewritten target.
+type RewriteTarget struct {
+	From string
+	To   string
+}
+
+func (s RewriteTarget) Process(mention Mention) (Mention, Verdict) {
+	if rest, ok := strings.CutPrefix(mention.WMTarget, s.From); ok {
+		mention.WMTarget = s.To + rest
+	}
+	return mention, Keep
+}
+
+func (s RewriteTarget) String() string {
+	return "rewrite-target=" + s.From + "=>" + s.To
+}
+
+// TrimContent trims surrounding whitespace from the content and, when MaxLength is positive, shortens the text to
+// MaxLength runes. The HTML of a shortened mention is dropped as it can't be cut safely.
+type TrimContent struct {
+	MaxLength int
+}
+
+func (s TrimContent) Process(mention Mention) (Mention, Verdict) {
+	mention.Content.Text = strings.TrimSpace(mention.Content.Text)
+	mention.Content.HTML = strings.TrimSpace(mention.Content.HTML)
+	if runes := []rune(mention.Content.Text); s.MaxLength > 0 && len(runes) > s.MaxLength {
+		mention.Content.Text = strings.TrimSpace(string(runes[:s.MaxLength-1])) + "…"
+		mention.Content.HTML = ""
+	}
+	return mention, Keep
+}
+
+func (s TrimContent) String() string {
+	return "trim-content=" + strconv.Itoa(s.MaxLength)
+}
+
+// ParseStage builds a built-in stage from its spec:
+//
+//	drop-private
+//	drop-property=like-of,repost-of
+//	rewrite-target=https://old.example/=>https://new.example/
+//	trim-content[=max-length]
+//	excerpt[=length]
+func ParseStage(spec string) (Stage, error) {
+	name, arg, hasArg := strings.Cut(strings.TrimSpace(spec), "=")
+	switch name {
+	case "drop-private":
+		return DropPrivate{}, nil
+	case "drop-property":
+		var properties []string
+		for _, property := range strings.Split(arg, ",") {
+			if property = strings.TrimSpace(property); property != "" {
+				properties = append(properties, property)
+			}
+		}
+		if len(properties) == 0 {
+			return nil, fmt.Errorf("stage '%s' needs at least one wm-property", spec)
+		}
+		return DropProperty{Properties: properties}, nil
+	case "rewrite-target":
+		from, to, ok := strings.Cut(arg, "=>")
+		if !ok || from == "" {
+			return nil, fmt.Errorf("stage '%s' needs a rewrite of the form from=>to", spec)
+		}
+		return RewriteTarget{From: from, To: to}, nil
+	case "trim-content":
+		if !hasArg {
+			return TrimContent{}, nil
+		}
+		maxLength, err := strconv.Atoi(arg)
+		if err != nil || maxLength < 1 {
+			return nil, fmt.Errorf("stage '%s' needs a positive maximum length", spec)
+		}
+		return TrimContent{MaxLength: maxLength}, nil
+	case "excerpt":
+		if !hasArg {
+			return ExcerptContent{Length: DefaultExcerptLength}, nil
+		}
+		length, err := strconv.Atoi(arg)
+		if err != nil || length < 1 {
+			return nil, fmt.Errorf("stage '%s' needs a positive length", spec)
+		}
+		return ExcerptContent{Length: length}, nil
+	default:
+		return nil, fmt.Errorf("unknown stage '%s'", name)
+	}
+}
+
+// ParsePipeline builds a pipeline from stage specs, in order.
+func ParsePipeline(specs []string) (Pipeline, error) {
+	pipeline := make(Pipeline, 0, len(specs))
+	for _, spec := range specs {
+		stage, err := ParseStage(spec)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, stage)
+	}
+	return pipeline, nil
+}
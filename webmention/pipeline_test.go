@@ -0,0 +1,100 @@
+package webmention
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPipeline(t *testing.T) {
+	Convey("Given a pipeline", t, func() {
+		pipeline := Pipeline{
+			RewriteTarget{From: "http://old.example/", To: "https://new.example/"},
+			DropPrivate{},
+			DropProperty{Properties: []string{"like-of"}},
+		}
+
+		Convey("A kept mention is transformed by every stage", func() {
+			mention, verdict, stage := pipeline.Process(Mention{WMTarget: "http://old.example/post", WMProperty: "in-reply-to"})
+			So(verdict, ShouldEqual, Keep)
+			So(stage, ShouldBeNil)
+			So(mention.WMTarget, ShouldEqual, "https://new.example/post")
+		})
+
+		Convey("A dropped mention reports the stage that dropped it", func() {
+			mention, verdict, stage := pipeline.Process(Mention{WMTarget: "http://old.example/post", WMProperty: "like-of"})
+			So(verdict, ShouldEqual, Drop)
+			So(stage, ShouldResemble, DropProperty{Properties: []string{"like-of"}})
+			So(mention.WMTarget, ShouldEqual, "https://new.example/post")
+
+			_, verdict, stage = pipeline.Process(Mention{WMPrivate: true})
+			So(verdict, ShouldEqual, Drop)
+			So(stage, ShouldEqual, DropPrivate{})
+		})
+
+		Convey("Custom stages compose with the built-ins", func() {
+			enrich := StageFunc(func(mention Mention) (Mention, Verdict) {
+				mention.Name = "enriched"
+				return mention, Keep
+			})
+			mention, verdict, _ := append(pipeline, enrich).Process(Mention{})
+			So(verdict, ShouldEqual, Keep)
+			So(mention.Name, ShouldEqual, "enriched")
+		})
+
+		Convey("The derived fields are those set by the stages deriving them", func() {
+			So(Pipeline(nil).Derived(), ShouldResemble, Derived{})
+			So(Pipeline{ClassifyNetwork{}, TrimContent{}, ExcerptContent{}}.Derived(), ShouldResemble,
+				Derived{Network: true, Excerpt: true})
+		})
+
+		Convey("An empty pipeline keeps everything unchanged", func() {
+			mention, verdict, _ := Pipeline(nil).Process(Mention{WMID: 1})
+			So(verdict, ShouldEqual, Keep)
+			So(mention, ShouldResemble, Mention{WMID: 1})
+		})
+	})
+}
+
+func TestTrimContent(t *testing.T) {
+	Convey("Content is trimmed and long text shortened without its HTML", t, func() {
+		mention, _ := TrimContent{}.Process(Mention{Content: Content{Text: "  hello \n", HTML: " <p>hello</p> "}})
+		So(mention.Content, ShouldResemble, Content{Text: "hello", HTML: "<p>hello</p>"})
+
+		mention, _ = TrimContent{MaxLength: 6}.Process(Mention{Content: Content{Text: "héllo wörld", HTML: "<p>héllo wörld</p>"}})
+		So(mention.Content.Text, ShouldEqual, "héllo…")
+		So(mention.Content.HTML, ShouldBeEmpty)
+	})
+}
+
+func TestParsePipeline(t *testing.T) {
+	Convey("Stage specs are parsed in order", t, func() {
+		pipeline, err := ParsePipeline([]string{
+			"drop-private",
+			"drop-property=like-of, repost-of",
+			"rewrite-target=http://old.example/=>https://new.example/",
+			"trim-content=280",
+			"trim-content",
+			"excerpt=140",
+			"excerpt",
+		})
+		So(err, ShouldBeNil)
+		So(pipeline, ShouldResemble, Pipeline{
+			DropPrivate{},
+			DropProperty{Properties: []string{"like-of", "repost-of"}},
+			RewriteTarget{From: "http://old.example/", To: "https://new.example/"},
+			TrimContent{MaxLength: 280},
+			TrimContent{},
+			ExcerptContent{Length: 140},
+			ExcerptContent{Length: DefaultExcerptLength},
+		})
+	})
+
+	Convey("Invalid specs are errors", t, func() {
+		for _, spec := range []string{"drop-spam", "drop-property=", "rewrite-target=https://old.example/", "trim-content=0",
+			"excerpt=short"} {
+			_, err := ParsePipeline([]string{spec})
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
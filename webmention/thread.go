@@ -0,0 +1,115 @@
+package webmention
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Thread is a node in a conversation tree. Roots are mentions replying to our own post (or to something we
+// don't have), and Replies holds the mentions whose in-reply-to points at this node's mention.
+type Thread struct {
+	Mention  Mention   `json:"mention"`
+	ParentID int       `json:"parent-id,omitempty"`
+	Depth    int       `json:"depth"`
+	Replies  []*Thread `json:"replies,omitempty"`
+}
+
+// BuildThreads links mentions into conversation trees by matching each mention's in-reply-to against the url and
+// wm-source of the other mentions. Roots and replies are ordered by WMID ascending so conversations read oldest
+// first. Reply cycles are broken by promoting the mention closing the cycle to a root.
+func BuildThreads(mentions []Mention) []*Thread {
+	nodes := make([]*Thread, len(mentions))
+	byURL := map[string]int{}
+	for i, mention := range mentions {
+		nodes[i] = &Thread{Mention: mention}
+		for _, u := range []string{mention.URL, mention.WMSource} {
+			key := threadKey(u)
+			if key == "" {
+				continue
+			}
+			// Prefer the earliest mention when several share a URL
+			if existing, ok := byURL[key]; !ok || mentions[existing].WMID > mention.WMID {
+				byURL[key] = i
+			}
+		}
+	}
+
+	parents := make([]int, len(mentions))
+	for i, mention := range mentions {
+		parents[i] = -1
+		if parent, ok := byURL[threadKey(mention.InReplyTo)]; ok && parent != i {
+			parents[i] = parent
+		}
+	}
+
+	// Break any cycles so every node is reachable from a root
+	for i := range parents {
+		seen := map[int]bool{i: true}
+		for p := parents[i]; p != -1; p = parents[p] {
+			if seen[p] {
+				parents[i] = -1
+				break
+			}
+			seen[p] = true
+		}
+	}
+
+	var roots []*Thread
+	for i, node := range nodes {
+		if parents[i] == -1 {
+			roots = append(roots, node)
+			continue
+		}
+		parent := nodes[parents[i]]
+		node.ParentID = parent.Mention.WMID
+		parent.Replies = append(parent.Replies, node)
+	}
+
+	sortThreads(roots, 0)
+	return roots
+}
+
+// sortThreads orders each level of the tree by WMID and assigns depths.
+func sortThreads(threads []*Thread, depth int) {
+	sort.Slice(threads, func(i, j int) bool {
+		return threads[i].Mention.WMID < threads[j].Mention.WMID
+	})
+	for _, thread := range threads {
+		thread.Depth = depth
+		sortThreads(thread.Replies, depth+1)
+	}
+}
+
+// threadKey normalizes a URL so that trivially different spellings of the same page match. The scheme and
+// fragment are ignored, the host is lower-cased and trailing slashes are trimmed.
+func threadKey(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return strings.TrimSuffix(rawURL, "/")
+	}
+	key := strings.ToLower(parsed.Host) + strings.TrimSuffix(parsed.Path, "/")
+	if parsed.RawQuery != "" {
+		key += "?" + parsed.RawQuery
+	}
+	return key
+}
+
+// SaveThreads writes the nested conversation trees to a JSON file.
+func SaveThreads(filepath string, threads []*Thread) error {
+	data, err := json.MarshalIndent(threads, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON: %w", err)
+	}
+
+	if err := WriteFileFunc(filepath, data, 0644); err != nil {
+		return fmt.Errorf("error writing to file: %v", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package webmention
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBuildThreads(t *testing.T) {
+	Convey("Given mentions replying to a post and to each other", t, func() {
+		mentions := []Mention{
+			{WMID: 4, URL: "https://c.example/reply-to-b", InReplyTo: "https://B.example/reply/#comment"},
+			{WMID: 3, URL: "https://b.example/reply", InReplyTo: "https://a.example/reply"},
+			{WMID: 2, URL: "https://other.example/reply", InReplyTo: "https://example.com/post"},
+			{WMID: 1, URL: "https://a.example/reply", InReplyTo: "https://example.com/post"},
+		}
+
+		threads := BuildThreads(mentions)
+
+		Convey("Then only direct replies to the post are roots, oldest first", func() {
+			So(threads, ShouldHaveLength, 2)
+			So(threads[0].Mention.WMID, ShouldEqual, 1)
+			So(threads[1].Mention.WMID, ShouldEqual, 2)
+			So(threads[0].Depth, ShouldEqual, 0)
+			So(threads[0].ParentID, ShouldEqual, 0)
+		})
+
+		Convey("Then replies to replies are nested with parent IDs and depths", func() {
+			So(threads[0].Replies, ShouldHaveLength, 1)
+			child := threads[0].Replies[0]
+			So(child.Mention.WMID, ShouldEqual, 3)
+			So(child.ParentID, ShouldEqual, 1)
+			So(child.Depth, ShouldEqual, 1)
+
+			So(child.Replies, ShouldHaveLength, 1)
+			grandchild := child.Replies[0]
+			So(grandchild.Mention.WMID, ShouldEqual, 4)
+			So(grandchild.ParentID, ShouldEqual, 3)
+			So(grandchild.Depth, ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given mentions replying to each other in a cycle", t, func() {
+		mentions := []Mention{
+			{WMID: 1, URL: "https://a.example/", InReplyTo: "https://b.example/"},
+			{WMID: 2, URL: "https://b.example/", InReplyTo: "https://a.example/"},
+		}
+
+		threads := BuildThreads(mentions)
+
+		Convey("Then the cycle is broken and every mention is kept", func() {
+			So(threads, ShouldHaveLength, 1)
+			So(threads[0].Replies, ShouldHaveLength, 1)
+		})
+	})
+
+	Convey("Given a mention replying to itself", t, func() {
+		mentions := []Mention{
+			{WMID: 1, URL: "https://a.example/", InReplyTo: "https://a.example/"},
+		}
+
+		threads := BuildThreads(mentions)
+
+		Convey("Then it is treated as a root", func() {
+			So(threads, ShouldHaveLength, 1)
+			So(threads[0].Replies, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestSaveThreads(t *testing.T) {
+	Convey("Given a SaveThreads function with a mock file writer", t, func() {
+		mockWriter := &MockFileWriter{}
+		WriteFileFunc = mockWriter.WriteFile
+
+		threads := BuildThreads([]Mention{
+			{WMID: 1, URL: "https://a.example/"},
+			{WMID: 2, URL: "https://b.example/", InReplyTo: "https://a.example/"},
+		})
+
+		Convey("When saving succeeds, the nested tree is written", func() {
+			err := SaveThreads("threads.json", threads)
+			So(err, ShouldBeNil)
+			So(mockWriter.TargetFilePath, ShouldEqual, "threads.json")
+			So(string(mockWriter.Data), ShouldContainSubstring, "\"parent-id\": 1")
+			So(string(mockWriter.Data), ShouldContainSubstring, "\"replies\"")
+		})
+
+		Convey("When the write fails, an error is returned", func() {
+			mockWriter.WantedErr = fmt.Errorf("disk full")
+			err := SaveThreads("threads.json", threads)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -1,11 +1,13 @@
 package webmention
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/charmbracelet/log"
 	"net/url"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -33,6 +35,19 @@ type Mention struct {
 	InReplyTo  string    `json:"in-reply-to" faker:"url"`
 	WMProperty string    `json:"wm-property" faker:"oneof: in-reply-to"`
 	WMPrivate  bool      `json:"wm-private"`
+	// Hidden marks a mention moderation kept on disk but that shouldn't be displayed.
+	Hidden bool `json:"hidden,omitempty"`
+	// SpamScore, from 0 to 100, and the SpamSignals that added up to it are kept for auditing when spam scoring is on.
+	SpamScore   int      `json:"spam-score,omitempty"`
+	SpamSignals []string `json:"spam-signals,omitempty"`
+	// Excerpt is set by the excerpt stage.
+	Excerpt *Excerpt `json:"excerpt,omitempty" faker:"-"`
+	// Network, Bridge and Permalink tell where the mention was originally posted, see ClassifyOrigin.
+	Network   string `json:"network,omitempty" faker:"-"`
+	Bridge    string `json:"bridge,omitempty" faker:"-"`
+	Permalink string `json:"permalink,omitempty" faker:"-"`
+	// AuthorID identifies the author across their URLs and photos, as listed in the authors index.
+	AuthorID string `json:"author-id,omitempty" faker:"-"`
 }
 
 type Author struct {
@@ -45,6 +60,8 @@ type Author struct {
 type Content struct {
 	HTML string `json:"html" faker:"paragraph"`
 	Text string `json:"text" faker:"paragraph"`
+	// RawHTML is the HTML as received, kept for auditing when sanitizing changed it and keeping it was asked for.
+	RawHTML string `json:"raw-html,omitempty" faker:"-"`
 }
 
 // GenerateSlug creates a slug based on the WMTarget URL
@@ -75,6 +92,30 @@ func LoadMentions(path string) ([]Mention, error) {
 	return mentions, nil
 }
 
+// ThreadsSuffix is the file name suffix of the conversation trees written next to each target's mentions file.
+const ThreadsSuffix = ".threads.json"
+
+// LoadAll loads every target's mentions file in destination, keyed by file path. Conversation tree files are skipped.
+func LoadAll(destination string) (map[string][]Mention, error) {
+	paths, err := filepath.Glob(filepath.Join(destination, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing mention files: %w", err)
+	}
+
+	mentionsByPath := map[string][]Mention{}
+	for _, path := range paths {
+		if strings.HasSuffix(path, ThreadsSuffix) {
+			continue
+		}
+		mentions, err := LoadMentions(path)
+		if err != nil {
+			return nil, fmt.Errorf("error loading %s: %w", path, err)
+		}
+		mentionsByPath[path] = mentions
+	}
+	return mentionsByPath, nil
+}
+
 func InsertMention(mentions []Mention, mention Mention) []Mention {
 	// Check if the mention already exists in the slice
 	for _, existingMention := range mentions {
@@ -94,6 +135,87 @@ func InsertMention(mentions []Mention, mention Mention) []Mention {
 	return mentions
 }
 
+// MergeResult describes how MergeMention changed a list of mentions.
+type MergeResult int
+
+const (
+	Unchanged MergeResult = iota
+	Inserted
+	Updated
+)
+
+// MergeMention inserts mention like InsertMention, but updates a stored mention with the same WMID when any of the
+// fields its source provides differ, e.g. after the source page was edited and re-sent. The fields stages derive from
+// the source, like the spam score, excerpt, network or author ID, are taken from mention when the stages that set them
+// ran, as told by derived, and where it has them otherwise; they don't count as a change on their own.
+func MergeMention(mentions []Mention, mention Mention, derived Derived) ([]Mention, MergeResult) {
+	for i, existingMention := range mentions {
+		if existingMention.WMID != mention.WMID {
+			continue
+		}
+		merged := mergeDerived(existingMention, mention, derived)
+		mentions[i] = merged
+		if sameMention(sourceFields(existingMention, merged), sourceFields(merged, existingMention)) {
+			return mentions, Unchanged
+		}
+		log.Infof("Mention with WMID %d changed, updating.", mention.WMID)
+		return mentions, Updated
+	}
+	return InsertMention(mentions, mention), Inserted
+}
+
+// mergeDerived returns mention with the derived fields no stage in derived set, and that it lacks, taken from stored.
+// A mirrored author photo is kept when mention comes with the remote one, as it does when mirroring it failed.
+func mergeDerived(stored, mention Mention, derived Derived) Mention {
+	if !derived.Hidden {
+		mention.Hidden = mention.Hidden || stored.Hidden
+	}
+	if !derived.Spam && mention.SpamScore == 0 && len(mention.SpamSignals) == 0 {
+		mention.SpamScore, mention.SpamSignals = stored.SpamScore, stored.SpamSignals
+	}
+	if !derived.Excerpt && mention.Excerpt == nil {
+		mention.Excerpt = stored.Excerpt
+	}
+	if !derived.Network && mention.Network == "" {
+		mention.Network, mention.Bridge, mention.Permalink = stored.Network, stored.Bridge, stored.Permalink
+	}
+	if !derived.AuthorID && mention.AuthorID == "" {
+		mention.AuthorID = stored.AuthorID
+	}
+	if localPhoto(stored.Author.Photo) && !localPhoto(mention.Author.Photo) {
+		mention.Author.Photo = stored.Author.Photo
+	}
+	return mention
+}
+
+// sourceFields returns mention without its derived fields, for comparing what its source provided. The author photo
+// is left out when either mention or other has a mirrored one, which replaced the photo the source gave.
+func sourceFields(mention, other Mention) Mention {
+	mention.Hidden = false
+	mention.SpamScore, mention.SpamSignals = 0, nil
+	mention.Excerpt = nil
+	mention.Network, mention.Bridge, mention.Permalink = "", "", ""
+	mention.AuthorID = ""
+	mention.Content.RawHTML = ""
+	if localPhoto(mention.Author.Photo) || localPhoto(other.Author.Photo) {
+		mention.Author.Photo = ""
+	}
+	return mention
+}
+
+// localPhoto tells whether photo is a path on the site itself, as mirrored author photos are.
+func localPhoto(photo string) bool {
+	parsed, err := url.Parse(photo)
+	return photo != "" && err == nil && parsed.Host == "" && parsed.Scheme == ""
+}
+
+// sameMention compares mentions by their JSON form, which ignores time zone representation differences.
+func sameMention(a, b Mention) bool {
+	aData, errA := json.Marshal(a)
+	bData, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(aData, bData)
+}
+
 // Save adds the current mention to a JSON file using provided io.Reader and io.Writer
 func Save(filepath string, mentions []Mention) error {
 	// Marshal the updated mentions list back to JSON
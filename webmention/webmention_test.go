@@ -150,6 +150,97 @@ func TestInsertMention(t *testing.T) {
 	})
 }
 
+func TestMergeMention(t *testing.T) {
+	Convey("Given a slice of mentions", t, func() {
+		mentions := []Mention{
+			{WMID: 5, Content: Content{Text: "five"}},
+			{WMID: 3, Content: Content{Text: "three"}},
+		}
+
+		Convey("A new mention is inserted in order", func() {
+			merged, result := MergeMention(mentions, Mention{WMID: 4}, Derived{})
+			So(result, ShouldEqual, Inserted)
+			So(merged, ShouldHaveLength, 3)
+			So(merged[1].WMID, ShouldEqual, 4)
+		})
+
+		Convey("An identical mention leaves the slice unchanged", func() {
+			merged, result := MergeMention(mentions, Mention{WMID: 3, Content: Content{Text: "three"}}, Derived{})
+			So(result, ShouldEqual, Unchanged)
+			So(merged, ShouldResemble, mentions)
+		})
+
+		Convey("A changed mention replaces the stored one", func() {
+			merged, result := MergeMention(mentions, Mention{WMID: 3, Content: Content{Text: "edited"}}, Derived{})
+			So(result, ShouldEqual, Updated)
+			So(merged, ShouldHaveLength, 2)
+			So(merged[1].Content.Text, ShouldEqual, "edited")
+		})
+	})
+
+	Convey("Given a stored mention with derived fields", t, func() {
+		stored := Mention{WMID: 3, Author: Author{Name: "Ann", Photo: "/avatars/0a1b2c.jpg"},
+			Content: Content{Text: "three"}, SpamScore: 20, SpamSignals: []string{"links"}, Excerpt: &Excerpt{Text: "three"},
+			Network: NetworkNative, Permalink: "https://ann.example/3", AuthorID: "a1"}
+
+		Convey("Re-fetching it without them leaves it unchanged and keeps them", func() {
+			fetched := Mention{WMID: 3, Author: Author{Name: "Ann", Photo: "https://ann.example/photo.jpg"},
+				Content: Content{Text: "three"}}
+			merged, result := MergeMention([]Mention{stored}, fetched, Derived{})
+			So(result, ShouldEqual, Unchanged)
+			So(merged[0], ShouldResemble, stored)
+		})
+
+		Convey("Re-fetching it with other derived fields updates them without counting as a change", func() {
+			fetched := stored
+			fetched.Author.Photo, fetched.AuthorID = "/avatars/3d4e5f.jpg", "b2"
+			merged, result := MergeMention([]Mention{stored}, fetched, Derived{})
+			So(result, ShouldEqual, Unchanged)
+			So(merged[0].Author.Photo, ShouldEqual, "/avatars/3d4e5f.jpg")
+			So(merged[0].AuthorID, ShouldEqual, "b2")
+		})
+
+		Convey("An edited source updates it and keeps the derived fields", func() {
+			fetched := Mention{WMID: 3, Author: Author{Name: "Ann"}, Content: Content{Text: "edited"}}
+			merged, result := MergeMention([]Mention{stored}, fetched, Derived{})
+			So(result, ShouldEqual, Updated)
+			So(merged[0].Content.Text, ShouldEqual, "edited")
+			So(merged[0].Author.Photo, ShouldEqual, "/avatars/0a1b2c.jpg")
+			So(merged[0].SpamScore, ShouldEqual, 20)
+			So(merged[0].AuthorID, ShouldEqual, "a1")
+		})
+
+		Convey("A spam score that dropped to 0 replaces the stored one when the scorer ran", func() {
+			fetched := stored
+			fetched.SpamScore, fetched.SpamSignals = 0, nil
+			merged, result := MergeMention([]Mention{stored}, fetched, Derived{Spam: true})
+			So(result, ShouldEqual, Unchanged)
+			So(merged[0].SpamScore, ShouldEqual, 0)
+			So(merged[0].SpamSignals, ShouldBeEmpty)
+		})
+
+		Convey("An excerpt that became empty replaces the stored one when the excerpt stage ran", func() {
+			fetched := stored
+			fetched.Excerpt = nil
+			merged, _ := MergeMention([]Mention{stored}, fetched, Derived{Excerpt: true})
+			So(merged[0].Excerpt, ShouldBeNil)
+			So(merged[0].SpamScore, ShouldEqual, 20)
+		})
+
+		Convey("A mention no longer hidden is shown when the moderator ran, and stays hidden otherwise", func() {
+			hidden := stored
+			hidden.Hidden = true
+			fetched := stored
+			merged, _ := MergeMention([]Mention{hidden}, fetched, Derived{Hidden: true})
+			So(merged[0].Hidden, ShouldBeFalse)
+
+			hidden.Hidden = true
+			merged, _ = MergeMention([]Mention{hidden}, fetched, Derived{})
+			So(merged[0].Hidden, ShouldBeTrue)
+		})
+	})
+}
+
 func TestLoadMentions(t *testing.T) {
 	Convey("Given a LoadMentions function with a mock file reader", t, func() {
 		mockReader := &MockFileReader{
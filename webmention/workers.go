@@ -3,8 +3,11 @@ package webmention
 import (
 	"context"
 	"fmt"
+	"maps"
+	"net/url"
 	"path/filepath"
 	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,6 +20,8 @@ type Saver interface {
 	Save(filepath string, mentions []Mention) error
 }
 
+var SaveThreadsFunc = SaveThreads
+
 var LoadFunc = LoadMentions
 
 type Loader interface {
@@ -24,14 +29,26 @@ type Loader interface {
 }
 
 type FetchWorker struct {
+	// Bus receives a PageFetched per page and a MentionReceived per mention. A private bus is created when nil, so
+	// set it before adding observers.
+	Bus       *Bus
 	observers []MentionObserver
 }
 
+// AddObserver subscribes observer to the mentions the worker receives.
 func (fetchWorker *FetchWorker) AddObserver(observer MentionObserver) {
 	if !slices.Contains(fetchWorker.observers, observer) {
 		log.Debug("Adding observer")
 		fetchWorker.observers = append(fetchWorker.observers, observer)
+		fetchWorker.bus().Subscribe(ReceivedMentions(observer))
+	}
+}
+
+func (fetchWorker *FetchWorker) bus() *Bus {
+	if fetchWorker.Bus == nil {
+		fetchWorker.Bus = &Bus{}
 	}
+	return fetchWorker.Bus
 }
 
 type Fetchable interface {
@@ -49,11 +66,14 @@ func (fetchWorker *FetchWorker) DoFetch(ctx context.Context, client Getter, ment
 		log.Debug("finished fetch worker")
 		close(mentionChannel)
 	}()
+	bus := fetchWorker.bus()
+	page := 0
 
 	result, err := client.GetMentions()
 	if err != nil {
 		return fmt.Errorf("error getting mentions: %v", err.Error())
 	}
+	bus.Publish(PageFetched{Domain: bus.Domain, Page: page, Mentions: len(result.Children)})
 	for len(result.Children) > 0 {
 		select {
 		case <-ctx.Done():
@@ -61,7 +81,7 @@ func (fetchWorker *FetchWorker) DoFetch(ctx context.Context, client Getter, ment
 		default:
 			// Send mentions to the channel, or return error if unable to proceed
 			for _, child := range result.Children {
-				fetchWorker.updateObservers(child)
+				bus.Publish(MentionReceived{Domain: bus.Domain, Mention: child})
 				select {
 				case mentionChannel <- child:
 				case <-ctx.Done():
@@ -73,83 +93,171 @@ func (fetchWorker *FetchWorker) DoFetch(ctx context.Context, client Getter, ment
 			if err != nil {
 				return fmt.Errorf("error getting mentions: %w", err)
 			}
+			page++
+			bus.Publish(PageFetched{Domain: bus.Domain, Page: page, Mentions: len(result.Children)})
 		}
 	}
 	return nil
 }
 
-func (fetchWorker *FetchWorker) updateObservers(child Mention) {
-	for _, observer := range fetchWorker.observers {
-		observer.Update(child)
-	}
-}
-
 func (fetchWorker *FetchWorker) AddObservers(observers []MentionObserver) {
 	for _, observer := range observers {
 		fetchWorker.AddObserver(observer)
 	}
 }
 
+// MetricsResponse is a snapshot of a MetricsObserver. The breakdowns count unique mentions by wm-id.
 type MetricsResponse struct {
 	MaxID            int
 	EarliestReceived time.Time
 	LatestReceived   time.Time
-	AllSenders       []string
-	MentionsSeen     int
-	UniqueMentions   []int
+	// AllSenders and UniqueMentions are in the order they were first seen.
+	AllSenders     []string
+	MentionsSeen   int
+	UniqueMentions []int
+	ByTarget       map[string]int
+	ByProperty     map[string]int
+	BySourceHost   map[string]int
+	ByAuthor       map[string]int
+	ByNetwork      map[string]int
+}
+
+// Count is one entry of a breakdown.
+type Count struct {
+	Key   string
+	Count int
 }
 
+// TopN returns the n largest entries of counts, largest first and ties by key. n < 0 returns every entry.
+func TopN(counts map[string]int, n int) []Count {
+	top := make([]Count, 0, len(counts))
+	for key, count := range counts {
+		top = append(top, Count{Key: key, Count: count})
+	}
+	slices.SortFunc(top, func(a, b Count) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	if n >= 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// MetricsObserver keeps running metrics about the mentions it observes. Its zero value is ready to use.
 type MetricsObserver struct {
-	MaxID            int
-	EarliestReceived time.Time
-	LatestReceived   time.Time
-	AllSenders       []string
-	MentionsSeen     int
-	UniqueMentions   []int
 	mu               sync.RWMutex
+	maxID            int
+	earliestReceived time.Time
+	latestReceived   time.Time
+	mentionsSeen     int
+	senders          []string
+	senderSet        map[string]struct{}
+	uniqueMentions   []int
+	uniqueSet        map[int]struct{}
+	byTarget         map[string]int
+	byProperty       map[string]int
+	bySourceHost     map[string]int
+	byAuthor         map[string]int
+	byNetwork        map[string]int
 }
 
-// Update performs the necessary operations on an observed mention to main it's set of metrics.
+// Update performs the necessary operations on an observed mention to maintain its set of metrics. A mention seen
+// again only increases MentionsSeen. Private mentions are skipped, so their targets and authors never show in the
+// logged metrics.
 func (m *MetricsObserver) Update(mention Mention) {
+	if mention.WMPrivate {
+		return
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	log.Info("Observing mention", "WMID", mention.WMID)
-
-	if m.MaxID == 0 || mention.WMID > m.MaxID {
-		m.MaxID = mention.WMID
+	log.Debug("Observing mention", "WMID", mention.WMID)
+	if m.senderSet == nil {
+		m.senderSet = map[string]struct{}{}
+		m.uniqueSet = map[int]struct{}{}
+		m.byTarget = map[string]int{}
+		m.byProperty = map[string]int{}
+		m.bySourceHost = map[string]int{}
+		m.byAuthor = map[string]int{}
+		m.byNetwork = map[string]int{}
 	}
+	m.mentionsSeen++
 
-	if m.EarliestReceived.Equal(time.Time{}) || m.EarliestReceived.After(mention.WMReceived) {
-		m.EarliestReceived = mention.WMReceived
+	// Synthetic wm-ids are negative and never the maximum.
+	if mention.WMID > m.maxID {
+		m.maxID = mention.WMID
+	}
+	if m.earliestReceived.IsZero() || m.earliestReceived.After(mention.WMReceived) {
+		m.earliestReceived = mention.WMReceived
+	}
+	if m.latestReceived.IsZero() || m.latestReceived.Before(mention.WMReceived) {
+		m.latestReceived = mention.WMReceived
+	}
+	if _, ok := m.senderSet[mention.WMSource]; !ok {
+		m.senderSet[mention.WMSource] = struct{}{}
+		m.senders = append(m.senders, mention.WMSource)
 	}
 
-	if m.LatestReceived.Equal(time.Time{}) || m.LatestReceived.Before(mention.WMReceived) {
-		m.LatestReceived = mention.WMReceived
+	if _, ok := m.uniqueSet[mention.WMID]; ok {
+		return
 	}
+	m.uniqueSet[mention.WMID] = struct{}{}
+	m.uniqueMentions = append(m.uniqueMentions, mention.WMID)
+	m.byTarget[mention.WMTarget]++
+	m.byProperty[mention.WMProperty]++
+	m.bySourceHost[hostOf(mention.WMSource)]++
+	m.byAuthor[authorKey(mention.Author)]++
+	m.byNetwork[ClassifyOrigin(mention).Network]++
+}
 
-	if !slices.Contains(m.AllSenders, mention.WMSource) {
-		m.AllSenders = append(m.AllSenders, mention.WMSource)
+func hostOf(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return "unknown"
 	}
+	return strings.ToLower(parsed.Hostname())
+}
 
-	if !slices.Contains(m.UniqueMentions, mention.WMID) {
-		m.UniqueMentions = append(m.UniqueMentions, mention.WMID)
+// authorKey identifies an author by URL, falling back to their name.
+func authorKey(author Author) string {
+	switch {
+	case author.URL != "":
+		return author.URL
+	case author.Name != "":
+		return author.Name
+	default:
+		return "unknown"
 	}
+}
 
-	m.MentionsSeen++
+// cloneCounts copies counts, returning an empty map rather than nil so callers may add to the copy.
+func cloneCounts(counts map[string]int) map[string]int {
+	if counts == nil {
+		return map[string]int{}
+	}
+	return maps.Clone(counts)
 }
 
+// GetMetrics returns a snapshot of the metrics that later updates don't change.
 func (m *MetricsObserver) GetMetrics() MetricsResponse {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	return MetricsResponse{
-		MaxID:            m.MaxID,
-		EarliestReceived: m.EarliestReceived,
-		LatestReceived:   m.LatestReceived,
-		AllSenders:       m.AllSenders,
-		MentionsSeen:     m.MentionsSeen,
-		UniqueMentions:   m.UniqueMentions,
+		MaxID:            m.maxID,
+		EarliestReceived: m.earliestReceived,
+		LatestReceived:   m.latestReceived,
+		AllSenders:       slices.Clone(m.senders),
+		MentionsSeen:     m.mentionsSeen,
+		UniqueMentions:   slices.Clone(m.uniqueMentions),
+		ByTarget:         cloneCounts(m.byTarget),
+		ByProperty:       cloneCounts(m.byProperty),
+		BySourceHost:     cloneCounts(m.bySourceHost),
+		ByAuthor:         cloneCounts(m.byAuthor),
+		ByNetwork:        cloneCounts(m.byNetwork),
 	}
 }
 
@@ -157,14 +265,69 @@ type MentionObserver interface {
 	Update(mention Mention)
 }
 
+// DefaultDestination is the directory mentions are persisted to when a PersistenceWorker has no Destination.
+const DefaultDestination = "data/webmentions"
+
 type PersistenceWorker struct {
+	// Destination is the directory holding one JSON file of mentions per target.
+	Destination string
+	// WriteThreads additionally saves each target's mentions as nested conversation trees next to the flat file.
+	WriteThreads bool
+	// Derived are the derived fields the pipeline the mentions came through sets, taken from them when merging.
+	Derived Derived
+	// Bus receives a TargetPersisted per saved target. A private bus is created when nil, so set it before adding
+	// observers.
+	Bus *Bus
+	// observers are the ones added by AddObserver, subscribed to the Bus through PersistedMentions; they are told
+	// about every persisted mention, including updated and unchanged ones.
 	observers []MentionObserver
+	stats     []PersistStats
+	mu        sync.Mutex
+}
+
+// PersistStats summarises what persisting one target's mentions changed.
+type PersistStats struct {
+	Target string
+	Path   string
+	// Persisted are all the mentions persisted for the target; New and Updated are those that changed its file.
+	Persisted []Mention
+	// ThreadsPath is the conversation threads file written next to Path, empty when threads weren't written.
+	ThreadsPath string
+	New         []Mention
+	Updated     []Mention
+	Unchanged   int
+}
+
+// Changed reports whether any mention was added or updated.
+func (p PersistStats) Changed() bool {
+	return len(p.New) > 0 || len(p.Updated) > 0
+}
+
+// Stats returns the outcome of every successful DoPersist call so far.
+func (w *PersistenceWorker) Stats() []PersistStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]PersistStats(nil), w.stats...)
 }
 
+// AddObserver subscribes observer to every mention the worker persists, whether or not it changed the stored ones.
 func (w *PersistenceWorker) AddObserver(observer MentionObserver) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	if !slices.Contains(w.observers, observer) {
 		w.observers = append(w.observers, observer)
+		w.bus().Subscribe(PersistedMentions(observer))
+	}
+}
+
+// bus returns the worker's Bus, creating it when nil. The caller holds w.mu.
+func (w *PersistenceWorker) bus() *Bus {
+	if w.Bus == nil {
+		w.Bus = &Bus{}
 	}
+	return w.Bus
 }
 
 type Persistable interface {
@@ -187,7 +350,7 @@ func (w *PersistenceWorker) DoPersist(fetchedMentions []Mention, s *sync.WaitGro
 	}
 
 	slug, err := fetchedMentions[0].GenerateSlug()
-	filePath := filepath.Join("data", "webmentions", fmt.Sprintf("%s.json", slug))
+	filePath := filepath.Join(w.destination(), fmt.Sprintf("%s.json", slug))
 
 	// Save or update the webmention
 	previouslyRetrievedMentions, err := LoadFunc(filePath)
@@ -195,10 +358,20 @@ func (w *PersistenceWorker) DoPersist(fetchedMentions []Mention, s *sync.WaitGro
 		return fmt.Errorf("failed to save webmention: %v", err)
 	}
 
+	stats := PersistStats{Target: fetchedMentions[0].WMTarget, Path: filePath}
 	for _, m := range fetchedMentions {
 		log.Debugf("Inserting mention %d", m.WMID)
-		previouslyRetrievedMentions = InsertMention(previouslyRetrievedMentions, m)
-		w.updateObservers(m)
+		var result MergeResult
+		previouslyRetrievedMentions, result = MergeMention(previouslyRetrievedMentions, m, w.Derived)
+		stats.Persisted = append(stats.Persisted, m)
+		switch result {
+		case Inserted:
+			stats.New = append(stats.New, m)
+		case Updated:
+			stats.Updated = append(stats.Updated, m)
+		default:
+			stats.Unchanged++
+		}
 	}
 
 	log.Infof("Saving %d mentions to %s", len(previouslyRetrievedMentions), filePath)
@@ -207,11 +380,28 @@ func (w *PersistenceWorker) DoPersist(fetchedMentions []Mention, s *sync.WaitGro
 		return fmt.Errorf("failed to save webmention: %v", err)
 
 	}
+
+	if w.WriteThreads {
+		threadsPath := filepath.Join(w.destination(), slug+ThreadsSuffix)
+		log.Infof("Saving conversation threads to %s", threadsPath)
+		if err := SaveThreadsFunc(threadsPath, BuildThreads(previouslyRetrievedMentions)); err != nil {
+			return fmt.Errorf("failed to save threads: %v", err)
+		}
+		stats.ThreadsPath = threadsPath
+	}
+
+	w.mu.Lock()
+	w.stats = append(w.stats, stats)
+	bus := w.bus()
+	w.mu.Unlock()
+
+	bus.Publish(TargetPersisted{Domain: bus.Domain, Stats: stats})
 	return nil
 }
 
-func (w *PersistenceWorker) updateObservers(mention Mention) {
-	for _, o := range w.observers {
-		o.Update(mention)
+func (w *PersistenceWorker) destination() string {
+	if w.Destination == "" {
+		return DefaultDestination
 	}
+	return w.Destination
 }
@@ -190,7 +190,7 @@ func TestFetchWorker_UpdateAll(t *testing.T) {
 		fetchWorker.AddObserver(&observer)
 
 		for _, mention := range mockMentions {
-			fetchWorker.updateObservers(mention)
+			fetchWorker.Bus.Publish(MentionReceived{Mention: mention})
 		}
 
 		// Test that all expected observations were made
@@ -263,6 +263,12 @@ func TestPersistenceWorker_Do(t *testing.T) {
 			Convey("It should not error", func() {
 				So(err, ShouldBeNil)
 			})
+			Convey("It should record what changed", func() {
+				stats := persistenceWorker.Stats()
+				So(stats, ShouldHaveLength, 1)
+				So(len(stats[0].New)+len(stats[0].Updated)+stats[0].Unchanged, ShouldEqual, len(fetchedMentions))
+				So(stats[0].Target, ShouldEqual, fetchedMentions[0].WMTarget)
+			})
 			// Verify SaveFunc was called with expected mentions
 			Convey("It should call SaveFunc with the correct data", func() {
 				So(saveMock.savedMentions, ShouldNotBeNil)
@@ -270,6 +276,44 @@ func TestPersistenceWorker_Do(t *testing.T) {
 			})
 		})
 
+		Convey("Observers are told about every persisted mention, stored before or not", func() {
+			stored := Mention{WMID: 100, WMTarget: "https://example.com/post"}
+			fresh := Mention{WMID: 101, WMTarget: "https://example.com/post"}
+			loadMentionsMock.wantedMentions = []Mention{stored}
+			var observer MetricsObserver
+			persistenceWorker.AddObserver(&observer)
+
+			var err error
+			go func() {
+				err = persistenceWorker.DoPersist([]Mention{stored, fresh}, &wg)
+			}()
+			wg.Wait()
+
+			So(err, ShouldBeNil)
+			So(observer.GetMetrics().UniqueMentions, ShouldResemble, []int{100, 101})
+		})
+
+		Convey("When DoPersist is asked to write threads", func() {
+			var savedThreads []*Thread
+			var threadsPath string
+			SaveThreadsFunc = func(filepath string, threads []*Thread) error {
+				threadsPath = filepath
+				savedThreads = threads
+				return nil
+			}
+			persistenceWorker := PersistenceWorker{WriteThreads: true}
+
+			var err error
+			go func() {
+				err = persistenceWorker.DoPersist(fetchedMentions, &wg)
+			}()
+			wg.Wait()
+
+			So(err, ShouldBeNil)
+			So(threadsPath, ShouldEndWith, ".threads.json")
+			So(savedThreads, ShouldNotBeEmpty)
+		})
+
 		Convey("When LoadMentions returns an error, it should bubble up", func() {
 			loadMentionsMock.wantedErr = fmt.Errorf("load error")
 			loadMentionsMock.wantedMentions = []Mention{
@@ -358,65 +402,149 @@ func TestMetricsObserver_Update(t *testing.T) {
 	Convey("Given a MetricsObserver", t, func() {
 		var metricsObserver MetricsObserver
 		Convey("values should initialize in an expected way", func() {
-			So(metricsObserver, ShouldNotBeNil)
-			So(metricsObserver.MaxID, ShouldEqual, 0)
-			So(metricsObserver.EarliestReceived, ShouldEqual, time.Time{})
-			So(metricsObserver.LatestReceived, ShouldEqual, time.Time{})
-			So(metricsObserver.AllSenders, ShouldResemble, []string(nil))
-			So(len(metricsObserver.UniqueMentions), ShouldEqual, 0)
-			So(metricsObserver.MentionsSeen, ShouldEqual, 0)
+			metrics := metricsObserver.GetMetrics()
+			So(metrics.MaxID, ShouldEqual, 0)
+			So(metrics.EarliestReceived, ShouldEqual, time.Time{})
+			So(metrics.LatestReceived, ShouldEqual, time.Time{})
+			So(metrics.AllSenders, ShouldResemble, []string(nil))
+			So(len(metrics.UniqueMentions), ShouldEqual, 0)
+			So(metrics.MentionsSeen, ShouldEqual, 0)
+			So(metrics.ByTarget, ShouldBeEmpty)
 		})
 		Convey("updating", func() {
 			now := time.Now()
 			mention1 := Mention{
 				WMID:       10,
 				WMReceived: now,
-				WMSource:   "https://mention1.net",
+				WMSource:   "https://mention1.net/post",
+				WMTarget:   "https://example.com/a",
+				WMProperty: "like-of",
+				Author:     Author{Name: "Ada", URL: "https://ada.example"},
 			}
 
 			mention2 := Mention{
 				WMID:       20,
 				WMReceived: now.Add(24 * time.Hour),
-				WMSource:   "https://mention2.net",
+				WMSource:   "https://mention2.net/post",
+				WMTarget:   "https://example.com/a",
+				WMProperty: "in-reply-to",
+				Author:     Author{Name: "Grace"},
 			}
 			Convey("updating once should set all of the values", func() {
-
 				metricsObserver.Update(mention1)
-				So(metricsObserver, ShouldNotBeNil)
-				So(metricsObserver.MaxID, ShouldEqual, mention1.WMID)
-				So(metricsObserver.EarliestReceived, ShouldEqual, mention1.WMReceived)
-				So(metricsObserver.LatestReceived, ShouldEqual, mention1.WMReceived)
-				So(metricsObserver.AllSenders, ShouldResemble, []string{mention1.WMSource})
-				So(len(metricsObserver.UniqueMentions), ShouldEqual, 1)
-				So(metricsObserver.MentionsSeen, ShouldEqual, 1)
+				metrics := metricsObserver.GetMetrics()
+				So(metrics.MaxID, ShouldEqual, mention1.WMID)
+				So(metrics.EarliestReceived, ShouldEqual, mention1.WMReceived)
+				So(metrics.LatestReceived, ShouldEqual, mention1.WMReceived)
+				So(metrics.AllSenders, ShouldResemble, []string{mention1.WMSource})
+				So(len(metrics.UniqueMentions), ShouldEqual, 1)
+				So(metrics.MentionsSeen, ShouldEqual, 1)
 			})
 
 			Convey("updating twice should update the values as expected", func() {
 				metricsObserver.Update(mention1)
 				metricsObserver.Update(mention2)
-
-				So(metricsObserver, ShouldNotBeNil)
-				So(metricsObserver.MaxID, ShouldEqual, mention2.WMID)
-				So(metricsObserver.EarliestReceived, ShouldEqual, mention1.WMReceived)
-				So(metricsObserver.LatestReceived, ShouldEqual, mention2.WMReceived)
-				So(metricsObserver.AllSenders, ShouldResemble, []string{mention1.WMSource, mention2.WMSource})
-				So(len(metricsObserver.UniqueMentions), ShouldEqual, 2)
-				So(metricsObserver.MentionsSeen, ShouldEqual, 2)
+				metrics := metricsObserver.GetMetrics()
+
+				So(metrics.MaxID, ShouldEqual, mention2.WMID)
+				So(metrics.EarliestReceived, ShouldEqual, mention1.WMReceived)
+				So(metrics.LatestReceived, ShouldEqual, mention2.WMReceived)
+				So(metrics.AllSenders, ShouldResemble, []string{mention1.WMSource, mention2.WMSource})
+				So(len(metrics.UniqueMentions), ShouldEqual, 2)
+				So(metrics.MentionsSeen, ShouldEqual, 2)
 			})
 
 			Convey("updating the same mention again should update counts but not values or observations", func() {
 				metricsObserver.Update(mention1)
 				metricsObserver.Update(mention2)
 				metricsObserver.Update(mention2)
+				metrics := metricsObserver.GetMetrics()
+
+				So(metrics.MaxID, ShouldEqual, mention2.WMID)
+				So(metrics.EarliestReceived, ShouldEqual, mention1.WMReceived)
+				So(metrics.LatestReceived, ShouldEqual, mention2.WMReceived)
+				So(metrics.AllSenders, ShouldResemble, []string{mention1.WMSource, mention2.WMSource})
+				So(len(metrics.UniqueMentions), ShouldEqual, 2)
+				So(metrics.MentionsSeen, ShouldEqual, 3)
+				So(metrics.ByTarget, ShouldResemble, map[string]int{"https://example.com/a": 2})
+			})
+
+			Convey("unique mentions are broken down by property, source host and author", func() {
+				metricsObserver.Update(mention1)
+				metricsObserver.Update(mention2)
+				metrics := metricsObserver.GetMetrics()
+
+				So(metrics.ByProperty, ShouldResemble, map[string]int{"like-of": 1, "in-reply-to": 1})
+				So(metrics.BySourceHost, ShouldResemble, map[string]int{"mention1.net": 1, "mention2.net": 1})
+				So(metrics.ByAuthor, ShouldResemble, map[string]int{"https://ada.example": 1, "Grace": 1})
+				So(metrics.ByNetwork, ShouldResemble, map[string]int{"native": 2})
+			})
+
+			Convey("private mentions are not observed", func() {
+				private := mention2
+				private.WMPrivate = true
+				metricsObserver.Update(mention1)
+				metricsObserver.Update(private)
+				metrics := metricsObserver.GetMetrics()
+
+				So(metrics.MentionsSeen, ShouldEqual, 1)
+				So(metrics.AllSenders, ShouldResemble, []string{mention1.WMSource})
+				So(metrics.ByAuthor, ShouldResemble, map[string]int{"https://ada.example": 1})
+			})
+
+			Convey("the metrics returned are not changed by later updates", func() {
+				metricsObserver.Update(mention1)
+				metrics := metricsObserver.GetMetrics()
+				metricsObserver.Update(mention2)
 
-				So(metricsObserver, ShouldNotBeNil)
-				So(metricsObserver.MaxID, ShouldEqual, mention2.WMID)
-				So(metricsObserver.EarliestReceived, ShouldEqual, mention1.WMReceived)
-				So(metricsObserver.LatestReceived, ShouldEqual, mention2.WMReceived)
-				So(metricsObserver.AllSenders, ShouldResemble, []string{mention1.WMSource, mention2.WMSource})
-				So(len(metricsObserver.UniqueMentions), ShouldEqual, 2)
-				So(metricsObserver.MentionsSeen, ShouldEqual, 3)
+				So(metrics.AllSenders, ShouldHaveLength, 1)
+				So(metrics.UniqueMentions, ShouldHaveLength, 1)
+				So(metrics.ByProperty, ShouldHaveLength, 1)
 			})
 		})
 	})
 }
+
+func TestMetricsObserver_Concurrent(t *testing.T) {
+	Convey("Updates and snapshots may run concurrently", t, func() {
+		var metricsObserver MetricsObserver
+		var wg sync.WaitGroup
+		for i := range 4 {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := range 250 {
+					metricsObserver.Update(Mention{WMID: i*250 + j, WMTarget: fmt.Sprintf("https://example.com/%d", j%10)})
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for range 50 {
+					metrics := metricsObserver.GetMetrics()
+					metrics.ByTarget["mutated"]++
+				}
+			}()
+		}
+		wg.Wait()
+
+		metrics := metricsObserver.GetMetrics()
+		So(metrics.UniqueMentions, ShouldHaveLength, 1000)
+		So(metrics.ByTarget, ShouldNotContainKey, "mutated")
+		So(TopN(metrics.ByTarget, 1)[0].Count, ShouldEqual, 100)
+	})
+}
+
+func TestTopN(t *testing.T) {
+	Convey("Given a breakdown", t, func() {
+		counts := map[string]int{"a": 1, "b": 3, "c": 3, "d": 2}
+
+		Convey("The largest entries come first, ties ordered by key", func() {
+			So(TopN(counts, 3), ShouldResemble, []Count{{"b", 3}, {"c", 3}, {"d", 2}})
+		})
+
+		Convey("A negative or too large n returns every entry", func() {
+			So(TopN(counts, -1), ShouldHaveLength, 4)
+			So(TopN(counts, 10), ShouldHaveLength, 4)
+		})
+	})
+}